@@ -0,0 +1,148 @@
+package articles
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/realtime"
+	"realworld-backend/users"
+)
+
+// Topic of the realtime messages of an article, streamed by ArticleEvents.
+func articleTopic(articleID uint) string {
+	return "articles/" + strconv.FormatUint(uint64(articleID), 10)
+}
+
+// Streams are public, their payloads are serialized as seen by an anonymous user. ArticleEvents
+// leaves out the messages of the users hidden from each subscriber.
+func anonymousContext() *gin.Context {
+	c := &gin.Context{}
+	c.Set("my_user_model", users.UserModel{})
+	return c
+}
+
+// Forward comments and favorites to the realtime streams of their article, and new articles and
+// mentions to the users concerned, call once at startup.
+func SubscribeEvents() {
+	events.Subscribe("articles.stream", func(event events.CommentCreated) error {
+		commentModel, err := FindOneComment(event.CommentID)
+		if err != nil {
+			return err
+		}
+		serializer := CommentSerializer{anonymousContext(), commentModel}
+		return realtime.PublishFrom(articleTopic(event.ArticleID), "comment.created", event.AuthorID, gin.H{"comment": serializer.Response()})
+	})
+	events.Subscribe("articles.stream", func(event events.CommentDeleted) error {
+		return realtime.PublishFrom(articleTopic(event.ArticleID), "comment.deleted", event.AuthorID, gin.H{"comment": gin.H{"id": event.CommentID}})
+	})
+	events.Subscribe("articles.stream", func(event events.ArticleFavorited) error {
+		return publishFavoritesChanged(event.ArticleID)
+	})
+	events.Subscribe("articles.stream", func(event events.ArticleUnfavorited) error {
+		return publishFavoritesChanged(event.ArticleID)
+	})
+
+	// New articles and mentions go to the WebSocket connections of the users concerned.
+	events.Subscribe("articles.push", func(event events.ArticleCreated) error {
+		return publishFeedUpdate(event.ArticleID)
+	})
+	events.Subscribe("articles.push", func(event events.UserMentioned) error {
+		return publishMention(event)
+	})
+}
+
+// What a feed needs to show a new article before it is fetched, the body stays out of the push.
+type FeedUpdateResponse struct {
+	Slug        string                `json:"slug"`
+	Title       string                `json:"title"`
+	Description string                `json:"description"`
+	Excerpt     string                `json:"excerpt"`
+	CreatedAt   string                `json:"createdAt"`
+	Author      users.ProfileResponse `json:"author"`
+	Tags        []string              `json:"tagList"`
+}
+
+// Tell the followers of the author and of the article's tags that their feed has a new article,
+// except those who muted or are blocked with the author.
+func publishFeedUpdate(articleID uint) error {
+	articleModel, err := FindOneArticle(context.Background(), &ArticleModel{Model: gorm.Model{ID: articleID}})
+	if err != nil || articleModel.ID == 0 {
+		return err
+	}
+	author := articleModel.Author.UserModel
+	update := FeedUpdateResponse{
+		Slug:        articleModel.Slug,
+		Title:       articleModel.Title,
+		Description: articleModel.Description,
+		Excerpt:     articleModel.Excerpt,
+		CreatedAt:   articleModel.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		Author:      users.ProfileResponse{ID: author.ID, Username: author.Username, Bio: author.Bio, Image: author.Image},
+		Tags:        []string{},
+	}
+	for _, tag := range articleModel.Tags {
+		update.Tags = append(update.Tags, tag.Tag)
+	}
+
+	hidden := map[uint]bool{author.ID: true}
+	for _, id := range author.HidingUserIDs(context.Background()) {
+		hidden[id] = true
+	}
+	for _, userID := range feedRecipients(articleModel) {
+		if hidden[userID] {
+			continue
+		}
+		hidden[userID] = true
+		if err := realtime.Publish(realtime.UserTopic(userID), "feed.update", gin.H{"article": update}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UserModel ids of the followers of the author and of the tags of `article`.
+func feedRecipients(article ArticleModel) []uint {
+	ids := article.Author.UserModel.FollowerIDs()
+	tagIDs := make([]uint, 0, len(article.Tags))
+	for _, tag := range article.Tags {
+		tagIDs = append(tagIDs, tag.ID)
+	}
+	if len(tagIDs) == 0 {
+		return ids
+	}
+	var tagFollowerIDs []uint
+	common.GetDB().Model(&TagFollowModel{}).
+		Joins("JOIN article_user_models ON article_user_models.id = tag_follow_models.followed_by_id").
+		Where("tag_follow_models.tag_id IN (?)", tagIDs).
+		Pluck("article_user_models.user_model_id", &tagFollowerIDs)
+	return append(ids, tagFollowerIDs...)
+}
+
+// Tell a mentioned user where they were mentioned, unless they muted the author.
+func publishMention(event events.UserMentioned) error {
+	for _, id := range (users.UserModel{ID: event.UserID}).HiddenUserIDs(context.Background()) {
+		if id == event.AuthorID {
+			return nil
+		}
+	}
+	articleModel, err := FindOneArticle(context.Background(), &ArticleModel{Model: gorm.Model{ID: event.ArticleID}})
+	if err != nil || articleModel.ID == 0 {
+		return err
+	}
+	var author users.UserModel
+	common.GetDB().First(&author, event.AuthorID)
+	return realtime.Publish(realtime.UserTopic(event.UserID), "mention", gin.H{
+		"article":   gin.H{"slug": articleModel.Slug, "title": articleModel.Title},
+		"commentId": event.CommentID,
+		"author":    users.ProfileResponse{ID: author.ID, Username: author.Username, Bio: author.Bio, Image: author.Image},
+	})
+}
+
+func publishFavoritesChanged(articleID uint) error {
+	articleModel := ArticleModel{}
+	articleModel.ID = articleID
+	return realtime.Publish(articleTopic(articleID), "favorites.changed", gin.H{"favoritesCount": articleModel.favoritesCount(context.Background())})
+}
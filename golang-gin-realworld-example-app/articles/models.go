@@ -1,11 +1,17 @@
 package articles
 
 import (
+	"context"
+	"errors"
 	_ "fmt"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/metrics"
 	"realworld-backend/users"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ArticleModel struct {
@@ -13,13 +19,54 @@ type ArticleModel struct {
 	Slug        string `gorm:"unique_index"`
 	Title       string
 	Description string `gorm:"size:2048"`
-	Body        string `gorm:"size:2048"`
+	Body        string `gorm:"type:text"`
+	WordCount   int
+	ReadingTime int    // minutes
+	Excerpt     string `gorm:"size:512"` // only filled when Description is empty
+	CoverImage  string `gorm:"size:1024"`
 	Author      ArticleUserModel
 	AuthorID    uint
 	Tags        []TagModel     `gorm:"many2many:article_tags;"`
 	Comments    []CommentModel `gorm:"ForeignKey:ArticleID"`
 }
 
+// Average silent reading speed used for ReadingTime, and the length of generated excerpts.
+const (
+	wordsPerMinute = 200
+	excerptLength  = 200
+)
+
+// Compute WordCount, ReadingTime and Excerpt from Body, they are stored so lists don't need the bodies.
+func (model *ArticleModel) setReadingStats() {
+	text := common.MarkdownToText(model.Body)
+	words := strings.Fields(text)
+	model.WordCount = len(words)
+	model.ReadingTime = (model.WordCount + wordsPerMinute - 1) / wordsPerMinute
+	model.Excerpt = ""
+	if model.Description == "" {
+		model.Excerpt = makeExcerpt(text, excerptLength)
+	}
+}
+
+// Cut plain text at the last word boundary before `length` runes.
+func makeExcerpt(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	cut := string(runes[:length])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// gorm callback, keeps the reading stats in sync whenever an article is created or saved.
+func (model *ArticleModel) BeforeSave() error {
+	model.setReadingStats()
+	return nil
+}
+
 type ArticleUserModel struct {
 	gorm.Model
 	UserModel      users.UserModel
@@ -36,10 +83,22 @@ type FavoriteModel struct {
 	FavoriteByID uint
 }
 
+// Tags are stored normalized by normalizeTag so "Go" and "go " are the same tag.
 type TagModel struct {
 	gorm.Model
 	Tag           string         `gorm:"unique_index"`
+	Description   string         `gorm:"size:1024"`
 	ArticleModels []ArticleModel `gorm:"many2many:article_tags;"`
+	ArticlesCount int            `gorm:"-"` // filled by getAllTags and FindOneTag
+}
+
+// A user following a tag, GetArticleFeed then includes every article with that tag.
+type TagFollowModel struct {
+	gorm.Model
+	Tag          TagModel
+	TagID        uint
+	FollowedBy   ArticleUserModel
+	FollowedByID uint
 }
 
 type CommentModel struct {
@@ -48,15 +107,24 @@ type CommentModel struct {
 	ArticleID uint
 	Author    ArticleUserModel
 	AuthorID  uint
-	Body      string `gorm:"size:2048"`
+	Body      string `gorm:"type:text"`
 }
 
-func GetArticleUserModel(userModel users.UserModel) ArticleUserModel {
+// A user mentioned with `@username` in the body of an article, or of one of its comments when
+// CommentID is set.
+type MentionModel struct {
+	gorm.Model
+	ArticleID uint `gorm:"index"`
+	CommentID uint
+	UserID    uint
+}
+
+func GetArticleUserModel(ctx context.Context, userModel users.UserModel) ArticleUserModel {
 	var articleUserModel ArticleUserModel
 	if userModel.ID == 0 {
 		return articleUserModel
 	}
-	db := common.GetDB()
+	db := common.GetDBContext(ctx)
 	db.Where(&ArticleUserModel{
 		UserModelID: userModel.ID,
 	}).FirstOrCreate(&articleUserModel)
@@ -64,8 +132,8 @@ func GetArticleUserModel(userModel users.UserModel) ArticleUserModel {
 	return articleUserModel
 }
 
-func (article ArticleModel) favoritesCount() uint {
-	db := common.GetDB()
+func (article ArticleModel) favoritesCount(ctx context.Context) uint {
+	db := common.GetDBContext(ctx)
 	var count uint
 	db.Model(&FavoriteModel{}).Where(FavoriteModel{
 		FavoriteID: article.ID,
@@ -73,8 +141,8 @@ func (article ArticleModel) favoritesCount() uint {
 	return count
 }
 
-func (article ArticleModel) isFavoriteBy(user ArticleUserModel) bool {
-	db := common.GetDB()
+func (article ArticleModel) isFavoriteBy(ctx context.Context, user ArticleUserModel) bool {
+	db := common.GetDBContext(ctx)
 	var favorite FavoriteModel
 	db.Where(FavoriteModel{
 		FavoriteID:   article.ID,
@@ -83,33 +151,107 @@ func (article ArticleModel) isFavoriteBy(user ArticleUserModel) bool {
 	return favorite.ID != 0
 }
 
-func (article ArticleModel) favoriteBy(user ArticleUserModel) error {
-	db := common.GetDB()
-	var favorite FavoriteModel
-	err := db.FirstOrCreate(&favorite, &FavoriteModel{
+func (article ArticleModel) favoriteBy(ctx context.Context, user ArticleUserModel) error {
+	db := common.GetDBContext(ctx)
+	var author ArticleUserModel
+	db.First(&author, article.AuthorID)
+	if users.BlockedBetween(ctx, author.UserModelID, user.UserModelID) {
+		return users.ErrBlocked
+	}
+	if article.isFavoriteBy(ctx, user) {
+		return nil
+	}
+	err := SaveOne(&FavoriteModel{
 		FavoriteID:   article.ID,
 		FavoriteByID: user.ID,
-	}).Error
+	}, func() events.Event {
+		return events.ArticleFavorited{ArticleID: article.ID, AuthorID: author.UserModelID, UserID: user.UserModelID}
+	})
+	if err == nil {
+		metrics.Favorites.Inc()
+	}
 	return err
 }
 
-func (article ArticleModel) unFavoriteBy(user ArticleUserModel) error {
-	db := common.GetDB()
-	err := db.Where(FavoriteModel{
+func (article ArticleModel) unFavoriteBy(ctx context.Context, user ArticleUserModel) error {
+	db := common.GetDBContext(ctx)
+	var author ArticleUserModel
+	db.First(&author, article.AuthorID)
+	tx := db.Begin()
+	result := tx.Where(FavoriteModel{
 		FavoriteID:   article.ID,
 		FavoriteByID: user.ID,
-	}).Delete(FavoriteModel{}).Error
-	return err
+	}).Delete(FavoriteModel{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		tx.Rollback()
+		return result.Error
+	}
+	eventID, err := events.Record(tx, events.ArticleUnfavorited{ArticleID: article.ID, AuthorID: author.UserModelID, UserID: user.UserModelID})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	events.Dispatch(eventID)
+	return nil
 }
 
-func SaveOne(data interface{}) error {
-	db := common.GetDB()
-	err := db.Save(data).Error
-	return err
+// Save `data`, and record the events `raise` makes of it in the same transaction. They are called
+// once `data` is saved, so they can use its id, and dispatched after the commit.
+//
+//	err := SaveOne(&commentModel, func() events.Event { return events.CommentCreated{CommentID: commentModel.ID} })
+func SaveOne(data interface{}, raise ...func() events.Event) error {
+	return saveOneWith(data, nil, raise...)
 }
 
-func FindOneArticle(condition interface{}) (ArticleModel, error) {
-	db := common.GetDB()
+// Rows saved in the transaction of their article or comment once it has its id, such as its
+// mentions. The events they return are recorded after those of `raise`.
+type saveAlso func(tx *gorm.DB) ([]events.Event, error)
+
+// Same as SaveOne, `also` is saved with `data` or not at all.
+func saveOneWith(data interface{}, also saveAlso, raise ...func() events.Event) error {
+	tx := common.GetDB().Begin()
+	if err := tx.Save(data).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return commitRecording(tx, also, raise)
+}
+
+// Save `also` and record the events of `raise` and `also` within `tx`, commit it, then dispatch them.
+func commitRecording(tx *gorm.DB, also saveAlso, raise []func() events.Event) error {
+	recorded := make([]events.Event, 0, len(raise))
+	for _, event := range raise {
+		recorded = append(recorded, event())
+	}
+	if also != nil {
+		extra, err := also(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		recorded = append(recorded, extra...)
+	}
+	eventIDs := make([]uint, 0, len(recorded))
+	for _, event := range recorded {
+		id, err := events.Record(tx, event)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		eventIDs = append(eventIDs, id)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	events.Dispatch(eventIDs...)
+	return nil
+}
+
+func FindOneArticle(ctx context.Context, condition interface{}) (ArticleModel, error) {
+	db := common.GetDBContext(ctx)
 	var model ArticleModel
 	tx := db.Begin()
 	tx.Where(condition).First(&model)
@@ -121,26 +263,359 @@ func FindOneArticle(condition interface{}) (ArticleModel, error) {
 }
 
 func (self *ArticleModel) getComments() error {
+	db := common.GetDB()
+	err := db.Where(CommentModel{ArticleID: self.ID}).Order("id asc").Find(&self.Comments).Error
+	if err != nil {
+		return err
+	}
+	return loadCommentAuthors(db, self.Comments)
+}
+
+// Bounds for the `limit` query parameter of the comment list.
+const (
+	defaultCommentLimit = 20
+	maxCommentLimit     = 100
+)
+
+// One page of an article's comments, NextCursor is empty on the last page.
+type CommentPage struct {
+	Comments   []CommentModel
+	Count      int
+	NextCursor string
+}
+
+// A malformed `cursor` or `order` given to getCommentsPage, the client's mistake.
+var (
+	errInvalidCursor = errors.New("Invalid cursor")
+	errInvalidOrder  = errors.New("Invalid order")
+)
+
+// Keyset pagination over the comment ids, `cursor` is the id of the last comment already seen
+// and `order` is "asc" (oldest first, the default) or "desc". Comments by `hidden` users are left out.
+// Returns errInvalidCursor or errInvalidOrder for malformed parameters.
+//
+//	page, err := articleModel.getCommentsPage(ctx, "20", "", "desc", myUserModel.HiddenUserIDs(ctx))
+func (self *ArticleModel) getCommentsPage(ctx context.Context, limit, cursor, order string, hidden []uint) (CommentPage, error) {
+	db := common.GetDBContext(ctx)
+	var page CommentPage
+
+	limit_int, err := strconv.Atoi(limit)
+	if err != nil || limit_int <= 0 {
+		limit_int = defaultCommentLimit
+	}
+	if limit_int > maxCommentLimit {
+		limit_int = maxCommentLimit
+	}
+
+	query := db.Where(CommentModel{ArticleID: self.ID}).Scopes(hideAuthors(hidden))
+	if err := query.Model(&CommentModel{}).Count(&page.Count).Error; err != nil {
+		return page, err
+	}
+
+	direction, comparison := "asc", "id > ?"
+	switch order {
+	case "", "asc":
+	case "desc":
+		direction, comparison = "desc", "id < ?"
+	default:
+		return page, errInvalidOrder
+	}
+	if cursor != "" {
+		cursor_id, err := strconv.ParseUint(cursor, 10, 32)
+		if err != nil {
+			return page, errInvalidCursor
+		}
+		query = query.Where(comparison, cursor_id)
+	}
+
+	// Fetch one extra row to know whether there is a next page.
+	err = query.Order("id " + direction).Limit(limit_int + 1).Find(&page.Comments).Error
+	if err != nil {
+		return page, err
+	}
+	if len(page.Comments) > limit_int {
+		page.Comments = page.Comments[:limit_int]
+		page.NextCursor = strconv.FormatUint(uint64(page.Comments[limit_int-1].ID), 10)
+	}
+	err = loadCommentAuthors(db, page.Comments)
+	return page, err
+}
+
+// Fill Author and Author.UserModel of every comment with two IN queries instead of two queries per comment.
+func loadCommentAuthors(db *gorm.DB, comments []CommentModel) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	var authorIDs []uint
+	for _, comment := range comments {
+		authorIDs = append(authorIDs, comment.AuthorID)
+	}
+	var authors []ArticleUserModel
+	if err := db.Where("id in (?)", authorIDs).Find(&authors).Error; err != nil {
+		return err
+	}
+
+	var userIDs []uint
+	for _, author := range authors {
+		userIDs = append(userIDs, author.UserModelID)
+	}
+	var userModels []users.UserModel
+	if err := db.Where("id in (?)", userIDs).Find(&userModels).Error; err != nil {
+		return err
+	}
+
+	userByID := make(map[uint]users.UserModel, len(userModels))
+	for _, userModel := range userModels {
+		userByID[userModel.ID] = userModel
+	}
+	authorByID := make(map[uint]ArticleUserModel, len(authors))
+	for _, author := range authors {
+		author.UserModel = userByID[author.UserModelID]
+		authorByID[author.ID] = author
+	}
+	for i := range comments {
+		comments[i].Author = authorByID[comments[i].AuthorID]
+	}
+	return nil
+}
+
+// Lower case with runs of spaces turned into "-", tags that normalize to "" are dropped.
+//
+//	normalizeTag(" Machine  Learning") // "machine-learning"
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+}
+
+// Number of tags per page of `/api/tags`, `?limit=` can ask for up to maxTagLimit.
+const (
+	defaultTagLimit = 20
+	maxTagLimit     = 100
+)
+
+// Tags used by at least one article with their ArticlesCount, the most used first unless `sort` is "name".
+// With a non zero `followedBy` it lists the tags that user follows instead, used or not.
+func getAllTags(ctx context.Context, limit, offset, sort string, followedBy uint) ([]TagModel, int, error) {
+	db := common.GetDBContext(ctx)
+	var models []TagModel
+	var count int
+
+	offset_int, err := strconv.Atoi(offset)
+	if err != nil || offset_int < 0 {
+		offset_int = 0
+	}
+	limit_int, err := strconv.Atoi(limit)
+	if err != nil || limit_int <= 0 {
+		limit_int = defaultTagLimit
+	}
+	if limit_int > maxTagLimit {
+		limit_int = maxTagLimit
+	}
+	order := "articles_count desc, tag_models.tag"
+	if sort == "name" {
+		order = "tag_models.tag"
+	}
+
+	used := db.Table("tag_models").
+		Joins("JOIN article_tags ON article_tags.tag_model_id = tag_models.id").
+		Joins("JOIN article_models ON article_models.id = article_tags.article_model_id AND article_models.deleted_at IS NULL").
+		Where("tag_models.deleted_at IS NULL")
+	if followedBy != 0 {
+		used = db.Table("tag_models").
+			Joins("JOIN tag_follow_models ON tag_follow_models.tag_id = tag_models.id AND tag_follow_models.deleted_at IS NULL").
+			Joins("LEFT JOIN article_tags ON article_tags.tag_model_id = tag_models.id").
+			Joins("LEFT JOIN article_models ON article_models.id = article_tags.article_model_id AND article_models.deleted_at IS NULL").
+			Where("tag_models.deleted_at IS NULL AND tag_follow_models.followed_by_id = ?", followedBy)
+	}
+	if err := used.Select("COUNT(DISTINCT tag_models.id)").Row().Scan(&count); err != nil {
+		return models, 0, err
+	}
+	var rows []struct {
+		ID            uint
+		Tag           string
+		Description   string
+		ArticlesCount int
+	}
+	err = used.Select("tag_models.id, tag_models.tag, tag_models.description, COUNT(article_models.id) AS articles_count").
+		Group("tag_models.id, tag_models.tag, tag_models.description").
+		Order(order).Offset(offset_int).Limit(limit_int).Scan(&rows).Error
+	for _, row := range rows {
+		model := TagModel{Tag: row.Tag, Description: row.Description, ArticlesCount: row.ArticlesCount}
+		model.ID = row.ID
+		models = append(models, model)
+	}
+	return models, count, err
+}
+
+// Find a tag by name, `tag` is normalized first so "/api/tags/Go" finds "go".
+func FindOneTag(tag string) (TagModel, error) {
+	db := common.GetDB()
+	var model TagModel
+	err := db.Where(TagModel{Tag: normalizeTag(tag)}).First(&model).Error
+	if err != nil {
+		return model, err
+	}
+	db.Table("article_tags").
+		Joins("JOIN article_models ON article_models.id = article_tags.article_model_id AND article_models.deleted_at IS NULL").
+		Where("article_tags.tag_model_id = ?", model.ID).Count(&model.ArticlesCount)
+	return model, nil
+}
+
+// Rename a tag and set its description, the caller checks `tag` isn't taken by another tag.
+func (model *TagModel) Update(tag, description string) error {
+	db := common.GetDB()
+	err := db.Model(model).Updates(map[string]interface{}{"tag": tag, "description": description}).Error
+	return err
+}
+
+// Move the articles and followers of `source` to `target` then delete `source`,
+// articles that had both and users who followed both keep a single link.
+func mergeTags(source, target TagModel) error {
 	db := common.GetDB()
 	tx := db.Begin()
-	tx.Model(self).Related(&self.Comments, "Comments")
-	for i, _ := range self.Comments {
-		tx.Model(&self.Comments[i]).Related(&self.Comments[i].Author, "Author")
-		tx.Model(&self.Comments[i].Author).Related(&self.Comments[i].Author.UserModel)
+	err := tx.Exec(`INSERT INTO article_tags (article_model_id, tag_model_id)
+		SELECT article_model_id, ? FROM article_tags WHERE tag_model_id = ?
+		AND article_model_id NOT IN (SELECT article_model_id FROM article_tags WHERE tag_model_id = ?)`,
+		target.ID, source.ID, target.ID).Error
+	if err == nil {
+		err = tx.Exec(`UPDATE tag_follow_models SET tag_id = ? WHERE tag_id = ? AND deleted_at IS NULL
+			AND followed_by_id NOT IN (SELECT followed_by_id FROM tag_follow_models WHERE tag_id = ? AND deleted_at IS NULL)`,
+			target.ID, source.ID, target.ID).Error
 	}
-	err := tx.Commit().Error
+	if err == nil {
+		err = deleteTag(tx, source)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Remove a tag from every article and follower. The row is deleted for good, a soft deleted one would
+// keep its name taken in the unique index and setTags could never create it again.
+func deleteTag(tx *gorm.DB, model TagModel) error {
+	err := tx.Exec("DELETE FROM article_tags WHERE tag_model_id = ?", model.ID).Error
+	if err == nil {
+		err = tx.Exec("DELETE FROM tag_follow_models WHERE tag_id = ?", model.ID).Error
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&model).Error
+}
+
+func (tag TagModel) followedBy(ctx context.Context, user ArticleUserModel) error {
+	db := common.GetDBContext(ctx)
+	var follow TagFollowModel
+	err := db.FirstOrCreate(&follow, &TagFollowModel{
+		TagID:        tag.ID,
+		FollowedByID: user.ID,
+	}).Error
+	return err
+}
+
+func (tag TagModel) unFollowedBy(ctx context.Context, user ArticleUserModel) error {
+	db := common.GetDBContext(ctx)
+	err := db.Where(TagFollowModel{
+		TagID:        tag.ID,
+		FollowedByID: user.ID,
+	}).Delete(TagFollowModel{}).Error
 	return err
 }
 
-func getAllTags() ([]TagModel, error) {
+func (tag TagModel) isFollowedBy(ctx context.Context, user ArticleUserModel) bool {
+	if user.ID == 0 {
+		return false
+	}
+	db := common.GetDBContext(ctx)
+	var follow TagFollowModel
+	db.Where(TagFollowModel{
+		TagID:        tag.ID,
+		FollowedByID: user.ID,
+	}).First(&follow)
+	return follow.ID != 0
+}
+
+// Ids of the tags `user` follows, serializers turn them into a set to flag many tags with one query.
+func followedTagIDs(ctx context.Context, user ArticleUserModel) []uint {
+	var ids []uint
+	if user.ID == 0 {
+		return ids
+	}
+	db := common.GetDBContext(ctx)
+	db.Model(&TagFollowModel{}).Where(TagFollowModel{FollowedByID: user.ID}).Pluck("tag_id", &ids)
+	return ids
+}
+
+func DeleteTagModel(model TagModel) error {
 	db := common.GetDB()
-	var models []TagModel
-	err := db.Find(&models).Error
-	return models, err
+	tx := db.Begin()
+	if err := deleteTag(tx, model); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
-func FindManyArticle(tag, author, limit, offset, favorited string) ([]ArticleModel, int, error) {
+// Fold the tags created before names were normalized into their normalized tag, run with the migrations.
+//
+//	"Go", "GO" and "go" become one "go" tag linked to all their articles.
+func NormalizeTags() error {
 	db := common.GetDB()
+	var models []TagModel
+	if err := db.Order("id").Find(&models).Error; err != nil {
+		return err
+	}
+	for _, model := range models {
+		name := normalizeTag(model.Tag)
+		if name == model.Tag {
+			continue
+		}
+		var err error
+		var target TagModel
+		if name == "" {
+			err = DeleteTagModel(model)
+		} else if db.Where(TagModel{Tag: name}).First(&target).RecordNotFound() {
+			err = db.Model(&model).Update("tag", name).Error
+		} else {
+			err = mergeTags(model, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Leave out the rows whose author_id belongs to one of `userIDs`, the users blocked or muted by the viewer.
+// Works on articles and comments, both reference an ArticleUserModel.
+func hideAuthors(userIDs []uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(userIDs) == 0 {
+			return db
+		}
+		return db.Where("author_id NOT IN (SELECT id FROM article_user_models WHERE user_model_id IN (?))", userIDs)
+	}
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Articles filtered by tag, author or favoriting user, most recent first as the RealWorld spec asks.
+// Articles by `hidden` users are left out, as are the author and favorites pages of a hidden user.
+//
+// Its queries are traced as part of the request of `ctx`.
+//
+//	articleModels, count, err := FindManyArticle(ctx, "go", "", "20", "0", "", myUserModel.BlockedUserIDs(ctx))
+func FindManyArticle(ctx context.Context, tag, author, limit, offset, favorited string, hidden []uint) ([]ArticleModel, int, error) {
+	db := common.GetDBContext(ctx)
 	var models []ArticleModel
 	var count int
 
@@ -157,40 +632,35 @@ func FindManyArticle(tag, author, limit, offset, favorited string) ([]ArticleMod
 	tx := db.Begin()
 	if tag != "" {
 		var tagModel TagModel
-		tx.Where(TagModel{Tag: tag}).First(&tagModel)
+		tx.Where(TagModel{Tag: normalizeTag(tag)}).First(&tagModel)
 		if tagModel.ID != 0 {
-			tx.Model(&tagModel).Offset(offset_int).Limit(limit_int).Related(&models, "ArticleModels")
-			count = tx.Model(&tagModel).Association("ArticleModels").Count()
+			tx.Model(&tagModel).Scopes(hideAuthors(hidden)).Order("article_models.created_at desc").Offset(offset_int).Limit(limit_int).Related(&models, "ArticleModels")
+			tx.Model(&ArticleModel{}).Joins("JOIN article_tags ON article_tags.article_model_id = article_models.id").
+				Where("article_tags.tag_model_id = ?", tagModel.ID).Scopes(hideAuthors(hidden)).Count(&count)
 		}
 	} else if author != "" {
 		var userModel users.UserModel
 		tx.Where(users.UserModel{Username: author}).First(&userModel)
-		articleUserModel := GetArticleUserModel(userModel)
+		articleUserModel := GetArticleUserModel(ctx, userModel)
 
-		if articleUserModel.ID != 0 {
+		if articleUserModel.ID != 0 && !containsID(hidden, userModel.ID) {
 			count = tx.Model(&articleUserModel).Association("ArticleModels").Count()
-			tx.Model(&articleUserModel).Offset(offset_int).Limit(limit_int).Related(&models, "ArticleModels")
+			tx.Model(&articleUserModel).Order("created_at desc").Offset(offset_int).Limit(limit_int).Related(&models, "ArticleModels")
 		}
 	} else if favorited != "" {
 		var userModel users.UserModel
 		tx.Where(users.UserModel{Username: favorited}).First(&userModel)
-		articleUserModel := GetArticleUserModel(userModel)
-		if articleUserModel.ID != 0 {
-			var favoriteModels []FavoriteModel
-			tx.Where(FavoriteModel{
-				FavoriteByID: articleUserModel.ID,
-			}).Offset(offset_int).Limit(limit_int).Find(&favoriteModels)
-
-			count = tx.Model(&articleUserModel).Association("FavoriteModels").Count()
-			for _, favorite := range favoriteModels {
-				var model ArticleModel
-				tx.Model(&favorite).Related(&model, "Favorite")
-				models = append(models, model)
-			}
+		articleUserModel := GetArticleUserModel(ctx, userModel)
+		if articleUserModel.ID != 0 && !containsID(hidden, userModel.ID) {
+			favorites := tx.Model(&ArticleModel{}).
+				Joins("JOIN favorite_models ON favorite_models.favorite_id = article_models.id AND favorite_models.deleted_at IS NULL").
+				Where("favorite_models.favorite_by_id = ?", articleUserModel.ID).Scopes(hideAuthors(hidden))
+			favorites.Count(&count)
+			favorites.Select("article_models.*").Order("favorite_models.created_at desc").Offset(offset_int).Limit(limit_int).Find(&models)
 		}
 	} else {
-		db.Model(&models).Count(&count)
-		db.Offset(offset_int).Limit(limit_int).Find(&models)
+		db.Model(&models).Scopes(hideAuthors(hidden)).Count(&count)
+		db.Scopes(hideAuthors(hidden)).Order("created_at desc").Offset(offset_int).Limit(limit_int).Find(&models)
 	}
 
 	for i, _ := range models {
@@ -202,8 +672,10 @@ func FindManyArticle(tag, author, limit, offset, favorited string) ([]ArticleMod
 	return models, count, err
 }
 
-func (self *ArticleUserModel) GetArticleFeed(limit, offset string) ([]ArticleModel, int, error) {
-	db := common.GetDB()
+// Articles by the authors and with the tags the user follows, most recently updated first.
+// Blocked and muted authors are left out even when a followed tag matches.
+func (self *ArticleUserModel) GetArticleFeed(ctx context.Context, limit, offset string) ([]ArticleModel, int, error) {
+	db := common.GetDBContext(ctx)
 	var models []ArticleModel
 	var count int
 
@@ -217,14 +689,13 @@ func (self *ArticleUserModel) GetArticleFeed(limit, offset string) ([]ArticleMod
 	}
 
 	tx := db.Begin()
-	followings := self.UserModel.GetFollowings()
-	var articleUserModels []uint
-	for _, following := range followings {
-		articleUserModel := GetArticleUserModel(following)
-		articleUserModels = append(articleUserModels, articleUserModel.ID)
-	}
+	articleUserModels := self.followedAuthorIDs(ctx)
 
-	tx.Where("author_id in (?)", articleUserModels).Order("updated_at desc").Offset(offset_int).Limit(limit_int).Find(&models)
+	// One query for both sources, so an article by a followed author with a followed tag is listed once.
+	feed := tx.Model(&ArticleModel{}).Where("author_id in (?) OR id in (SELECT article_model_id FROM article_tags WHERE tag_model_id in (?))",
+		articleUserModels, followedTagIDs(ctx, *self)).Scopes(hideAuthors(self.UserModel.HiddenUserIDs(ctx)))
+	feed.Count(&count)
+	feed.Order("updated_at desc").Offset(offset_int).Limit(limit_int).Find(&models)
 
 	for i, _ := range models {
 		tx.Model(&models[i]).Related(&models[i].Author, "Author")
@@ -235,10 +706,45 @@ func (self *ArticleUserModel) GetArticleFeed(limit, offset string) ([]ArticleMod
 	return models, count, err
 }
 
-func (model *ArticleModel) setTags(tags []string) error {
-	db := common.GetDB()
+// ArticleUserModel ids of the authors the user follows.
+func (self *ArticleUserModel) followedAuthorIDs(ctx context.Context) []uint {
+	var ids []uint
+	for _, following := range self.UserModel.GetFollowings(ctx) {
+		ids = append(ids, GetArticleUserModel(ctx, following).ID)
+	}
+	return ids
+}
+
+// Articles the authors the user follows published after `since`, oldest first, for email digests.
+// Blocked and muted authors are left out like in the feed.
+func (self *ArticleUserModel) GetFollowedArticlesSince(ctx context.Context, since time.Time, limit int) ([]ArticleModel, error) {
+	db := common.GetDBContext(ctx)
+	var models []ArticleModel
+	authorIDs := self.followedAuthorIDs(ctx)
+	if len(authorIDs) == 0 {
+		return models, nil
+	}
+	tx := db.Begin()
+	tx.Where("author_id in (?) AND created_at > ?", authorIDs, since).Scopes(hideAuthors(self.UserModel.HiddenUserIDs(ctx))).
+		Order("created_at asc").Limit(limit).Find(&models)
+	for i := range models {
+		tx.Model(&models[i]).Related(&models[i].Author, "Author")
+		tx.Model(&models[i].Author).Related(&models[i].Author.UserModel)
+	}
+	err := tx.Commit().Error
+	return models, err
+}
+
+func (model *ArticleModel) setTags(ctx context.Context, tags []string) error {
+	db := common.GetDBContext(ctx)
 	var tagList []TagModel
+	seen := map[string]bool{}
 	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
 		var tagModel TagModel
 		err := db.FirstOrCreate(&tagModel, TagModel{Tag: tag}).Error
 		if err != nil {
@@ -250,22 +756,186 @@ func (model *ArticleModel) setTags(tags []string) error {
 	return nil
 }
 
-func (model *ArticleModel) Update(data interface{}) error {
+// Record the users mentioned in `body`, written by `author`, forgetting those no longer mentioned.
+// Unknown usernames, the author and users blocked with the author are skipped, and only users
+// mentioned for the first time raise an event. It runs in the transaction saving the article or
+// comment, so both are saved or neither.
+//
+//	err := saveOneWith(&comment, func(tx *gorm.DB) ([]events.Event, error) {
+//		return saveMentions(ctx, tx, article.ID, comment.ID, comment.Author, comment.Body)
+//	})
+func saveMentions(ctx context.Context, tx *gorm.DB, articleID, commentID uint, author ArticleUserModel, body string) ([]events.Event, error) {
+	names := common.ParseMentions(body)
+	var mentioned []users.UserModel
+	if len(names) > 0 {
+		tx.Where("username in (?) AND id <> ?", names, author.UserModelID).Find(&mentioned)
+	}
+	mentionedIDs := []uint{}
+	for _, userModel := range mentioned {
+		if !users.BlockedBetween(ctx, author.UserModelID, userModel.ID) {
+			mentionedIDs = append(mentionedIDs, userModel.ID)
+		}
+	}
+
+	var existing []MentionModel
+	tx.Where("article_id = ? AND comment_id = ?", articleID, commentID).Find(&existing)
+	known := []uint{}
+	for _, mention := range existing {
+		if containsID(mentionedIDs, mention.UserID) {
+			known = append(known, mention.UserID)
+			continue
+		}
+		if err := tx.Unscoped().Delete(&mention).Error; err != nil {
+			return nil, err
+		}
+	}
+	raised := []events.Event{}
+	for _, id := range mentionedIDs {
+		if containsID(known, id) {
+			continue
+		}
+		if err := tx.Create(&MentionModel{ArticleID: articleID, CommentID: commentID, UserID: id}).Error; err != nil {
+			return nil, err
+		}
+		raised = append(raised, events.UserMentioned{ArticleID: articleID, CommentID: commentID, AuthorID: author.UserModelID, UserID: id})
+	}
+	return raised, nil
+}
+
+// The mentions of a page, keyed by article with a zero CommentID and by comment otherwise.
+type mentionKey struct {
+	ArticleID uint
+	CommentID uint
+}
+
+// Mentions in the bodies of the articles `articleIDs` and of the comments `commentIDs`, in order of
+// mention, with the users mentioned. Users in `hidden` are left out.
+func findMentions(ctx context.Context, articleIDs, commentIDs []uint, hidden []uint) ([]MentionModel, []users.UserModel) {
+	db := common.GetDBContext(ctx)
+	var mentions []MentionModel
+	var userModels []users.UserModel
+	if len(articleIDs) == 0 && len(commentIDs) == 0 {
+		return mentions, userModels
+	}
+	if len(articleIDs) == 0 {
+		articleIDs = []uint{0}
+	}
+	if len(commentIDs) == 0 {
+		commentIDs = []uint{0}
+	}
+	query := db.Where("(comment_id = 0 AND article_id IN (?)) OR (comment_id <> 0 AND comment_id IN (?))", articleIDs, commentIDs)
+	if len(hidden) > 0 {
+		query = query.Where("user_id NOT IN (?)", hidden)
+	}
+	query.Order("id").Find(&mentions)
+	if len(mentions) == 0 {
+		return mentions, userModels
+	}
+	userIDs := make([]uint, 0, len(mentions))
+	for _, mention := range mentions {
+		userIDs = append(userIDs, mention.UserID)
+	}
+	db.Where("id IN (?)", userIDs).Find(&userModels)
+	return mentions, userModels
+}
+
+// Like SaveOne, the events `raise` makes are recorded with the update.
+func (model *ArticleModel) Update(data interface{}, raise ...func() events.Event) error {
+	return model.updateWith(data, nil, raise...)
+}
+
+// Same as Update, `also` is saved with the update or not at all.
+func (model *ArticleModel) updateWith(data interface{}, also saveAlso, raise ...func() events.Event) error {
+	tx := common.GetDB().Begin()
+	err := tx.Model(model).Update(data).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// The struct update above skips zero values, so the recomputed stats are written as columns.
+	model.setReadingStats()
+	err = tx.Model(model).UpdateColumns(map[string]interface{}{
+		"word_count":   model.WordCount,
+		"reading_time": model.ReadingTime,
+		"excerpt":      model.Excerpt,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return commitRecording(tx, also, raise)
+}
+
+// Like SaveOne, the events `raise` makes are recorded with the deletion.
+func DeleteArticleModel(condition interface{}, raise ...func() events.Event) error {
+	tx := common.GetDB().Begin()
+	if err := tx.Where(condition).Delete(ArticleModel{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return commitRecording(tx, nil, raise)
+}
+
+// A comment with its author, as serialized.
+func FindOneComment(id uint) (CommentModel, error) {
 	db := common.GetDB()
-	err := db.Model(model).Update(data).Error
-	return err
+	var model CommentModel
+	if err := db.First(&model, id).Error; err != nil {
+		return model, err
+	}
+	comments := []CommentModel{model}
+	err := loadCommentAuthors(db, comments)
+	return comments[0], err
 }
 
-func DeleteArticleModel(condition interface{}) error {
+// Like SaveOne, the events `raise` makes are recorded with the deletion.
+func DeleteCommentModel(condition interface{}, raise ...func() events.Event) error {
+	tx := common.GetDB().Begin()
+	if err := tx.Where(condition).Delete(CommentModel{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return commitRecording(tx, nil, raise)
+}
+
+// Every distinct cover image, the variant pipeline uses it to backfill covers.
+//
+//	go uploads.BackfillVariants(ctx, "cover", articles.CoverImageURLs())
+func CoverImageURLs() []string {
 	db := common.GetDB()
-	err := db.Where(condition).Delete(ArticleModel{}).Error
-	return err
+	var urls []string
+	db.Model(&ArticleModel{}).Where("cover_image <> ''").Pluck("DISTINCT cover_image", &urls)
+	return urls
 }
 
-func DeleteCommentModel(condition interface{}) error {
+// When an article was last deleted, zero if none ever was. Feeds check it as an article leaving
+// them changes them without leaving an updated_at behind.
+func LastArticleDeletion(ctx context.Context) time.Time {
+	db := common.GetDBContext(ctx)
+	var models []ArticleModel
+	db.Unscoped().Select("id, deleted_at").Where("deleted_at IS NOT NULL").Order("deleted_at desc").Limit(1).Find(&models)
+	if len(models) == 0 || models[0].DeletedAt == nil {
+		return time.Time{}
+	}
+	return *models[0].DeletedAt
+}
+
+// Number of articles, sitemaps use it to know how many pages to list.
+func CountArticles() int {
 	db := common.GetDB()
-	err := db.Where(condition).Delete(CommentModel{}).Error
-	return err
+	count := 0
+	db.Model(&ArticleModel{}).Count(&count)
+	return count
+}
+
+// Only the slugs and update times of a page of articles in id order, enough for a sitemap without loading bodies.
+//
+//	articleModels, err := articles.FindArticleSlugs(50000, 50000)
+func FindArticleSlugs(offset, limit int) ([]ArticleModel, error) {
+	db := common.GetDB()
+	var models []ArticleModel
+	err := db.Select("id, slug, updated_at").Order("id").Offset(offset).Limit(limit).Find(&models).Error
+	return models, err
 }
 
 // AutoMigrate and add performance indexes
@@ -274,7 +944,10 @@ func AutoMigrateArticles(db *gorm.DB) {
 	db.AutoMigrate(&ArticleUserModel{})
 	db.AutoMigrate(&FavoriteModel{})
 	db.AutoMigrate(&TagModel{})
+	db.AutoMigrate(&TagFollowModel{})
 	db.AutoMigrate(&CommentModel{})
+	db.AutoMigrate(&MentionModel{})
+	MigrateBodies(db)
 
 	// Add performance indexes
 	db.Model(&ArticleModel{}).AddIndex("idx_article_created_at", "created_at")
@@ -283,3 +956,16 @@ func AutoMigrateArticles(db *gorm.DB) {
 	db.Model(&FavoriteModel{}).AddIndex("idx_favorite_article_id", "favorite_id")
 	db.Model(&FavoriteModel{}).AddIndex("idx_favorite_user_id", "favorite_by_id")
 }
+
+// Bodies were varchar(2048) before they held Markdown, AutoMigrate creates missing columns but
+// never changes existing ones. SQLite ignores the declared length, its varchar columns already
+// take any text and it can't alter a column anyway, so only the other dialects are changed.
+func MigrateBodies(db *gorm.DB) error {
+	if db.Dialect().GetName() == "sqlite3" {
+		return nil
+	}
+	if err := db.Model(&ArticleModel{}).ModifyColumn("body", "text").Error; err != nil {
+		return err
+	}
+	return db.Model(&CommentModel{}).ModifyColumn("body", "text").Error
+}
@@ -3,10 +3,17 @@ package articles
 import (
 	"errors"
 	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/metrics"
+	"realworld-backend/realtime"
+	"realworld-backend/uploads"
 	"realworld-backend/users"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 func ArticlesRegister(router *gin.RouterGroup) {
@@ -23,10 +30,20 @@ func ArticlesAnonymousRegister(router *gin.RouterGroup) {
 	router.GET("/", ArticleList)
 	router.GET("/:slug", ArticleRetrieve)
 	router.GET("/:slug/comments", ArticleCommentList)
+	router.GET("/:slug/events", ArticleEvents)
 }
 
 func TagsAnonymousRegister(router *gin.RouterGroup) {
 	router.GET("/", TagList)
+	router.GET("/:tag", TagRetrieve)
+}
+
+func TagsRegister(router *gin.RouterGroup) {
+	router.PUT("/:tag", users.AdminMiddleware(), TagUpdate)
+	router.DELETE("/:tag", users.AdminMiddleware(), TagDelete)
+	router.POST("/:tag/merge", users.AdminMiddleware(), TagMerge)
+	router.POST("/:tag/follow", TagFollow)
+	router.DELETE("/:tag/follow", TagUnfollow)
 }
 
 func ArticleCreate(c *gin.Context) {
@@ -37,11 +54,19 @@ func ArticleCreate(c *gin.Context) {
 	}
 	//fmt.Println(articleModelValidator.articleModel.Author.UserModel)
 
-	if err := SaveOne(&articleModelValidator.articleModel); err != nil {
+	articleModel := &articleModelValidator.articleModel
+	err := saveOneWith(articleModel, func(tx *gorm.DB) ([]events.Event, error) {
+		return saveMentions(c.Request.Context(), tx, articleModel.ID, 0, articleModel.Author, articleModel.Body)
+	}, func() events.Event {
+		return events.ArticleCreated{ArticleID: articleModel.ID, Slug: articleModel.Slug, AuthorID: articleModel.Author.UserModelID}
+	})
+	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
-	serializer := ArticleSerializer{c, articleModelValidator.articleModel}
+	metrics.ArticlesCreated.Inc()
+	uploads.EnqueueVariants(articleModel.CoverImage, "cover")
+	serializer := ArticleSerializer{c, *articleModel}
 	c.JSON(http.StatusCreated, gin.H{"article": serializer.Response()})
 }
 
@@ -52,7 +77,7 @@ func ArticleList(c *gin.Context) {
 	favorited := c.Query("favorited")
 	limit := c.Query("limit")
 	offset := c.Query("offset")
-	articleModels, modelCount, err := FindManyArticle(tag, author, limit, offset, favorited)
+	articleModels, modelCount, err := FindManyArticle(c.Request.Context(), tag, author, limit, offset, favorited, users.ContextBlockedUserIDs(c))
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
 		return
@@ -69,8 +94,8 @@ func ArticleFeed(c *gin.Context) {
 		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
 		return
 	}
-	articleUserModel := GetArticleUserModel(myUserModel)
-	articleModels, modelCount, err := articleUserModel.GetArticleFeed(limit, offset)
+	articleUserModel := GetArticleUserModel(c.Request.Context(), myUserModel)
+	articleModels, modelCount, err := articleUserModel.GetArticleFeed(c.Request.Context(), limit, offset)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
 		return
@@ -79,14 +104,21 @@ func ArticleFeed(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
 }
 
+// Whether the current user and the author of `articleModel` are blocked with each other, the
+// article, its comments and its stream are then answered as missing.
+func blockedWithAuthor(c *gin.Context, articleModel ArticleModel) bool {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	return users.BlockedBetween(c.Request.Context(), myUserModel.ID, articleModel.Author.UserModelID)
+}
+
 func ArticleRetrieve(c *gin.Context) {
 	slug := c.Param("slug")
 	if slug == "feed" {
 		ArticleFeed(c)
 		return
 	}
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
+	if err != nil || blockedWithAuthor(c, articleModel) {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
@@ -96,7 +128,7 @@ func ArticleRetrieve(c *gin.Context) {
 
 func ArticleUpdate(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
@@ -107,18 +139,34 @@ func ArticleUpdate(c *gin.Context) {
 		return
 	}
 
+	previousCoverImage := articleModel.CoverImage
 	articleModelValidator.articleModel.ID = articleModel.ID
-	if err := articleModel.Update(articleModelValidator.articleModel); err != nil {
+	err = articleModel.updateWith(articleModelValidator.articleModel, func(tx *gorm.DB) ([]events.Event, error) {
+		return saveMentions(c.Request.Context(), tx, articleModel.ID, 0, articleModel.Author, articleModelValidator.articleModel.Body)
+	}, func() events.Event {
+		return events.ArticleUpdated{ArticleID: articleModel.ID, Slug: articleModel.Slug, AuthorID: articleModel.Author.UserModelID}
+	})
+	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
+	if articleModel.CoverImage != previousCoverImage {
+		uploads.EnqueueVariants(articleModel.CoverImage, "cover")
+	}
 	serializer := ArticleSerializer{c, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
 func ArticleDelete(c *gin.Context) {
 	slug := c.Param("slug")
-	err := DeleteArticleModel(&ArticleModel{Slug: slug})
+	articleModel, _ := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
+	raise := []func() events.Event{}
+	if articleModel.ID != 0 {
+		raise = append(raise, func() events.Event {
+			return events.ArticleDeleted{ArticleID: articleModel.ID, Slug: slug, AuthorID: articleModel.Author.UserModelID}
+		})
+	}
+	err := DeleteArticleModel(&ArticleModel{Slug: slug}, raise...)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
@@ -128,33 +176,37 @@ func ArticleDelete(c *gin.Context) {
 
 func ArticleFavorite(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	err = articleModel.favoriteBy(GetArticleUserModel(myUserModel))
+	err = articleModel.favoriteBy(c.Request.Context(), GetArticleUserModel(c.Request.Context(), myUserModel))
+	if errors.Is(err, users.ErrBlocked) {
+		c.JSON(http.StatusForbidden, common.NewError("article", err))
+		return
+	}
 	serializer := ArticleSerializer{c, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
 func ArticleUnfavorite(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	err = articleModel.unFavoriteBy(GetArticleUserModel(myUserModel))
+	err = articleModel.unFavoriteBy(c.Request.Context(), GetArticleUserModel(c.Request.Context(), myUserModel))
 	serializer := ArticleSerializer{c, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
 func ArticleCommentCreate(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid slug")))
 		return
@@ -164,9 +216,24 @@ func ArticleCommentCreate(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
 		return
 	}
+	if users.BlockedBetween(c.Request.Context(), commentModelValidator.commentModel.Author.UserModelID, articleModel.Author.UserModelID) {
+		c.JSON(http.StatusForbidden, common.NewError("comment", users.ErrBlocked))
+		return
+	}
 	commentModelValidator.commentModel.Article = articleModel
 
-	if err := SaveOne(&commentModelValidator.commentModel); err != nil {
+	commentModel := &commentModelValidator.commentModel
+	err = saveOneWith(commentModel, func(tx *gorm.DB) ([]events.Event, error) {
+		return saveMentions(c.Request.Context(), tx, articleModel.ID, commentModel.ID, commentModel.Author, commentModel.Body)
+	}, func() events.Event {
+		return events.CommentCreated{
+			CommentID:       commentModel.ID,
+			ArticleID:       articleModel.ID,
+			ArticleAuthorID: articleModel.Author.UserModelID,
+			AuthorID:        commentModel.Author.UserModelID,
+		}
+	})
+	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
@@ -181,7 +248,14 @@ func ArticleCommentDelete(c *gin.Context) {
 		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
 		return
 	}
-	err = DeleteCommentModel([]uint{id})
+	commentModel, _ := FindOneComment(id)
+	raise := []func() events.Event{}
+	if commentModel.ID != 0 {
+		raise = append(raise, func() events.Event {
+			return events.CommentDeleted{CommentID: commentModel.ID, ArticleID: commentModel.ArticleID, AuthorID: commentModel.Author.UserModelID}
+		})
+	}
+	err = DeleteCommentModel([]uint{id}, raise...)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
 		return
@@ -189,27 +263,228 @@ func ArticleCommentDelete(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"comment": "Delete success"})
 }
 
+// Server-Sent Events of the article: comment.created, comment.deleted and favorites.changed.
+// A reconnecting EventSource sends the Last-Event-ID header and gets what it missed, comments
+// (": heartbeat") keep idle connections from being closed by proxies. Comments of the users the
+// current user blocked, muted or is blocked by are left out, as in ArticleCommentList.
+func ArticleEvents(c *gin.Context) {
+	slug := c.Param("slug")
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
+	if err != nil || articleModel.ID == 0 || blockedWithAuthor(c, articleModel) {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return
+	}
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	hidden := map[uint]bool{}
+	for _, id := range myUserModel.HiddenUserIDs(c.Request.Context()) {
+		hidden[id] = true
+	}
+	render := func(message realtime.Message) {
+		if message.UserID != 0 && hidden[message.UserID] {
+			return
+		}
+		c.Render(-1, sse.Event{Id: message.ID, Event: message.Event, Data: string(message.Data)})
+	}
+	subscription, backlog := realtime.Subscribe(articleTopic(articleModel.ID), lastEventID)
+	defer subscription.Close()
+	heartbeatInterval := common.GetenvDuration("SSE_HEARTBEAT", 15*time.Second)
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	// The server's write timeout covers the whole response, a stream only needs every write to
+	// make it before the next heartbeat is due.
+	controller := http.NewResponseController(c.Writer)
+	controller.SetWriteDeadline(time.Now().Add(heartbeatInterval + 10*time.Second))
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.WriteString("retry: 3000\n\n")
+	for _, message := range backlog {
+		render(message)
+	}
+	c.Writer.Flush()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case message, ok := <-subscription.C:
+			if !ok {
+				// Too slow to keep up or shutting down, the client reconnects and catches up from Last-Event-ID.
+				return
+			}
+			controller.SetWriteDeadline(time.Now().Add(heartbeatInterval + 10*time.Second))
+			render(message)
+		case <-heartbeat.C:
+			controller.SetWriteDeadline(time.Now().Add(heartbeatInterval + 10*time.Second))
+			c.Writer.WriteString(": heartbeat\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
 func ArticleCommentList(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
+	if err != nil || blockedWithAuthor(c, articleModel) {
 		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Invalid slug")))
 		return
 	}
-	err = articleModel.getComments()
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	page, err := articleModel.getCommentsPage(c.Request.Context(), c.Query("limit"), c.Query("cursor"), c.Query("order"), myUserModel.HiddenUserIDs(c.Request.Context()))
+	if errors.Is(err, errInvalidCursor) || errors.Is(err, errInvalidOrder) {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("comments", err))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Database error")))
+		c.JSON(http.StatusInternalServerError, common.NewError("database", err))
 		return
 	}
-	serializer := CommentsSerializer{c, articleModel.Comments}
-	c.JSON(http.StatusOK, gin.H{"comments": serializer.Response()})
+	var nextCursor interface{}
+	if page.NextCursor != "" {
+		nextCursor = page.NextCursor
+	}
+	serializer := CommentsSerializer{c, page.Comments}
+	c.JSON(http.StatusOK, gin.H{"comments": serializer.Response(), "commentsCount": page.Count, "nextCursor": nextCursor})
 }
+
+// `tags` keeps the plain list of names the RealWorld spec defines, `tagDetails` has the same tags with their counts.
+// `?following=true` lists the tags the current user follows.
 func TagList(c *gin.Context) {
-	tagModels, err := getAllTags()
+	limit := c.Query("limit")
+	offset := c.Query("offset")
+	sort := c.Query("sort")
+	var followedBy uint
+	if c.Query("following") == "true" {
+		myUserModel := c.MustGet("my_user_model").(users.UserModel)
+		if myUserModel.ID == 0 {
+			c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+			return
+		}
+		followedBy = GetArticleUserModel(c.Request.Context(), myUserModel).ID
+	}
+	tagModels, modelCount, err := getAllTags(c.Request.Context(), limit, offset, sort, followedBy)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
 		return
 	}
 	serializer := TagsSerializer{c, tagModels}
-	c.JSON(http.StatusOK, gin.H{"tags": serializer.Response()})
+	c.JSON(http.StatusOK, gin.H{"tags": serializer.Response(), "tagDetails": serializer.DetailResponse(), "tagsCount": modelCount})
+}
+
+func TagRetrieve(c *gin.Context) {
+	tagModel, err := FindOneTag(c.Param("tag"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("tags", errors.New("Invalid tag")))
+		return
+	}
+	serializer := TagSerializer{c, tagModel}
+	c.JSON(http.StatusOK, gin.H{"tag": serializer.DetailResponse()})
+}
+
+// Rename a tag or change its description, renaming onto an existing tag has to go through TagMerge.
+func TagUpdate(c *gin.Context) {
+	tagModel, err := FindOneTag(c.Param("tag"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("tags", errors.New("Invalid tag")))
+		return
+	}
+	tagModelValidator := NewTagModelValidatorFillWith(tagModel)
+	if err := tagModelValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	name := tagModelValidator.tagModel.Tag
+	if name == "" {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("tag", errors.New("Invalid tag")))
+		return
+	}
+	if name != tagModel.Tag {
+		if _, err := FindOneTag(name); err == nil {
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("tag", errors.New("Tag already exists, merge into it instead")))
+			return
+		}
+	}
+	if err := tagModel.Update(name, tagModelValidator.tagModel.Description); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := TagSerializer{c, tagModel}
+	c.JSON(http.StatusOK, gin.H{"tag": serializer.DetailResponse()})
+}
+
+func TagMerge(c *gin.Context) {
+	source, err := FindOneTag(c.Param("tag"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("tags", errors.New("Invalid tag")))
+		return
+	}
+	var tagMergeValidator TagMergeValidator
+	if err := tagMergeValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	target, err := FindOneTag(tagMergeValidator.Into)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("into", errors.New("Invalid tag")))
+		return
+	}
+	if target.ID == source.ID {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("into", errors.New("Can't merge a tag into itself")))
+		return
+	}
+	if err := mergeTags(source, target); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	target, _ = FindOneTag(target.Tag)
+	serializer := TagSerializer{c, target}
+	c.JSON(http.StatusOK, gin.H{"tag": serializer.DetailResponse()})
+}
+
+func TagFollow(c *gin.Context) {
+	tagModel, err := FindOneTag(c.Param("tag"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("tags", errors.New("Invalid tag")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err := tagModel.followedBy(c.Request.Context(), GetArticleUserModel(c.Request.Context(), myUserModel)); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := TagSerializer{c, tagModel}
+	c.JSON(http.StatusOK, gin.H{"tag": serializer.DetailResponse()})
+}
+
+func TagUnfollow(c *gin.Context) {
+	tagModel, err := FindOneTag(c.Param("tag"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("tags", errors.New("Invalid tag")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err := tagModel.unFollowedBy(c.Request.Context(), GetArticleUserModel(c.Request.Context(), myUserModel)); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := TagSerializer{c, tagModel}
+	c.JSON(http.StatusOK, gin.H{"tag": serializer.DetailResponse()})
+}
+
+func TagDelete(c *gin.Context) {
+	tagModel, err := FindOneTag(c.Param("tag"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("tags", errors.New("Invalid tag")))
+		return
+	}
+	if err := DeleteTagModel(tagModel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tag": "Delete success"})
 }
@@ -1,7 +1,12 @@
 package articles
 
 import (
+	"strings"
+	"time"
+
 	"github.com/gosimple/slug"
+	"realworld-backend/common"
+	"realworld-backend/uploads"
 	"realworld-backend/users"
 	"github.com/gin-gonic/gin"
 )
@@ -16,10 +21,33 @@ type TagsSerializer struct {
 	Tags []TagModel
 }
 
+// Article tag lists only hold names, `/api/tags` also describes each tag with DetailResponse.
+type TagResponse struct {
+	Tag           string `json:"tag"`
+	Description   string `json:"description"`
+	ArticlesCount int    `json:"articlesCount"`
+	Following     bool   `json:"following"`
+}
+
 func (s *TagSerializer) Response() string {
 	return s.TagModel.Tag
 }
 
+func (s *TagSerializer) DetailResponse() TagResponse {
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	ctx := common.RequestContext(s.C)
+	return s.detailResponse(s.isFollowedBy(ctx, GetArticleUserModel(ctx, myUserModel)))
+}
+
+func (s *TagSerializer) detailResponse(following bool) TagResponse {
+	return TagResponse{
+		Tag:           s.TagModel.Tag,
+		Description:   s.TagModel.Description,
+		ArticlesCount: s.TagModel.ArticlesCount,
+		Following:     following,
+	}
+}
+
 func (s *TagsSerializer) Response() []string {
 	response := []string{}
 	for _, tag := range s.Tags {
@@ -29,14 +57,43 @@ func (s *TagsSerializer) Response() []string {
 	return response
 }
 
+// The followed tags are loaded once for the whole list.
+func (s *TagsSerializer) DetailResponse() []TagResponse {
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	followed := map[uint]bool{}
+	ctx := common.RequestContext(s.C)
+	for _, id := range followedTagIDs(ctx, GetArticleUserModel(ctx, myUserModel)) {
+		followed[id] = true
+	}
+	response := []TagResponse{}
+	for _, tag := range s.Tags {
+		serializer := TagSerializer{s.C, tag}
+		response = append(response, serializer.detailResponse(followed[tag.ID]))
+	}
+	return response
+}
+
 type ArticleUserSerializer struct {
 	C *gin.Context
 	ArticleUserModel
 }
 
 func (s *ArticleUserSerializer) Response() users.ProfileResponse {
-	response := users.ProfileSerializer{s.C, s.ArticleUserModel.UserModel}
-	return response.Response()
+	return s.response(nil)
+}
+
+func (s *ArticleUserSerializer) response(avatars uploads.Variants) users.ProfileResponse {
+	response := users.ProfileSerializer{C: s.C, UserModel: s.ArticleUserModel.UserModel}
+	return response.ResponseWithAvatars(avatars)
+}
+
+// The avatars of the authors of a page, to load their variants at once.
+func authorImages(authors []ArticleUserModel) []string {
+	userModels := make([]users.UserModel, 0, len(authors))
+	for _, author := range authors {
+		userModels = append(userModels, author.UserModel)
+	}
+	return users.ImagesOf(userModels)
 }
 
 type ArticleSerializer struct {
@@ -45,17 +102,41 @@ type ArticleSerializer struct {
 }
 
 type ArticleResponse struct {
-	ID             uint                  `json:"-"`
-	Title          string                `json:"title"`
-	Slug           string                `json:"slug"`
-	Description    string                `json:"description"`
-	Body           string                `json:"body"`
-	CreatedAt      string                `json:"createdAt"`
-	UpdatedAt      string                `json:"updatedAt"`
-	Author         users.ProfileResponse `json:"author"`
-	Tags           []string              `json:"tagList"`
-	Favorite       bool                  `json:"favorited"`
-	FavoritesCount uint                  `json:"favoritesCount"`
+	ID             uint                    `json:"-"`
+	Title          string                  `json:"title"`
+	Slug           string                  `json:"slug"`
+	Description    string                  `json:"description"`
+	Body           string                  `json:"body"`
+	BodyHTML       string                  `json:"bodyHtml,omitempty"`
+	Excerpt        string                  `json:"excerpt"`
+	WordCount      int                     `json:"wordCount"`
+	ReadingTime    int                     `json:"readingTime"`
+	CoverImage     *string                 `json:"coverImage"`
+	CoverVariants  map[string]string       `json:"coverImageVariants,omitempty"`
+	CreatedAt      string                  `json:"createdAt"`
+	UpdatedAt      string                  `json:"updatedAt"`
+	Author         users.ProfileResponse   `json:"author"`
+	Tags           []string                `json:"tagList"`
+	Favorite       bool                    `json:"favorited"`
+	FavoritesCount uint                    `json:"favoritesCount"`
+	Mentions       []users.ProfileResponse `json:"mentions"`
+	Meta           ArticleMetaResponse     `json:"meta"`
+}
+
+// What a server rendered page needs in its <head>: the canonical link and the Open Graph
+// (`<meta property=...>`) and Twitter card (`<meta name=...>`) tags, in document order.
+type ArticleMetaResponse struct {
+	Canonical   string    `json:"canonical"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	OpenGraph   []MetaTag `json:"openGraph"`
+	Twitter     []MetaTag `json:"twitter"`
+}
+
+type MetaTag struct {
+	Property string `json:"property,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Content  string `json:"content"`
 }
 
 type ArticlesSerializer struct {
@@ -64,7 +145,14 @@ type ArticlesSerializer struct {
 }
 
 func (s *ArticleSerializer) Response() ArticleResponse {
+	return s.response(nil, nil, nil)
+}
+
+// With covers and avatars loaded for a whole page by uploads.FindVariantsOf, and mentions by
+// mentionsResponses, looked up for this article alone when nil.
+func (s *ArticleSerializer) response(covers, avatars uploads.Variants, mentions map[mentionKey][]users.ProfileResponse) ArticleResponse {
 	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	ctx := common.RequestContext(s.C)
 	authorSerializer := ArticleUserSerializer{s.C, s.Author}
 	response := ArticleResponse{
 		ID:          s.ID,
@@ -72,26 +160,103 @@ func (s *ArticleSerializer) Response() ArticleResponse {
 		Title:       s.Title,
 		Description: s.Description,
 		Body:        s.Body,
+		BodyHTML:    common.RenderMarkdown(s.Body),
+		Excerpt:     s.Excerpt,
+		WordCount:   s.WordCount,
+		ReadingTime: s.ReadingTime,
 		CreatedAt:   s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		//UpdatedAt:      s.UpdatedAt.UTC().Format(time.RFC3339Nano),
 		UpdatedAt:      s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
-		Author:         authorSerializer.Response(),
-		Favorite:       s.isFavoriteBy(GetArticleUserModel(myUserModel)),
-		FavoritesCount: s.favoritesCount(),
+		Author:         authorSerializer.response(avatars),
+		Favorite:       s.isFavoriteBy(ctx, GetArticleUserModel(ctx, myUserModel)),
+		FavoritesCount: s.favoritesCount(ctx),
+		Mentions:       mentionsOf(s.C, mentions, mentionKey{s.ID, 0}),
+	}
+	if s.CoverImage != "" {
+		response.CoverImage = &s.CoverImage
+		if covers == nil {
+			covers = uploads.FindVariantsOf(ctx, "cover", []string{s.CoverImage})
+		}
+		response.CoverVariants = covers[s.CoverImage]
+	}
+	if response.Excerpt == "" {
+		response.Excerpt = s.Description
 	}
 	response.Tags = make([]string, 0)
 	for _, tag := range s.Tags {
 		serializer := TagSerializer{s.C, tag}
 		response.Tags = append(response.Tags, serializer.Response())
 	}
+	response.Meta = s.meta(response)
 	return response
 }
 
+func (s *ArticleSerializer) meta(article ArticleResponse) ArticleMetaResponse {
+	canonical := common.SiteURL("/article/" + s.Slug)
+	meta := ArticleMetaResponse{
+		Canonical:   canonical,
+		Title:       s.Title,
+		Description: article.Excerpt,
+	}
+	image := s.CoverImage
+	if strings.HasPrefix(image, "/") {
+		image = common.RequestBaseURL(s.C) + image
+	}
+
+	og := func(property, content string) {
+		meta.OpenGraph = append(meta.OpenGraph, MetaTag{Property: property, Content: content})
+	}
+	og("og:type", "article")
+	og("og:site_name", common.Getenv("SITE_NAME", "Conduit"))
+	og("og:title", s.Title)
+	og("og:description", article.Excerpt)
+	og("og:url", canonical)
+	if image != "" {
+		og("og:image", image)
+	}
+	og("article:published_time", s.CreatedAt.UTC().Format(time.RFC3339))
+	og("article:modified_time", s.UpdatedAt.UTC().Format(time.RFC3339))
+	og("article:author", common.SiteURL("/@"+article.Author.Username))
+	for _, tag := range article.Tags {
+		og("article:tag", tag)
+	}
+
+	twitter := func(name, content string) {
+		meta.Twitter = append(meta.Twitter, MetaTag{Name: name, Content: content})
+	}
+	if image != "" {
+		twitter("twitter:card", "summary_large_image")
+	} else {
+		twitter("twitter:card", "summary")
+	}
+	twitter("twitter:title", s.Title)
+	twitter("twitter:description", article.Excerpt)
+	if image != "" {
+		twitter("twitter:image", image)
+	}
+	return meta
+}
+
+// The image variants and the mentions of the whole page are loaded at once instead of per article.
 func (s *ArticlesSerializer) Response() []ArticleResponse {
+	ctx := common.RequestContext(s.C)
+	coverImages := []string{}
+	authors := make([]ArticleUserModel, 0, len(s.Articles))
+	ids := make([]uint, 0, len(s.Articles))
+	for _, article := range s.Articles {
+		if article.CoverImage != "" {
+			coverImages = append(coverImages, article.CoverImage)
+		}
+		authors = append(authors, article.Author)
+		ids = append(ids, article.ID)
+	}
+	covers := uploads.FindVariantsOf(ctx, "cover", coverImages)
+	avatars := uploads.FindVariantsOf(ctx, "avatar", authorImages(authors))
+	mentions := mentionsResponses(s.C, ids, nil)
 	response := []ArticleResponse{}
 	for _, article := range s.Articles {
 		serializer := ArticleSerializer{s.C, article}
-		response = append(response, serializer.Response())
+		response = append(response, serializer.response(covers, avatars, mentions))
 	}
 	return response
 }
@@ -107,30 +272,83 @@ type CommentsSerializer struct {
 }
 
 type CommentResponse struct {
-	ID        uint                  `json:"id"`
-	Body      string                `json:"body"`
-	CreatedAt string                `json:"createdAt"`
-	UpdatedAt string                `json:"updatedAt"`
-	Author    users.ProfileResponse `json:"author"`
+	ID        uint                    `json:"id"`
+	Body      string                  `json:"body"`
+	BodyHTML  string                  `json:"bodyHtml,omitempty"`
+	CreatedAt string                  `json:"createdAt"`
+	UpdatedAt string                  `json:"updatedAt"`
+	Author    users.ProfileResponse   `json:"author"`
+	Mentions  []users.ProfileResponse `json:"mentions"`
+}
+
+// Profiles of the users mentioned in the articles `articleIDs` and the comments `commentIDs`,
+// leaving out those blocked with the current user. Loaded at once for a whole page.
+func mentionsResponses(c *gin.Context, articleIDs, commentIDs []uint) map[mentionKey][]users.ProfileResponse {
+	mentions, userModels := findMentions(common.RequestContext(c), articleIDs, commentIDs, users.ContextBlockedUserIDs(c))
+	serializer := users.ProfilesSerializer{C: c, Users: userModels}
+	profiles := map[uint]users.ProfileResponse{}
+	for _, profile := range serializer.Response() {
+		profiles[profile.ID] = profile
+	}
+	response := map[mentionKey][]users.ProfileResponse{}
+	for _, mention := range mentions {
+		profile, ok := profiles[mention.UserID]
+		if !ok {
+			continue
+		}
+		key := mentionKey{mention.ArticleID, mention.CommentID}
+		response[key] = append(response[key], profile)
+	}
+	return response
+}
+
+// The mentions of `key` in the mentions of a page, looked up alone when they weren't loaded.
+func mentionsOf(c *gin.Context, mentions map[mentionKey][]users.ProfileResponse, key mentionKey) []users.ProfileResponse {
+	if mentions == nil {
+		if key.CommentID == 0 {
+			mentions = mentionsResponses(c, []uint{key.ArticleID}, nil)
+		} else {
+			mentions = mentionsResponses(c, nil, []uint{key.CommentID})
+		}
+	}
+	if profiles, ok := mentions[key]; ok {
+		return profiles
+	}
+	return []users.ProfileResponse{}
 }
 
 func (s *CommentSerializer) Response() CommentResponse {
+	return s.response(nil, nil)
+}
+
+func (s *CommentSerializer) response(avatars uploads.Variants, mentions map[mentionKey][]users.ProfileResponse) CommentResponse {
 	authorSerializer := ArticleUserSerializer{s.C, s.Author}
 	response := CommentResponse{
 		ID:        s.ID,
 		Body:      s.Body,
+		BodyHTML:  common.RenderMarkdown(s.Body),
 		CreatedAt: s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		UpdatedAt: s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
-		Author:    authorSerializer.Response(),
+		Author:    authorSerializer.response(avatars),
+		Mentions:  mentionsOf(s.C, mentions, mentionKey{s.ArticleID, s.ID}),
 	}
 	return response
 }
 
+// The avatar variants of the authors and the mentions of the whole page are loaded at once.
 func (s *CommentsSerializer) Response() []CommentResponse {
+	authors := make([]ArticleUserModel, 0, len(s.Comments))
+	ids := make([]uint, 0, len(s.Comments))
+	for _, comment := range s.Comments {
+		authors = append(authors, comment.Author)
+		ids = append(ids, comment.ID)
+	}
+	avatars := uploads.FindVariantsOf(common.RequestContext(s.C), "avatar", authorImages(authors))
+	mentions := mentionsResponses(s.C, nil, ids)
 	response := []CommentResponse{}
 	for _, comment := range s.Comments {
 		serializer := CommentSerializer{s.C, comment}
-		response = append(response, serializer.Response())
+		response = append(response, serializer.response(avatars, mentions))
 	}
 	return response
 }
@@ -1,14 +1,29 @@
 package articles
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gosimple/slug"
 	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/metrics"
+	"realworld-backend/realtime"
+	"realworld-backend/uploads"
 	"realworld-backend/users"
 )
 
@@ -18,11 +33,14 @@ var test_db *gorm.DB
 func setupTestDB() {
 	test_db = common.TestDBInit()
 	users.AutoMigrate()
+	events.AutoMigrate()
 	test_db.AutoMigrate(&ArticleModel{})
 	test_db.AutoMigrate(&TagModel{})
+	test_db.AutoMigrate(&TagFollowModel{})
 	test_db.AutoMigrate(&FavoriteModel{})
 	test_db.AutoMigrate(&ArticleUserModel{})
 	test_db.AutoMigrate(&CommentModel{})
+	test_db.AutoMigrate(&MentionModel{})
 }
 
 // Create mock user for testing
@@ -59,7 +77,7 @@ func TestArticleCreation(t *testing.T) {
 
 	// Create a test user and article user
 	userModel := createMockUser("author1", "author1@test.com")
-	articleUserModel := GetArticleUserModel(userModel)
+	articleUserModel := GetArticleUserModel(context.Background(), userModel)
 
 	// Create an article
 	article := ArticleModel{
@@ -86,7 +104,7 @@ func TestArticleValidation(t *testing.T) {
 	asserts := assert.New(t)
 
 	userModel := createMockUser("author2", "author2@test.com")
-	articleUserModel := GetArticleUserModel(userModel)
+	articleUserModel := GetArticleUserModel(context.Background(), userModel)
 
 	// Test with empty title (should still create but we can verify fields)
 	article := ArticleModel{
@@ -128,31 +146,31 @@ func TestFavoriteUnfavorite(t *testing.T) {
 
 	// Create author and article
 	author := createMockUser("author3", "author3@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 	article := createMockArticle("Favorite Test", "Description", "Body", authorArticleUser)
 
 	// Create a user who will favorite the article
 	favoriter := createMockUser("favoriter1", "favoriter1@test.com")
-	favoriterArticleUser := GetArticleUserModel(favoriter)
+	favoriterArticleUser := GetArticleUserModel(context.Background(), favoriter)
 
 	// Test favorite
-	asserts.False(article.isFavoriteBy(favoriterArticleUser), "article should not be favorited initially")
-	asserts.Equal(uint(0), article.favoritesCount(), "favorites count should be 0 initially")
+	asserts.False(article.isFavoriteBy(context.Background(), favoriterArticleUser), "article should not be favorited initially")
+	asserts.Equal(uint(0), article.favoritesCount(context.Background()), "favorites count should be 0 initially")
 
-	err := article.favoriteBy(favoriterArticleUser)
+	err := article.favoriteBy(context.Background(), favoriterArticleUser)
 	asserts.NoError(err, "favoriting should not return error")
 
 	// Reload article to check
 	var reloadedArticle ArticleModel
 	test_db.First(&reloadedArticle, article.ID)
-	asserts.True(reloadedArticle.isFavoriteBy(favoriterArticleUser), "article should be favorited")
-	asserts.Equal(uint(1), reloadedArticle.favoritesCount(), "favorites count should be 1")
+	asserts.True(reloadedArticle.isFavoriteBy(context.Background(), favoriterArticleUser), "article should be favorited")
+	asserts.Equal(uint(1), reloadedArticle.favoritesCount(context.Background()), "favorites count should be 1")
 
 	// Test unfavorite
-	err = reloadedArticle.unFavoriteBy(favoriterArticleUser)
+	err = reloadedArticle.unFavoriteBy(context.Background(), favoriterArticleUser)
 	asserts.NoError(err, "unfavoriting should not return error")
-	asserts.False(reloadedArticle.isFavoriteBy(favoriterArticleUser), "article should not be favorited after unfavorite")
-	asserts.Equal(uint(0), reloadedArticle.favoritesCount(), "favorites count should be 0 after unfavorite")
+	asserts.False(reloadedArticle.isFavoriteBy(context.Background(), favoriterArticleUser), "article should not be favorited after unfavorite")
+	asserts.Equal(uint(0), reloadedArticle.favoritesCount(context.Background()), "favorites count should be 0 after unfavorite")
 }
 
 // Task 1.2 - Test 4: Model Tests - Tag association
@@ -163,13 +181,13 @@ func TestTagAssociation(t *testing.T) {
 	asserts := assert.New(t)
 
 	author := createMockUser("author4", "author4@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 
 	article := createMockArticle("Tagged Article", "Description", "Body", authorArticleUser)
 
 	// Test setting tags
 	tags := []string{"golang", "testing", "backend"}
-	err := article.setTags(tags)
+	err := article.setTags(context.Background(), tags)
 	asserts.NoError(err, "setting tags should not return error")
 
 	// Save article with tags
@@ -199,21 +217,21 @@ func TestMultipleFavorites(t *testing.T) {
 	asserts := assert.New(t)
 
 	author := createMockUser("author5", "author5@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 	article := createMockArticle("Popular Article", "Description", "Body", authorArticleUser)
 
 	// Create multiple users who favorite the article
 	for i := 1; i <= 5; i++ {
 		user := createMockUser(fmt.Sprintf("favoriter%d", i), fmt.Sprintf("fav%d@test.com", i))
-		articleUser := GetArticleUserModel(user)
-		err := article.favoriteBy(articleUser)
+		articleUser := GetArticleUserModel(context.Background(), user)
+		err := article.favoriteBy(context.Background(), articleUser)
 		asserts.NoError(err, "favorite should succeed")
 	}
 
 	// Check favorites count
 	var reloadedArticle ArticleModel
 	test_db.First(&reloadedArticle, article.ID)
-	asserts.Equal(uint(5), reloadedArticle.favoritesCount(), "article should have 5 favorites")
+	asserts.Equal(uint(5), reloadedArticle.favoritesCount(context.Background()), "article should have 5 favorites")
 }
 
 // Task 1.2 - Test 6: Serializer Tests - ArticleSerializer output format
@@ -225,9 +243,9 @@ func TestArticleSerializer(t *testing.T) {
 
 	// Create test data
 	author := createMockUser("serialauthor", "serialauthor@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 	article := createMockArticle("Serializer Test", "Test Description", "Test Body", authorArticleUser)
-	article.setTags([]string{"test", "serializer"})
+	article.setTags(context.Background(), []string{"test", "serializer"})
 
 	// Create gin context for serializer
 	gin.SetMode(gin.TestMode)
@@ -256,7 +274,7 @@ func TestArticlesSerializer(t *testing.T) {
 	asserts := assert.New(t)
 
 	author := createMockUser("listauthor", "listauthor@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 
 	// Create multiple articles
 	articles := []ArticleModel{
@@ -278,6 +296,100 @@ func TestArticlesSerializer(t *testing.T) {
 	asserts.Equal("Article 3", response[2].Title, "third article title should match")
 }
 
+func TestArticlesSerializerVariants(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+	uploads.AutoMigrate()
+	storage, _ := uploads.NewLocalStorage(t.TempDir(), "/uploads")
+	uploads.SetStorage(storage)
+	defer uploads.SetStorage(nil)
+
+	asserts := assert.New(t)
+
+	author := createMockUser("variantauthor", "variantauthor@test.com")
+	avatar := "/uploads/2025/01/avatar.png"
+	test_db.Model(&author).Update("image", avatar)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
+	articles := []ArticleModel{}
+	for i := 0; i < 3; i++ {
+		article := createMockArticle(fmt.Sprintf("Variant Article %d", i), "Description", "Body", authorArticleUser)
+		article.CoverImage = fmt.Sprintf("/uploads/2025/01/cover-%d.png", i)
+		test_db.Save(&article)
+		test_db.Create(&uploads.ImageVariantModel{SourceURL: article.CoverImage, Preset: "cover", Size: 400, Key: fmt.Sprintf("variants/%d/cover-400.jpg", i)})
+		articles = append(articles, article)
+	}
+	test_db.Create(&uploads.ImageVariantModel{SourceURL: avatar, Preset: "avatar", Size: 64, Key: "variants/a/avatar-64.jpg"})
+
+	queries := 0
+	test_db.Callback().Query().After("gorm:query").Register("test:count_variants", func(scope *gorm.Scope) {
+		if scope.TableName() == "image_variant_models" {
+			queries++
+		}
+	})
+	defer test_db.Callback().Query().Remove("test:count_variants")
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(nil)
+	c.Set("my_user_model", author)
+	serializer := ArticlesSerializer{C: c, Articles: articles}
+	response := serializer.Response()
+
+	asserts.Len(response, 3, "should serialize 3 articles")
+	asserts.Equal(2, queries, "covers and avatars of a page should be loaded in one query each")
+	for _, article := range response {
+		asserts.Equal("/uploads/variants/a/avatar-64.jpg", article.Author.ImageVariants["64"], "author avatar variants should be serialized")
+		asserts.Regexp(`^/uploads/variants/\d/cover-400\.jpg$`, article.CoverVariants["400"], "cover variants should be serialized")
+	}
+}
+
+func TestArticlesSerializerMentions(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	author := createMockUser("mentionsauthor", "mentionsauthor@test.com")
+	reader := createMockUser("mentionsreader", "mentionsreader@test.com")
+	carol := createMockUser("carol", "carol@mentions.test")
+	dave := createMockUser("dave", "dave@mentions.test")
+	test_db.Create(&users.BlockModel{BlockerID: reader.ID, BlockedID: dave.ID})
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
+	articles := []ArticleModel{}
+	comments := []CommentModel{}
+	for i := 0; i < 3; i++ {
+		article := createMockArticle(fmt.Sprintf("Mentions Article %d", i), "Description", "Body", authorArticleUser)
+		test_db.Create(&MentionModel{ArticleID: article.ID, UserID: carol.ID})
+		test_db.Create(&MentionModel{ArticleID: article.ID, UserID: dave.ID})
+		articles = append(articles, article)
+		comment := CommentModel{Body: "@carol", Article: articles[0], ArticleID: articles[0].ID, Author: authorArticleUser, AuthorID: authorArticleUser.ID}
+		test_db.Create(&comment)
+		test_db.Create(&MentionModel{ArticleID: articles[0].ID, CommentID: comment.ID, UserID: carol.ID})
+		comments = append(comments, comment)
+	}
+
+	queries := map[string]int{}
+	test_db.Callback().Query().After("gorm:query").Register("test:count_mentions", func(scope *gorm.Scope) {
+		queries[scope.TableName()]++
+	})
+	defer test_db.Callback().Query().Remove("test:count_mentions")
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(nil)
+	users.UpdateContextUserModel(c, reader.ID)
+	queries = map[string]int{}
+	articlesSerializer := ArticlesSerializer{C: c, Articles: articles}
+	for _, article := range articlesSerializer.Response() {
+		asserts.Len(article.Mentions, 1, "users blocked with the reader should be left out")
+		asserts.Equal("carol", article.Mentions[0].Username)
+	}
+	commentsSerializer := CommentsSerializer{C: c, Comments: comments}
+	for _, comment := range commentsSerializer.Response() {
+		asserts.Len(comment.Mentions, 1, "comments should list their own mentions")
+	}
+	asserts.Equal(2, queries["mention_models"], "the mentions of a page should be loaded in one query")
+	asserts.Equal(1, queries["block_models"], "the blocks of the reader should be loaded once per request")
+}
+
 // Task 1.2 - Test 8: Serializer Tests - CommentSerializer structure
 func TestCommentSerializer(t *testing.T) {
 	setupTestDB()
@@ -286,12 +398,12 @@ func TestCommentSerializer(t *testing.T) {
 	asserts := assert.New(t)
 
 	author := createMockUser("commentauthor", "commentauthor@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 	article := createMockArticle("Commented Article", "Description", "Body", authorArticleUser)
 
 	// Create a comment
 	commenter := createMockUser("commenter1", "commenter1@test.com")
-	commenterArticleUser := GetArticleUserModel(commenter)
+	commenterArticleUser := GetArticleUserModel(context.Background(), commenter)
 
 	comment := CommentModel{
 		Article:   article,
@@ -338,7 +450,7 @@ func TestArticleModelValidatorValid(t *testing.T) {
 	validator.articleModel.Title = validator.Article.Title
 	validator.articleModel.Description = validator.Article.Description
 	validator.articleModel.Body = validator.Article.Body
-	validator.articleModel.Author = GetArticleUserModel(user)
+	validator.articleModel.Author = GetArticleUserModel(context.Background(), user)
 
 	asserts.Equal("Valid Title", validator.articleModel.Title, "title should be set")
 	asserts.Equal("Valid Description", validator.articleModel.Description, "description should be set")
@@ -380,7 +492,7 @@ func TestCommentModelValidator(t *testing.T) {
 
 	// Manually set the comment model fields (simulating Bind)
 	validator.commentModel.Body = validator.Comment.Body
-	validator.commentModel.Author = GetArticleUserModel(user)
+	validator.commentModel.Author = GetArticleUserModel(context.Background(), user)
 
 	asserts.Equal("This is a valid comment body", validator.commentModel.Body, "comment body should be set")
 	asserts.NotNil(validator.commentModel.Author, "author should be set")
@@ -394,11 +506,11 @@ func TestFindOneArticle(t *testing.T) {
 	asserts := assert.New(t)
 
 	author := createMockUser("findauthor", "findauthor@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 	article := createMockArticle("Find Me", "Description", "Body", authorArticleUser)
 
 	// Find by ID
-	foundArticle, err := FindOneArticle(&ArticleModel{
+	foundArticle, err := FindOneArticle(context.Background(), &ArticleModel{
 		Model: gorm.Model{ID: article.ID},
 	})
 
@@ -415,7 +527,7 @@ func TestSaveOne(t *testing.T) {
 	asserts := assert.New(t)
 
 	author := createMockUser("saveauthor", "saveauthor@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 	article := createMockArticle("Save Test", "Description", "Body", authorArticleUser)
 
 	// Modify and save
@@ -438,7 +550,7 @@ func TestDeleteArticleModel(t *testing.T) {
 	asserts := assert.New(t)
 
 	author := createMockUser("deleteauthor", "deleteauthor@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 	article := createMockArticle("Delete Me", "Description", "Body", authorArticleUser)
 
 	// Delete the article
@@ -462,11 +574,11 @@ func TestCommentCreationAndRetrieval(t *testing.T) {
 	asserts := assert.New(t)
 
 	author := createMockUser("articleauthor", "articleauthor@test.com")
-	authorArticleUser := GetArticleUserModel(author)
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
 	article := createMockArticle("Commented Article", "Description", "Body", authorArticleUser)
 
 	commenter := createMockUser("commenter2", "commenter2@test.com")
-	commenterArticleUser := GetArticleUserModel(commenter)
+	commenterArticleUser := GetArticleUserModel(context.Background(), commenter)
 
 	// Create comment
 	comment := CommentModel{
@@ -495,13 +607,13 @@ func TestGetArticleUserModel(t *testing.T) {
 	user := createMockUser("articlesuser", "articlesuser@test.com")
 
 	// Get article user model
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 	asserts.NotZero(articleUser.ID, "article user should have ID")
 	asserts.Equal(user.ID, articleUser.UserModelID, "user model ID should match")
 
 	// Test with zero user
 	emptyUser := users.UserModel{}
-	articleUser2 := GetArticleUserModel(emptyUser)
+	articleUser2 := GetArticleUserModel(context.Background(), emptyUser)
 	asserts.Zero(articleUser2.ID, "article user for empty user should have zero ID")
 }
 
@@ -552,3 +664,832 @@ func TestTagsSerializer(t *testing.T) {
 	asserts.Contains(response, "testing", "should contain testing")
 	asserts.Contains(response, "backend", "should contain backend")
 }
+
+// Test comment listing pagination: limit, cursor, order and batched authors
+func TestCommentsPage(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	author := createMockUser("pageauthor", "pageauthor@test.com")
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
+	article := createMockArticle("Paged Article", "Description", "Body", authorArticleUser)
+
+	commenter := createMockUser("pagecommenter", "pagecommenter@test.com")
+	commenterArticleUser := GetArticleUserModel(context.Background(), commenter)
+	for i := 1; i <= 5; i++ {
+		test_db.Create(&CommentModel{
+			ArticleID: article.ID,
+			AuthorID:  commenterArticleUser.ID,
+			Body:      fmt.Sprintf("comment %v", i),
+		})
+	}
+
+	page, err := article.getCommentsPage(context.Background(), "2", "", "", nil)
+	asserts.NoError(err, "first page should load")
+	asserts.Equal(5, page.Count, "count should cover all comments")
+	asserts.Len(page.Comments, 2, "page should respect limit")
+	asserts.Equal("comment 1", page.Comments[0].Body, "default order should be oldest first")
+	asserts.Equal("pagecommenter", page.Comments[0].Author.UserModel.Username, "authors should be loaded")
+	asserts.NotEmpty(page.NextCursor, "first page should have a next cursor")
+
+	page, err = article.getCommentsPage(context.Background(), "2", page.NextCursor, "", nil)
+	asserts.NoError(err, "second page should load")
+	asserts.Equal("comment 3", page.Comments[0].Body, "cursor should continue after the last comment")
+
+	page, err = article.getCommentsPage(context.Background(), "10", "", "desc", nil)
+	asserts.NoError(err, "desc page should load")
+	asserts.Len(page.Comments, 5, "all comments should fit")
+	asserts.Equal("comment 5", page.Comments[0].Body, "desc order should be newest first")
+	asserts.Empty(page.NextCursor, "last page should not have a next cursor")
+
+	_, err = article.getCommentsPage(context.Background(), "10", "abc", "", nil)
+	asserts.Equal(errInvalidCursor, err, "invalid cursor should return error")
+	_, err = article.getCommentsPage(context.Background(), "10", "", "sideways", nil)
+	asserts.Equal(errInvalidOrder, err, "invalid order should return error")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, commenter.ID)
+	})
+	ArticlesAnonymousRegister(r.Group("/api/articles"))
+	list := func(query string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/articles/paged-article/comments"+query, nil)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	asserts.Equal(http.StatusOK, list("?limit=2"))
+	asserts.Equal(http.StatusUnprocessableEntity, list("?cursor=abc"), "malformed cursors are the client's mistake")
+	asserts.Equal(http.StatusUnprocessableEntity, list("?order=sideways"), "malformed orders are the client's mistake")
+	test_db.Exec("ALTER TABLE comment_models RENAME TO comment_models_away")
+	code := list("")
+	test_db.Exec("ALTER TABLE comment_models_away RENAME TO comment_models")
+	asserts.Equal(http.StatusInternalServerError, code, "database failures are not the client's mistake")
+}
+
+// Test article and comment bodies are rendered as sanitized html
+func TestSerializerBodyHTML(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	author := createMockUser("mdauthor", "mdauthor@test.com")
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
+	article := createMockArticle("Markdown Article", "Description", "*hi* <img src=x onerror=alert(1)>", authorArticleUser)
+	comment := CommentModel{ArticleID: article.ID, Author: authorArticleUser, AuthorID: authorArticleUser.ID, Body: "`code`"}
+	test_db.Create(&comment)
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(nil)
+	c.Set("my_user_model", author)
+
+	articleSerializer := ArticleSerializer{C: c, ArticleModel: article}
+	response := articleSerializer.Response()
+	asserts.Contains(response.BodyHTML, "<em>hi</em>", "markdown should be rendered")
+	asserts.NotContains(response.BodyHTML, "onerror", "html should be sanitized")
+
+	commentSerializer := CommentSerializer{C: c, CommentModel: comment}
+	asserts.Contains(commentSerializer.Response().BodyHTML, "<code>code</code>", "comment markdown should be rendered")
+}
+
+// Tables created before bodies were Markdown, with varchar(2048) bodies.
+type legacyArticleModel struct {
+	gorm.Model
+	Slug  string `gorm:"unique_index"`
+	Title string
+	Body  string `gorm:"size:2048"`
+}
+
+func (legacyArticleModel) TableName() string { return "article_models" }
+
+type legacyCommentModel struct {
+	gorm.Model
+	ArticleID uint
+	Body      string `gorm:"size:2048"`
+}
+
+func (legacyCommentModel) TableName() string { return "comment_models" }
+
+// Test long bodies fit in a database created with the old columns
+func TestMigrateBodies(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	test_db.DropTable(&ArticleModel{}, &CommentModel{})
+	test_db.CreateTable(&legacyArticleModel{}, &legacyCommentModel{})
+	var schema string
+	test_db.Raw("SELECT sql FROM sqlite_master WHERE name = 'article_models'").Row().Scan(&schema)
+	asserts.Contains(schema, "varchar(2048)", "the table should have the old column")
+
+	AutoMigrateArticles(test_db)
+	asserts.NoError(MigrateBodies(test_db))
+
+	author := GetArticleUserModel(context.Background(), createMockUser("longauthor", "longauthor@test.com"))
+	body := strings.Repeat("a", 65535)
+	article := createMockArticle("Long Article", "Description", body, author)
+	comment := CommentModel{ArticleID: article.ID, AuthorID: author.ID, Body: body}
+	asserts.NoError(test_db.Create(&comment).Error)
+
+	var savedArticle ArticleModel
+	test_db.First(&savedArticle, article.ID)
+	asserts.Equal(body, savedArticle.Body, "long article bodies should be kept whole")
+	var savedComment CommentModel
+	test_db.First(&savedComment, comment.ID)
+	asserts.Equal(body, savedComment.Body, "long comment bodies should be kept whole")
+}
+
+// Test word count, reading time and excerpt are computed on save and on update
+func TestArticleReadingStats(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	author := createMockUser("statsauthor", "statsauthor@test.com")
+	authorArticleUser := GetArticleUserModel(context.Background(), author)
+
+	body := "# Heading\n\n" + strings.Repeat("word ", 450)
+	article := createMockArticle("Stats Article", "", body, authorArticleUser)
+	asserts.Equal(451, article.WordCount, "markdown syntax should not be counted")
+	asserts.Equal(3, article.ReadingTime, "reading time should round up")
+	asserts.True(strings.HasPrefix(article.Excerpt, "Heading word word"), "excerpt should be plain text")
+	asserts.True(strings.HasSuffix(article.Excerpt, "…"), "long excerpt should be truncated")
+
+	err := article.Update(ArticleModel{Body: "just three words", Description: "Now described"})
+	asserts.NoError(err, "update should succeed")
+
+	stored, _ := FindOneArticle(context.Background(), &ArticleModel{Slug: article.Slug})
+	asserts.Equal(3, stored.WordCount, "word count should be recomputed on update")
+	asserts.Equal(1, stored.ReadingTime, "reading time should be recomputed on update")
+	asserts.Equal("", stored.Excerpt, "excerpt should be cleared once a description is set")
+}
+
+// Test cover images are bound from the request and returned in the response
+func TestArticleCoverImage(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	user := createMockUser("coveruser", "coveruser@test.com")
+	gin.SetMode(gin.TestMode)
+
+	bind := func(bodyData string) (ArticleModelValidator, error) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/articles/", bytes.NewBufferString(bodyData))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set("my_user_model", user)
+		validator := NewArticleModelValidator()
+		err := validator.Bind(c)
+		return validator, err
+	}
+
+	validator, err := bind(`{"article":{"title":"Cover Article","body":"Body","coverImage":"/uploads/2025/01/cover.png"}}`)
+	asserts.NoError(err, "uploaded cover image should be accepted")
+	asserts.Equal("/uploads/2025/01/cover.png", validator.articleModel.CoverImage, "cover image should be set")
+
+	_, err = bind(`{"article":{"title":"Cover Article","body":"Body","coverImage":"javascript:alert(1)"}}`)
+	asserts.Error(err, "unsafe cover image should be rejected")
+
+	SaveOne(&validator.articleModel)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("my_user_model", user)
+	serializer := ArticleSerializer{C: c, ArticleModel: validator.articleModel}
+	asserts.Equal("/uploads/2025/01/cover.png", *serializer.Response().CoverImage, "cover image should be serialized")
+
+	article := createMockArticle("No Cover", "Description", "Body", GetArticleUserModel(context.Background(), user))
+	serializer = ArticleSerializer{C: c, ArticleModel: article}
+	asserts.Nil(serializer.Response().CoverImage, "missing cover image should be null")
+}
+
+func TestArticleMeta(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	os.Setenv("SITE_URL", "https://conduit.test/")
+	defer os.Unsetenv("SITE_URL")
+
+	user := createMockUser("metauser", "metauser@test.com")
+	article := createMockArticle("Meta Article", "", "Some **body** text", GetArticleUserModel(context.Background(), user))
+	article.setTags(context.Background(), []string{"seo"})
+	SaveOne(&article)
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/api/articles/meta-article", nil)
+	c.Request.Host = "api.conduit.test"
+	c.Request.Header.Set("X-Forwarded-Proto", "https")
+	c.Set("my_user_model", user)
+
+	meta := (&ArticleSerializer{C: c, ArticleModel: article}).Response().Meta
+	asserts.Equal("https://conduit.test/article/meta-article", meta.Canonical, "canonical should be on the frontend")
+	asserts.Equal("Some body text", meta.Description, "description should fall back to the excerpt")
+	asserts.Contains(meta.OpenGraph, MetaTag{Property: "og:url", Content: meta.Canonical}, "og:url should be canonical")
+	asserts.Contains(meta.OpenGraph, MetaTag{Property: "article:author", Content: "https://conduit.test/@metauser"}, "author should be linked")
+	asserts.Contains(meta.OpenGraph, MetaTag{Property: "article:tag", Content: "seo"}, "tags should be listed")
+	asserts.Contains(meta.Twitter, MetaTag{Name: "twitter:card", Content: "summary"}, "articles without image should use summary card")
+	for _, tag := range meta.OpenGraph {
+		asserts.NotEqual("og:image", tag.Property, "articles without image should not have og:image")
+	}
+
+	article.CoverImage = "/uploads/cover.png"
+	meta = (&ArticleSerializer{C: c, ArticleModel: article}).Response().Meta
+	asserts.Contains(meta.OpenGraph, MetaTag{Property: "og:image", Content: "https://api.conduit.test/uploads/cover.png"}, "uploaded images should be absolute")
+	asserts.Contains(meta.Twitter, MetaTag{Name: "twitter:card", Content: "summary_large_image"}, "articles with image should use large card")
+}
+
+func TestTagNormalization(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	asserts.Equal("go", normalizeTag(" Go "), "tags should be trimmed and lower cased")
+	asserts.Equal("machine-learning", normalizeTag("Machine   Learning"), "spaces should become dashes")
+	asserts.Equal("", normalizeTag("   "), "blank tags should be empty")
+
+	user := createMockUser("tagnormuser", "tagnormuser@test.com")
+	article := createMockArticle("Normalized Tags", "Description", "Body", GetArticleUserModel(context.Background(), user))
+	article.setTags(context.Background(), []string{"Go", "go", " GO ", "", "Web Dev"})
+	SaveOne(&article)
+
+	var reloaded ArticleModel
+	test_db.Preload("Tags").First(&reloaded, article.ID)
+	asserts.Equal(2, len(reloaded.Tags), "duplicate and blank tags should be dropped")
+	count := 0
+	test_db.Model(&TagModel{}).Count(&count)
+	asserts.Equal(2, count, "only normalized tags should be created")
+
+	articleModels, _, _ := FindManyArticle(context.Background(), "GO", "", "", "", "", nil)
+	asserts.Equal(1, len(articleModels), "filtering by tag should be case insensitive")
+
+	// Tags created before normalization are folded together with their articles.
+	legacy := createMockArticle("Legacy Tags", "Description", "Body", GetArticleUserModel(context.Background(), user))
+	upper := TagModel{Tag: "Web-Dev"}
+	spaced := TagModel{Tag: "Rust Lang"}
+	test_db.Create(&upper)
+	test_db.Create(&spaced)
+	test_db.Model(&legacy).Association("Tags").Append(upper, spaced)
+	test_db.Model(&reloaded).Association("Tags").Append(upper)
+
+	asserts.NoError(NormalizeTags(), "normalizing tags should not fail")
+	webDev, err := FindOneTag("web-dev")
+	asserts.NoError(err, "normalized tag should exist")
+	asserts.Equal(2, webDev.ArticlesCount, "merged tag should keep every article once")
+	_, err = FindOneTag("rust-lang")
+	asserts.NoError(err, "tag without a normalized twin should be renamed")
+	test_db.Model(&TagModel{}).Count(&count)
+	asserts.Equal(3, count, "duplicate tags should be removed")
+}
+
+func TestTagList(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	user := createMockUser("taglistuser", "taglistuser@test.com")
+	author := GetArticleUserModel(context.Background(), user)
+	for i, tags := range [][]string{{"go", "web"}, {"go"}, {"go", "rust"}, {"web"}} {
+		article := createMockArticle(fmt.Sprintf("Tag List %v", i), "Description", "Body", author)
+		article.setTags(context.Background(), tags)
+		SaveOne(&article)
+	}
+	deleted := createMockArticle("Deleted Tagged", "Description", "Body", author)
+	deleted.setTags(context.Background(), []string{"rust", "zig"})
+	SaveOne(&deleted)
+	DeleteArticleModel(&ArticleModel{Slug: deleted.Slug})
+	test_db.Create(&TagModel{Tag: "unused"})
+
+	tagModels, count, err := getAllTags(context.Background(), "", "", "", 0)
+	asserts.NoError(err, "listing tags should not fail")
+	asserts.Equal(3, count, "unused tags and tags of deleted articles should not be counted")
+	asserts.Equal([]string{"go", "web", "rust"}, (&TagsSerializer{Tags: tagModels}).Response(), "tags should be sorted by popularity")
+	asserts.Equal(3, tagModels[0].ArticlesCount, "tags should have their article count")
+	asserts.Equal(1, tagModels[2].ArticlesCount, "deleted articles should not be counted")
+
+	tagModels, count, _ = getAllTags(context.Background(), "2", "1", "name", 0)
+	asserts.Equal(3, count, "count should not depend on the page")
+	asserts.Equal([]string{"rust", "web"}, (&TagsSerializer{Tags: tagModels}).Response(), "tags should be paginated by name")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, 0)
+	})
+	TagsAnonymousRegister(r.Group("/api/tags"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/tags/Go", nil)
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code, "tag should be found by any case")
+	asserts.JSONEq(`{"tag":{"tag":"go","description":"","articlesCount":3,"following":false}}`, w.Body.String(), "tag details should be returned")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/tags/?limit=1", nil)
+	r.ServeHTTP(w, req)
+	asserts.JSONEq(`{"tags":["go"],"tagDetails":[{"tag":"go","description":"","articlesCount":3,"following":false}],"tagsCount":3}`, w.Body.String(), "tag list should keep the plain names")
+}
+
+func TestTagAdmin(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	admin := createMockUser("tagadmin", "tagadmin@test.com")
+	test_db.Model(&admin).Update("is_admin", true)
+	member := createMockUser("tagmember", "tagmember@test.com")
+	author := GetArticleUserModel(context.Background(), member)
+	for i, tags := range [][]string{{"golang", "go"}, {"golang"}, {"js"}} {
+		article := createMockArticle(fmt.Sprintf("Admin Tags %v", i), "Description", "Body", author)
+		article.setTags(context.Background(), tags)
+		SaveOne(&article)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var current users.UserModel
+	r.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, current.ID)
+	})
+	TagsRegister(r.Group("/api/tags"))
+	request := func(user users.UserModel, method, url, body string) *httptest.ResponseRecorder {
+		current = user
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(member, "DELETE", "/api/tags/js", "")
+	asserts.Equal(http.StatusForbidden, w.Code, "members should not manage tags")
+
+	w = request(admin, "PUT", "/api/tags/js", `{"tag":{"tag":"go"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "renaming onto an existing tag should be refused")
+
+	w = request(admin, "PUT", "/api/tags/js", `{"tag":{"tag":"JavaScript","description":"The language of the web"}}`)
+	asserts.Equal(http.StatusOK, w.Code, "admins should rename tags")
+	asserts.JSONEq(`{"tag":{"tag":"javascript","description":"The language of the web","articlesCount":1,"following":false}}`, w.Body.String(), "renamed tag should be normalized")
+
+	w = request(admin, "POST", "/api/tags/golang/merge", `{"into":"golang"}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "a tag should not be merged into itself")
+
+	w = request(admin, "POST", "/api/tags/golang/merge", `{"into":"go"}`)
+	asserts.Equal(http.StatusOK, w.Code, "admins should merge tags")
+	asserts.JSONEq(`{"tag":{"tag":"go","description":"","articlesCount":2,"following":false}}`, w.Body.String(), "merged tag should have the articles of both")
+	_, err := FindOneTag("golang")
+	asserts.Error(err, "merged tag should be gone")
+	links := 0
+	test_db.Table("article_tags").Count(&links)
+	asserts.Equal(3, links, "articles having both tags should keep a single link")
+
+	w = request(admin, "DELETE", "/api/tags/go", "")
+	asserts.Equal(http.StatusOK, w.Code, "admins should delete tags")
+	test_db.Table("article_tags").Count(&links)
+	asserts.Equal(1, links, "deleted tag should be removed from its articles")
+
+	w = request(admin, "DELETE", "/api/tags/go", "")
+	asserts.Equal(http.StatusNotFound, w.Code, "deleting a missing tag should return 404")
+
+	article := createMockArticle("Tag Comes Back", "Description", "Body", author)
+	asserts.NoError(article.setTags(context.Background(), []string{"go"}), "a deleted tag should be created again")
+}
+
+func TestTagFollow(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	reader := createMockUser("tagreader", "tagreader@test.com")
+	writer := createMockUser("tagwriter", "tagwriter@test.com")
+	other := createMockUser("tagother", "tagother@test.com")
+	test_db.Create(&users.FollowModel{FollowingID: writer.ID, FollowedByID: reader.ID})
+	for i, data := range []struct {
+		author users.UserModel
+		tags   []string
+	}{
+		{writer, []string{"go"}},    // followed author and tag, listed once
+		{writer, []string{"rust"}},  // followed author
+		{other, []string{"go"}},     // followed tag
+		{other, []string{"python"}}, // neither
+	} {
+		article := createMockArticle(fmt.Sprintf("Feed Article %v", i), "Description", "Body", GetArticleUserModel(context.Background(), data.author))
+		article.setTags(context.Background(), data.tags)
+		SaveOne(&article)
+	}
+	test_db.Create(&TagModel{Tag: "unused"})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var current users.UserModel
+	r.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, current.ID)
+	})
+	TagsAnonymousRegister(r.Group("/api/tags"))
+	TagsRegister(r.Group("/api/tags"))
+	request := func(user users.UserModel, method, url string) *httptest.ResponseRecorder {
+		current = user
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(reader, "POST", "/api/tags/Go/follow")
+	asserts.Equal(http.StatusOK, w.Code, "following a tag should succeed")
+	asserts.JSONEq(`{"tag":{"tag":"go","description":"","articlesCount":2,"following":true}}`, w.Body.String(), "followed tag should be flagged")
+	request(reader, "POST", "/api/tags/go/follow")
+	request(reader, "POST", "/api/tags/unused/follow")
+	w = request(reader, "POST", "/api/tags/missing/follow")
+	asserts.Equal(http.StatusNotFound, w.Code, "following a missing tag should return 404")
+
+	w = request(reader, "GET", "/api/tags/?sort=name")
+	asserts.JSONEq(`{"tags":["go","python","rust"],"tagDetails":[
+		{"tag":"go","description":"","articlesCount":2,"following":true},
+		{"tag":"python","description":"","articlesCount":1,"following":false},
+		{"tag":"rust","description":"","articlesCount":1,"following":false}],"tagsCount":3}`, w.Body.String(), "tag list should flag followed tags")
+
+	w = request(reader, "GET", "/api/tags/?following=true&sort=name")
+	asserts.JSONEq(`{"tags":["go","unused"],"tagDetails":[
+		{"tag":"go","description":"","articlesCount":2,"following":true},
+		{"tag":"unused","description":"","articlesCount":0,"following":true}],"tagsCount":2}`, w.Body.String(), "followed tags should be listed even when unused")
+	w = request(users.UserModel{}, "GET", "/api/tags/?following=true")
+	asserts.Equal(http.StatusUnauthorized, w.Code, "followed tags should require auth")
+
+	readerArticleUser := GetArticleUserModel(context.Background(), reader)
+	articleModels, count, err := readerArticleUser.GetArticleFeed(context.Background(), "", "")
+	asserts.NoError(err, "feed should load")
+	asserts.Equal(3, count, "feed should count followed authors and tags once")
+	titles := []string{}
+	for _, article := range articleModels {
+		titles = append(titles, article.Title)
+	}
+	asserts.ElementsMatch([]string{"Feed Article 0", "Feed Article 1", "Feed Article 2"}, titles, "feed should mix followed authors and tags")
+
+	// Followers move with a merged tag.
+	goTag, _ := FindOneTag("go")
+	rustTag, _ := FindOneTag("rust")
+	asserts.NoError(mergeTags(goTag, rustTag), "merging should not fail")
+	asserts.True(rustTag.isFollowedBy(context.Background(), readerArticleUser), "followers should follow the merged tag")
+	follows := 0
+	test_db.Model(&TagFollowModel{}).Where("tag_id = ?", goTag.ID).Count(&follows)
+	asserts.Equal(0, follows, "follows of the merged tag should be gone")
+
+	w = request(reader, "DELETE", "/api/tags/rust/follow")
+	asserts.Equal(http.StatusOK, w.Code, "unfollowing a tag should succeed")
+	asserts.Contains(w.Body.String(), `"following":false`, "unfollowed tag should not be flagged")
+	_, count, _ = readerArticleUser.GetArticleFeed(context.Background(), "", "")
+	asserts.Equal(2, count, "unfollowed tag should leave the feed")
+}
+
+func TestBlockAndMute(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	reader := createMockUser("blockreader", "blockreader@test.com")
+	blocker := createMockUser("blocker", "blocker@test.com")
+	muted := createMockUser("mutedwriter", "mutedwriter@test.com")
+	test_db.Create(&users.BlockModel{BlockerID: blocker.ID, BlockedID: reader.ID})
+	test_db.Create(&users.MuteModel{MuterID: reader.ID, MutedID: muted.ID})
+	test_db.Create(&users.FollowModel{FollowingID: muted.ID, FollowedByID: reader.ID})
+
+	blockerArticle := createMockArticle("Blocker Article", "Description", "Body", GetArticleUserModel(context.Background(), blocker))
+	blockerArticle.setTags(context.Background(), []string{"shared"})
+	SaveOne(&blockerArticle)
+	mutedArticle := createMockArticle("Muted Article", "Description", "Body", GetArticleUserModel(context.Background(), muted))
+	mutedArticle.setTags(context.Background(), []string{"shared"})
+	SaveOne(&mutedArticle)
+	mutedArticle.favoriteBy(context.Background(), GetArticleUserModel(context.Background(), blocker))
+	test_db.Create(&FavoriteModel{FavoriteID: blockerArticle.ID, FavoriteByID: GetArticleUserModel(context.Background(), muted).ID})
+
+	hidden := reader.BlockedUserIDs(context.Background())
+	articleModels, count, _ := FindManyArticle(context.Background(), "", "", "", "", "", hidden)
+	asserts.Equal(1, count, "articles of blocking users should not be listed")
+	asserts.Equal("Muted Article", articleModels[0].Title, "articles of muted users should stay in lists")
+	_, count, _ = FindManyArticle(context.Background(), "shared", "", "", "", "", hidden)
+	asserts.Equal(1, count, "tag lists should leave out blocking users")
+	_, count, _ = FindManyArticle(context.Background(), "", "blocker", "", "", "", hidden)
+	asserts.Equal(0, count, "author page of a blocking user should be empty")
+	_, count, _ = FindManyArticle(context.Background(), "", "", "", "", "blocker", hidden)
+	asserts.Equal(0, count, "favorites of a blocking user should be empty")
+	_, count, _ = FindManyArticle(context.Background(), "", "", "", "", "mutedwriter", hidden)
+	asserts.Equal(0, count, "favorited articles of blocking users should be left out")
+	_, count, _ = FindManyArticle(context.Background(), "", "", "", "", "", nil)
+	asserts.Equal(2, count, "anonymous lists should not be filtered")
+	articleModels, count, _ = FindManyArticle(context.Background(), "", "", "", "", "blocker", nil)
+	asserts.Equal(1, count, "favorited articles should be listed")
+	asserts.Equal("Muted Article", articleModels[0].Title, "favorited articles should be loaded")
+	asserts.Equal("mutedwriter", articleModels[0].Author.UserModel.Username, "favorited articles should come with their author")
+
+	readerArticleUser := GetArticleUserModel(context.Background(), reader)
+	_, count, _ = readerArticleUser.GetArticleFeed(context.Background(), "", "")
+	asserts.Equal(0, count, "muted authors should be left out of the feed")
+
+	asserts.Equal(users.ErrBlocked, blockerArticle.favoriteBy(context.Background(), readerArticleUser), "blocked users should not favorite")
+	asserts.NoError(mutedArticle.favoriteBy(context.Background(), readerArticleUser), "muted authors can still be favorited")
+
+	SaveOne(&CommentModel{ArticleID: blockerArticle.ID, AuthorID: GetArticleUserModel(context.Background(), muted).ID, Body: "muted comment"})
+	SaveOne(&CommentModel{ArticleID: blockerArticle.ID, AuthorID: GetArticleUserModel(context.Background(), blocker).ID, Body: "blocker comment"})
+	SaveOne(&CommentModel{ArticleID: blockerArticle.ID, AuthorID: readerArticleUser.ID, Body: "visible comment"})
+	page, _ := blockerArticle.getCommentsPage(context.Background(), "", "", "", reader.HiddenUserIDs(context.Background()))
+	asserts.Equal(1, page.Count, "comments of muted and blocking users should be hidden")
+	asserts.Equal("visible comment", page.Comments[0].Body, "other comments should be listed")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, reader.ID)
+	})
+	ArticlesRegister(r.Group("/api/articles"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/articles/blocker-article/comments", bytes.NewBufferString(`{"comment":{"body":"hello"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusForbidden, w.Code, "blocked users should not comment")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/articles/blocker-article/favorite", nil)
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusForbidden, w.Code, "blocked users should not favorite")
+
+	// Blocks work in both directions: neither user can open the other's articles.
+	ArticlesAnonymousRegister(r.Group("/api/articles"))
+	for _, url := range []string{"/api/articles/blocker-article", "/api/articles/blocker-article/comments", "/api/articles/blocker-article/events"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", url, nil)
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusNotFound, w.Code, url+" should be missing for blocked users")
+	}
+	readerArticle := createMockArticle("Reader Article", "Description", "Body", readerArticleUser)
+	blockerRouter := gin.New()
+	blockerRouter.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, blocker.ID)
+	})
+	ArticlesAnonymousRegister(blockerRouter.Group("/api/articles"))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/articles/"+readerArticle.Slug, nil)
+	blockerRouter.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code, "articles of blocked users should be missing for the blocker")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/articles/muted-article", nil)
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code, "articles of muted users should stay reachable")
+}
+
+func TestMentions(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	writer := createMockUser("mentionwriter", "mentionwriter@test.com")
+	alice := createMockUser("alice", "alice@mentions.test")
+	bob := createMockUser("bob", "bob@mentions.test")
+	blocker := createMockUser("mentionblocker", "mentionblocker@test.com")
+	test_db.Create(&users.BlockModel{BlockerID: blocker.ID, BlockedID: writer.ID})
+	// Mentions of the article, or of one of its comments.
+	mentionedUsers := func(articleID, commentID uint, hidden []uint) []MentionModel {
+		if commentID == 0 {
+			mentions, _ := findMentions(context.Background(), []uint{articleID}, nil, hidden)
+			return mentions
+		}
+		mentions, _ := findMentions(context.Background(), nil, []uint{commentID}, hidden)
+		return mentions
+	}
+
+	var mentioned []events.UserMentioned
+	events.Subscribe("test", func(event events.UserMentioned) error {
+		mentioned = append(mentioned, event)
+		return nil
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, writer.ID)
+	})
+	ArticlesRegister(r.Group("/api/articles"))
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/articles/", `{"article":{"title":"Mention Article","description":"d","body":"thanks @alice, @nobody, @mentionblocker and @mentionwriter"}}`)
+	asserts.Equal(http.StatusCreated, w.Code, "article with mentions should be created")
+	asserts.Contains(w.Body.String(), `"mentions":[{"username":"alice"`, "response should list mentioned profiles")
+	asserts.Len(mentioned, 1, "only existing users not blocked with the author should be mentioned")
+	asserts.Equal(alice.ID, mentioned[0].UserID, "mention event should name the mentioned user")
+	asserts.Equal(writer.ID, mentioned[0].AuthorID, "mention event should name the author")
+
+	created := testutil.ToFloat64(metrics.ArticlesCreated)
+	w = send("PUT", "/api/articles/mention-article", `{"article":{"body":"thanks @bob and @alice"}}`)
+	asserts.Equal(http.StatusOK, w.Code, "article should be updated")
+	asserts.Equal(created, testutil.ToFloat64(metrics.ArticlesCreated), "updates should not count as created articles")
+	asserts.Len(mentioned, 2, "users already mentioned should not be mentioned again")
+	asserts.Equal(bob.ID, mentioned[1].UserID, "newly mentioned user should be mentioned")
+	articleModel, _ := FindOneArticle(context.Background(), &ArticleModel{Slug: "mention-article"})
+	asserts.Len(mentionedUsers(articleModel.ID, 0, nil), 2, "mentions should follow the body")
+
+	send("PUT", "/api/articles/mention-article", `{"article":{"body":"thanks @bob"}}`)
+	asserts.Len(mentionedUsers(articleModel.ID, 0, nil), 1, "users no longer mentioned should be forgotten")
+
+	w = send("POST", "/api/articles/mention-article/comments", `{"comment":{"body":"@alice what do you think?"}}`)
+	asserts.Equal(http.StatusCreated, w.Code, "comment with mentions should be created")
+	asserts.Contains(w.Body.String(), `"mentions":[{"username":"alice"`, "comment response should list mentioned profiles")
+	asserts.Len(mentioned, 3, "mentions in comments should be recorded")
+	asserts.NotZero(mentioned[2].CommentID, "mention in a comment should name the comment")
+	asserts.Len(mentionedUsers(articleModel.ID, 0, nil), 1, "comment mentions should not be mentions of the article")
+	asserts.Len(mentionedUsers(articleModel.ID, mentioned[2].CommentID, []uint{alice.ID}), 0, "hidden users should be left out")
+
+	// Without a mention table the mentions can't be saved, neither can what mentions.
+	test_db.Exec("ALTER TABLE mention_models RENAME TO mention_models_away")
+	w = send("POST", "/api/articles/", `{"article":{"title":"Lost Article","description":"d","body":"hello @bob"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "failing mentions should fail the article")
+	lost, _ := FindOneArticle(context.Background(), &ArticleModel{Slug: "lost-article"})
+	asserts.Zero(lost.ID, "the article should not be saved without its mentions")
+	w = send("POST", "/api/articles/mention-article/comments", `{"comment":{"body":"@bob again"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "failing mentions should fail the comment")
+	test_db.Exec("ALTER TABLE mention_models_away RENAME TO mention_models")
+	var outboxCount int
+	test_db.Model(&events.OutboxModel{}).Where("payload LIKE ?", "%lost-article%").Count(&outboxCount)
+	asserts.Equal(0, outboxCount, "no event should go out for the article")
+	asserts.Len(mentioned, 3, "no mention should go out either")
+	var commentCount int
+	test_db.Model(&CommentModel{}).Where("body = ?", "@bob again").Count(&commentCount)
+	asserts.Equal(0, commentCount, "the comment should not be saved without its mentions")
+}
+
+func TestArticleEvents(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	author := createMockUser("streamauthor", "streamauthor@test.com")
+	reader := createMockUser("streamreader", "streamreader@test.com")
+	muted := createMockUser("streammuted", "streammuted@test.com")
+	test_db.Create(&users.MuteModel{MuterID: reader.ID, MutedID: muted.ID})
+	createMockArticle("Streamed Article", "Description", "Body", GetArticleUserModel(context.Background(), author))
+	SubscribeEvents()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// Requests are made by the reader unless X-User-ID names someone else.
+	r.Use(func(c *gin.Context) {
+		userID := reader.ID
+		if id, err := strconv.Atoi(c.GetHeader("X-User-ID")); err == nil {
+			userID = uint(id)
+		}
+		users.UpdateContextUserModel(c, userID)
+	})
+	ArticlesAnonymousRegister(r.Group("/api/articles"))
+	ArticlesRegister(r.Group("/api/articles"))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// Open the stream, run `action`, then read `count` events (id, event and data lines).
+	stream := func(lastEventID string, count int, action func()) [][3]string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/articles/streamed-article/events", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		asserts.NoError(err, "stream should open")
+		defer resp.Body.Close()
+		asserts.Equal("text/event-stream", resp.Header.Get("Content-Type"), "stream should be sent as events")
+		action()
+		received := [][3]string{}
+		var current [3]string
+		scanner := bufio.NewScanner(resp.Body)
+		for len(received) < count && scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id:"):
+				current[0] = strings.TrimPrefix(line, "id:")
+			case strings.HasPrefix(line, "event:"):
+				current[1] = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				current[2] = strings.TrimPrefix(line, "data:")
+			case line == "" && current[1] != "":
+				received = append(received, current)
+				current = [3]string{}
+			}
+		}
+		return received
+	}
+	sendAs := func(userID uint, method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", fmt.Sprint(userID))
+		r.ServeHTTP(w, req)
+		return w
+	}
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		return sendAs(reader.ID, method, url, body)
+	}
+
+	received := stream("", 3, func() {
+		w := send("POST", "/api/articles/streamed-article/comments", `{"comment":{"body":"live comment"}}`)
+		var created struct {
+			Comment CommentResponse `json:"comment"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &created)
+		send("POST", "/api/articles/streamed-article/favorite", "")
+		send("DELETE", "/api/articles/streamed-article/comments/"+fmt.Sprint(created.Comment.ID), "")
+	})
+	asserts.Len(received, 3, "every change should be streamed")
+	asserts.Equal("comment.created", received[0][1], "new comments should be streamed")
+	asserts.Contains(received[0][2], `"body":"live comment"`, "new comments should be serialized")
+	asserts.Contains(received[0][2], `"following":false`, "streams should be serialized for anonymous users")
+	asserts.Equal("favorites.changed", received[1][1], "favorites should be streamed")
+	asserts.Equal(`{"favoritesCount":1}`, received[1][2], "favorites count should be sent")
+	asserts.Equal("comment.deleted", received[2][1], "deleted comments should be streamed")
+
+	resumed := stream(received[0][0], 2, func() {})
+	asserts.Len(resumed, 2, "reconnection should get the missed events")
+	asserts.Equal(received[1][0], resumed[0][0], "missed events should be replayed from Last-Event-ID")
+
+	received = stream("", 1, func() {
+		sendAs(muted.ID, "POST", "/api/articles/streamed-article/comments", `{"comment":{"body":"muted comment"}}`)
+		send("POST", "/api/articles/streamed-article/comments", `{"comment":{"body":"visible comment"}}`)
+	})
+	asserts.Len(received, 1)
+	asserts.Contains(received[0][2], `"body":"visible comment"`, "comments of muted users should not be streamed")
+	resumed = stream(resumed[1][0], 1, func() {})
+	asserts.Contains(resumed[0][2], `"body":"visible comment"`, "comments of muted users should not be replayed")
+
+	w := send("GET", "/api/articles/nothing-here/events", "")
+	asserts.Equal(http.StatusNotFound, w.Code, "unknown articles have no stream")
+}
+
+func TestUserEvents(t *testing.T) {
+	setupTestDB()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	writer := createMockUser("feedwriter", "feedwriter@test.com")
+	follower := createMockUser("feedfollower", "feedfollower@test.com")
+	tagFollower := createMockUser("feedtagfollower", "feedtagfollower@test.com")
+	muter := createMockUser("feedmuter", "feedmuter@test.com")
+	test_db.Create(&users.FollowModel{FollowingID: writer.ID, FollowedByID: follower.ID})
+	test_db.Create(&users.FollowModel{FollowingID: writer.ID, FollowedByID: muter.ID})
+	test_db.Create(&users.MuteModel{MuterID: muter.ID, MutedID: writer.ID})
+
+	article := createMockArticle("Feed Article", "Description", "Body", GetArticleUserModel(context.Background(), writer))
+	asserts.NoError(article.setTags(context.Background(), []string{"golang"}))
+	test_db.Save(&article)
+	var tag TagModel
+	test_db.Where(TagModel{Tag: "golang"}).First(&tag)
+	test_db.Create(&TagFollowModel{TagID: tag.ID, FollowedByID: GetArticleUserModel(context.Background(), tagFollower).ID})
+	test_db.Create(&TagFollowModel{TagID: tag.ID, FollowedByID: GetArticleUserModel(context.Background(), follower).ID})
+
+	subscribe := func(user users.UserModel) *realtime.Subscription {
+		subscription, _ := realtime.Subscribe(realtime.UserTopic(user.ID), "")
+		return subscription
+	}
+	followerStream := subscribe(follower)
+	defer followerStream.Close()
+	tagFollowerStream := subscribe(tagFollower)
+	defer tagFollowerStream.Close()
+	muterStream := subscribe(muter)
+	defer muterStream.Close()
+	writerStream := subscribe(writer)
+	defer writerStream.Close()
+
+	asserts.NoError(publishFeedUpdate(article.ID))
+	asserts.Len(followerStream.C, 1, "followers of the author and of the tag should get the article once")
+	asserts.Len(tagFollowerStream.C, 1, "followers of the tag should get the article")
+	asserts.Len(muterStream.C, 0, "users who muted the author should not get the article")
+	asserts.Len(writerStream.C, 0, "the author should not get their own article")
+	message := <-followerStream.C
+	asserts.Equal("feed.update", message.Event)
+	asserts.Contains(string(message.Data), `"slug":"feed-article"`, "the update should describe the article")
+	asserts.Contains(string(message.Data), `"tagList":["golang"]`, "the update should list the tags")
+
+	asserts.NoError(publishMention(events.UserMentioned{ArticleID: article.ID, AuthorID: writer.ID, UserID: muter.ID}))
+	asserts.Len(muterStream.C, 0, "users who muted the author should not hear of their mentions")
+	asserts.NoError(publishMention(events.UserMentioned{ArticleID: article.ID, CommentID: 3, AuthorID: writer.ID, UserID: follower.ID}))
+	message = <-followerStream.C
+	asserts.Equal("mention", message.Event)
+	asserts.Contains(string(message.Data), `"commentId":3`, "the mention should name the comment")
+	asserts.Contains(string(message.Data), `"username":"feedwriter"`, "the mention should name the author")
+}
@@ -11,7 +11,8 @@ type ArticleModelValidator struct {
 	Article struct {
 		Title       string   `form:"title" json:"title" binding:"required,min=4"`
 		Description string   `form:"description" json:"description" binding:"max=2048"`
-		Body        string   `form:"body" json:"body" binding:"max=2048"`
+		Body        string   `form:"body" json:"body" binding:"max=65535"`
+		CoverImage  string   `form:"coverImage" json:"coverImage" binding:"omitempty,mediaurl,max=1024"`
 		Tags        []string `form:"tagList" json:"tagList"`
 	} `json:"article"`
 	articleModel ArticleModel `json:"-"`
@@ -26,6 +27,7 @@ func NewArticleModelValidatorFillWith(articleModel ArticleModel) ArticleModelVal
 	articleModelValidator.Article.Title = articleModel.Title
 	articleModelValidator.Article.Description = articleModel.Description
 	articleModelValidator.Article.Body = articleModel.Body
+	articleModelValidator.Article.CoverImage = articleModel.CoverImage
 	for _, tagModel := range articleModel.Tags {
 		articleModelValidator.Article.Tags = append(articleModelValidator.Article.Tags, tagModel.Tag)
 	}
@@ -43,14 +45,15 @@ func (s *ArticleModelValidator) Bind(c *gin.Context) error {
 	s.articleModel.Title = s.Article.Title
 	s.articleModel.Description = s.Article.Description
 	s.articleModel.Body = s.Article.Body
-	s.articleModel.Author = GetArticleUserModel(myUserModel)
-	s.articleModel.setTags(s.Article.Tags)
+	s.articleModel.CoverImage = s.Article.CoverImage
+	s.articleModel.Author = GetArticleUserModel(common.RequestContext(c), myUserModel)
+	s.articleModel.setTags(common.RequestContext(c), s.Article.Tags)
 	return nil
 }
 
 type CommentModelValidator struct {
 	Comment struct {
-		Body string `form:"body" json:"body" binding:"max=2048"`
+		Body string `form:"body" json:"body" binding:"max=65535"`
 	} `json:"comment"`
 	commentModel CommentModel `json:"-"`
 }
@@ -67,6 +70,40 @@ func (s *CommentModelValidator) Bind(c *gin.Context) error {
 		return err
 	}
 	s.commentModel.Body = s.Comment.Body
-	s.commentModel.Author = GetArticleUserModel(myUserModel)
+	s.commentModel.Author = GetArticleUserModel(common.RequestContext(c), myUserModel)
 	return nil
 }
+
+type TagModelValidator struct {
+	Tag struct {
+		Tag         string `form:"tag" json:"tag" binding:"required,max=64"`
+		Description string `form:"description" json:"description" binding:"max=1024"`
+	} `json:"tag"`
+	tagModel TagModel `json:"-"`
+}
+
+func NewTagModelValidatorFillWith(tagModel TagModel) TagModelValidator {
+	tagModelValidator := TagModelValidator{}
+	tagModelValidator.Tag.Tag = tagModel.Tag
+	tagModelValidator.Tag.Description = tagModel.Description
+	return tagModelValidator
+}
+
+func (s *TagModelValidator) Bind(c *gin.Context) error {
+	err := common.Bind(c, s)
+	if err != nil {
+		return err
+	}
+	s.tagModel.Tag = normalizeTag(s.Tag.Tag)
+	s.tagModel.Description = s.Tag.Description
+	return nil
+}
+
+// Body of `POST /api/tags/:tag/merge`, the tag in the url is merged into `into`.
+type TagMergeValidator struct {
+	Into string `form:"into" json:"into" binding:"required,max=64"`
+}
+
+func (s *TagMergeValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, s)
+}
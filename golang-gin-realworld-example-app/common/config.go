@@ -0,0 +1,60 @@
+package common
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings are read from the environment so the same binary runs locally, in tests and in containers.
+//
+//	dir := common.Getenv("UPLOAD_DIR", "./../uploads")
+func Getenv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Same as Getenv for integer settings, an unparsable value falls back to the default.
+func GetenvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Same as Getenv for boolean settings such as "true", "1" or "false".
+func GetenvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Same as Getenv for durations written like "30s" or "5m".
+func GetenvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Absolute url of a page of the frontend (SITE_URL), for links leaving the api such as feeds and emails.
+//
+//	link := common.SiteURL("/article/" + articleModel.Slug)
+func SiteURL(path string) string {
+	return strings.TrimRight(Getenv("SITE_URL", "http://localhost:4100"), "/") + path
+}
+
+// Absolute url of an endpoint of the api (API_URL), for links that must reach the api itself such
+// as the unsubscribe links of emails.
+//
+//	link := common.APIURL("/api/digest/unsubscribe?token=" + token)
+func APIURL(path string) string {
+	return strings.TrimRight(Getenv("API_URL", "http://localhost:8080"), "/") + path
+}
@@ -1,9 +1,11 @@
 package common
 
 import (
-	"fmt"
+	"context"
+	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"log/slog"
 	"os"
 )
 
@@ -17,10 +19,10 @@ var DB *gorm.DB
 func Init() *gorm.DB {
 	db, err := gorm.Open("sqlite3", "./../gorm.db")
 	if err != nil {
-		fmt.Println("db err: (Init) ", err)
+		slog.Error("db err: (Init)", "err", err)
 	}
 	db.DB().SetMaxIdleConns(10)
-	//db.LogMode(true)
+	configureLogging(db)
 	DB = db
 	return DB
 }
@@ -29,10 +31,11 @@ func Init() *gorm.DB {
 func TestDBInit() *gorm.DB {
 	test_db, err := gorm.Open("sqlite3", "./../gorm_test.db")
 	if err != nil {
-		fmt.Println("db err: (TestDBInit) ", err)
+		slog.Error("db err: (TestDBInit)", "err", err)
 	}
 	test_db.DB().SetMaxIdleConns(3)
-	test_db.LogMode(true)
+	// Statements are logged with LOG_LEVEL=debug.
+	configureLogging(test_db)
 	DB = test_db
 	return DB
 }
@@ -48,3 +51,25 @@ func TestDBFree(test_db *gorm.DB) error {
 func GetDB() *gorm.DB {
 	return DB
 }
+
+// Key of the context given to GetDBContext in the settings of the *gorm.DB.
+const DBContextKey = "common:context"
+
+// Same as GetDB, with the context of the request the queries are run for. Tracing reads it back
+// to record the queries as part of the request, gorm v1 doesn't take contexts itself.
+//
+//	db := common.GetDBContext(c.Request.Context())
+func GetDBContext(ctx context.Context) *gorm.DB {
+	return DB.Set(DBContextKey, ctx)
+}
+
+// Context of the request `c` serves, for serializers to pass on to the models. Serializers built
+// outside of a request, by event handlers or tests, get a background context.
+//
+//	favorited := s.isFavoriteBy(common.RequestContext(s.C), articleUserModel)
+func RequestContext(c *gin.Context) context.Context {
+	if c == nil || c.Request == nil {
+		return context.Background()
+	}
+	return c.Request.Context()
+}
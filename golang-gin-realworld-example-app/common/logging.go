@@ -0,0 +1,207 @@
+package common
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Attributes whose value is replaced by "[REDACTED]", wherever they are in a record: any key
+// containing one of these words, such as "password", "access_token" or "Authorization".
+var redactedKeys = []string{"password", "token", "secret", "authorization", "cookie"}
+
+const redacted = "[REDACTED]"
+
+func isRedacted(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range redactedKeys {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Level from LOG_LEVEL: debug, info (the default), warn or error.
+func LogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(Getenv("LOG_LEVEL", "info"))); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// A logger writing to `w` at LOG_LEVEL, as JSON unless LOG_FORMAT is "text". Secrets are redacted.
+func NewLogger(w io.Writer) *slog.Logger {
+	options := &slog.HandlerOptions{
+		Level: LogLevel(),
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if isRedacted(attr.Key) {
+				return slog.String(attr.Key, redacted)
+			}
+			return attr
+		},
+	}
+	if Getenv("LOG_FORMAT", "json") == "text" {
+		return slog.New(slog.NewTextHandler(w, options))
+	}
+	return slog.New(slog.NewJSONHandler(w, options))
+}
+
+// Log to stdout with NewLogger, call once at startup. The log package and slog.Info and friends
+// go through it as well.
+func InitLogger() {
+	slog.SetDefault(NewLogger(os.Stdout))
+}
+
+type loggerKey struct{}
+
+// The logger of the request of `ctx`, carrying its request_id, or the default logger outside of
+// requests. Handlers can pass their *gin.Context.
+//
+//	common.Logger(c).Info("article created", "slug", articleModel.Slug)
+func Logger(ctx context.Context) *slog.Logger {
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Attach `logger` to ctx, for Logger to find it.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// AccessLog middleware logging every request once served, except for `skipPaths` such as probes.
+// Only the path is logged, query strings may hold tokens. Server errors are logged as errors and
+// client errors as warnings.
+func AccessLog(skipPaths ...string) gin.HandlerFunc {
+	skipped := map[string]bool{}
+	for _, path := range skipPaths {
+		skipped[path] = true
+	}
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		if skipped[c.Request.URL.Path] {
+			return
+		}
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		Logger(c).LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery middleware answering 500 to a panicking handler, the panic is logged with its stack
+// trace by the logger of the request.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		Logger(c).Error("panic", "err", err, "stack", string(debug.Stack()))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// gorm v1 logger sending its messages to slog: statements at debug level, without the values bound
+// to them, and errors.
+type gormLogger struct{}
+
+func (gormLogger) Print(values ...interface{}) {
+	if len(values) == 0 {
+		return
+	}
+	switch values[0] {
+	case "sql":
+		if len(values) >= 6 {
+			slog.Debug("query", "source", values[1], "duration", values[2], "sql", values[3], "rows", values[5])
+		}
+	case "info":
+		slog.Debug("db", "values", values[1:])
+	default:
+		if len(values) >= 3 {
+			slog.Error("db err: (gorm)", "source", values[1], "err", values[2])
+		} else {
+			slog.Error("db err: (gorm)", "values", values[1:])
+		}
+	}
+}
+
+const slowQueryKey = "common:slow_query_start"
+
+// Send the logs of `db` to slog, statements only at debug level, and log the queries slower than
+// DB_SLOW_QUERY_THRESHOLD as warnings. A threshold of 0 turns slow query logging off.
+func configureLogging(db *gorm.DB) {
+	db.SetLogger(gormLogger{})
+	// LogMode(false) would silence errors too, gorm only logs them in its default mode.
+	if LogLevel() <= slog.LevelDebug {
+		db.LogMode(true)
+	}
+
+	threshold := GetenvDuration("DB_SLOW_QUERY_THRESHOLD", 200*time.Millisecond)
+	if threshold <= 0 {
+		return
+	}
+	start := func(scope *gorm.Scope) {
+		scope.Set(slowQueryKey, time.Now())
+	}
+	end := func(operation string) func(scope *gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			value, ok := scope.Get(slowQueryKey)
+			if !ok {
+				return
+			}
+			duration := time.Since(value.(time.Time))
+			if duration < threshold {
+				return
+			}
+			ctx := context.Background()
+			if value, ok := scope.Get(DBContextKey); ok {
+				ctx, _ = value.(context.Context)
+			}
+			table := ""
+			if scope.Value != nil {
+				table = scope.TableName()
+			}
+			Logger(ctx).Warn("slow query", "operation", operation, "table", table, "duration_ms", float64(duration.Microseconds())/1000, "sql", scope.SQL)
+		}
+	}
+	callback := db.Callback()
+	callback.Create().Before("gorm:create").Register("common:before_create", start)
+	callback.Create().After("gorm:create").Register("common:after_create", end("create"))
+	callback.Query().Before("gorm:query").Register("common:before_query", start)
+	callback.Query().After("gorm:query").Register("common:after_query", end("query"))
+	callback.Update().Before("gorm:update").Register("common:before_update", start)
+	callback.Update().After("gorm:update").Register("common:after_update", end("update"))
+	callback.Delete().Before("gorm:delete").Register("common:before_delete", start)
+	callback.Delete().After("gorm:delete").Register("common:after_delete", end("delete"))
+	callback.RowQuery().Before("gorm:row_query").Register("common:before_row_query", start)
+	callback.RowQuery().After("gorm:row_query").Register("common:after_row_query", end("row_query"))
+}
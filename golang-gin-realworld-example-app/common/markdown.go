@@ -0,0 +1,93 @@
+package common
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// Only the tags Markdown can produce are allowed, links get rel="nofollow noopener" and
+// every URL has to be http(s), mailto or relative.
+var htmlPolicy = newHTMLPolicy()
+
+func newHTMLPolicy() *bluemonday.Policy {
+	policy := bluemonday.NewPolicy()
+	policy.AllowElements("p", "br", "hr", "blockquote", "pre", "code",
+		"h1", "h2", "h3", "h4", "h5", "h6",
+		"ul", "ol", "li", "strong", "em", "del",
+		"table", "thead", "tbody", "tr", "th", "td")
+	policy.AllowAttrs("align").OnElements("th", "td")
+	policy.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	policy.AllowAttrs("class").Matching(bluemonday.SpaceSeparatedTokens).OnElements("code")
+	policy.AllowAttrs("href", "title").OnElements("a")
+	policy.AllowAttrs("src", "alt", "title").OnElements("img")
+	policy.AllowURLSchemes("http", "https", "mailto")
+	policy.AllowRelativeURLs(true)
+	policy.RequireNoFollowOnLinks(true)
+	policy.AddTargetBlankToFullyQualifiedLinks(true)
+	return policy
+}
+
+var textPolicy = bluemonday.StrictPolicy()
+
+// Render Markdown to HTML that is safe to inject into a page, raw HTML in the source is dropped.
+//
+//	html := common.RenderMarkdown(articleModel.Body)
+func RenderMarkdown(source string) string {
+	if source == "" {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return "<p>" + html.EscapeString(source) + "</p>"
+	}
+	return htmlPolicy.Sanitize(buf.String())
+}
+
+// Strip Markdown down to its visible text with whitespace collapsed, used for word counts and excerpts.
+//
+//	text := common.MarkdownToText("# Title\n\nSome **bold** text") // "Title Some bold text"
+func MarkdownToText(source string) string {
+	if source == "" {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return strings.Join(strings.Fields(source), " ")
+	}
+	// Block elements are rendered one per line, so words of adjacent blocks stay apart.
+	text := html.UnescapeString(textPolicy.Sanitize(buf.String()))
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Usernames are alphanumeric, a mention can't follow a word character so emails aren't mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9]+)`)
+
+// Most mentions kept per body, the rest are ignored.
+const maxMentions = 50
+
+// Distinct usernames mentioned with `@username`, in order of appearance.
+//
+//	names := common.ParseMentions("thanks @alice and @bob, cc @alice") // ["alice", "bob"]
+func ParseMentions(source string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(source, -1) {
+		if seen[match[1]] {
+			continue
+		}
+		seen[match[1]] = true
+		names = append(names, match[1])
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Client for urls given by users, such as remote images and webhook endpoints. It refuses to
+// connect to loopback, private and link-local addresses, redirects included, so those urls can't
+// be used to probe internal services.
+//
+//	client := common.NewPublicClient(10 * time.Second)
+func NewPublicClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: DenyPrivateAddresses}).DialContext,
+		},
+		CheckRedirect: denyPrivateRedirects,
+	}
+}
+
+// Control of a net.Dialer refusing every address which isn't public. It runs once the name is
+// resolved, so a public name pointing to a private address is refused too.
+func DenyPrivateAddresses(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublic(net.ParseIP(host)) {
+		return fmt.Errorf("refusing to connect to %v", host)
+	}
+	return nil
+}
+
+// The dialer catches redirects to private names, redirects to private ips are refused before
+// anything is sent.
+func denyPrivateRedirects(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if ip := net.ParseIP(req.URL.Hostname()); ip != nil && !isPublic(ip) {
+		return fmt.Errorf("refusing to follow a redirect to %v", ip)
+	}
+	return nil
+}
+
+func isPublic(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsMulticast()
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The api server with timeouts, so slow or idle clients can't hold connections forever. Event
+// streams and WebSockets lift the write deadline of their own connection.
+//
+//	server := common.NewServer(r)
+//	go server.ListenAndServe()
+func NewServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + Getenv("PORT", "8080"),
+		Handler:           handler,
+		ReadHeaderTimeout: GetenvDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       GetenvDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      GetenvDuration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       GetenvDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		MaxHeaderBytes:    GetenvInt("HTTP_MAX_HEADER_BYTES", 1<<20),
+	}
+}
+
+// Largest request body accepted by LimitBody, uploads check their own UPLOAD_MAX_BYTES.
+func MaxBodyBytes() int64 {
+	return int64(GetenvInt("HTTP_MAX_BODY_BYTES", 1<<20))
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// LimitBody middleware refuses bodies over `maxBytes` with 413, right away when Content-Length
+// says so, otherwise reading them fails once the limit is reached.
+func LimitBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, NewError("body", errBodyTooLarge))
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
@@ -2,16 +2,24 @@ package common
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -323,3 +331,323 @@ func TestBindFunction(t *testing.T) {
 
 	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "invalid email should return 422")
 }
+
+func TestRenderMarkdown(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Equal("", RenderMarkdown(""), "empty body should render empty")
+
+	html := RenderMarkdown("# Title\n\nSome **bold** text")
+	asserts.Contains(html, "<h1>Title</h1>", "headings should be rendered")
+	asserts.Contains(html, "<strong>bold</strong>", "emphasis should be rendered")
+
+	html = RenderMarkdown("hello <script>alert(1)</script>")
+	asserts.NotContains(html, "<script>", "raw html should be dropped")
+
+	html = RenderMarkdown("[x](javascript:alert(1)) [y](https://golang.org)")
+	asserts.NotContains(html, "javascript:", "unsafe url schemes should be removed")
+	asserts.Contains(html, `href="https://golang.org"`, "safe links should be kept")
+	asserts.Contains(html, `rel="nofollow noopener"`, "links should be nofollow")
+}
+
+func TestParseMentions(t *testing.T) {
+	asserts := assert.New(t)
+
+	var mentionTests = []struct {
+		source   string
+		expected []string
+	}{
+		{"thanks @alice and @bob, cc @alice", []string{"alice", "bob"}},
+		{"@alice: hi", []string{"alice"}},
+		{"(@alice)", []string{"alice"}},
+		{"mail bob@example.com", []string{}},
+		{"@@alice @", []string{}},
+		{"", []string{}},
+	}
+	for _, testData := range mentionTests {
+		asserts.Equal(testData.expected, ParseMentions(testData.source), "mentions of "+testData.source)
+	}
+}
+
+func TestMediaURLValidation(t *testing.T) {
+	asserts := assert.New(t)
+
+	type Media struct {
+		Image string `json:"image" binding:"omitempty,mediaurl"`
+	}
+	var requestTests = []struct {
+		image string
+		valid bool
+	}{
+		{"", true},
+		{"https://golang.org/doc/gopher/frontpage.png", true},
+		{"http://image/1.jpg", true},
+		{"/uploads/2025/01/aB3dE5gH7jK9mN1p.png", true},
+		{"//evil.example/x.png", false},
+		{"javascript:alert(1)", false},
+		{"ftp://files.example/x.png", false},
+		{"not a url", false},
+	}
+
+	r := gin.New()
+	r.POST("/media", func(c *gin.Context) {
+		var media Media
+		if err := Bind(c, &media); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, NewValidatorError(err))
+			return
+		}
+		c.JSON(http.StatusOK, media)
+	})
+
+	for _, testData := range requestTests {
+		bodyData := fmt.Sprintf(`{"image": %q}`, testData.image)
+		req, _ := http.NewRequest("POST", "/media", bytes.NewBufferString(bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(testData.valid, w.Code == http.StatusOK, "mediaurl validation of "+testData.image)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(LimitBody(16))
+	r.POST("/test", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusRequestEntityTooLarge, NewError("body", err))
+			return
+		}
+		c.String(http.StatusOK, string(body))
+	})
+
+	req, _ := http.NewRequest("POST", "/test", bytes.NewBufferString("small body"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code, "bodies under the limit should be accepted")
+	asserts.Equal("small body", w.Body.String())
+
+	req, _ = http.NewRequest("POST", "/test", bytes.NewBufferString("a body over the limit"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusRequestEntityTooLarge, w.Code, "a Content-Length over the limit should return 413")
+	asserts.Equal(`{"errors":{"body":"request body too large"}}`, w.Body.String())
+
+	req, _ = http.NewRequest("POST", "/test", io.MultiReader(bytes.NewBufferString("a body over the limit")))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusRequestEntityTooLarge, w.Code, "reading past the limit should fail without Content-Length")
+}
+
+func TestNewServer(t *testing.T) {
+	asserts := assert.New(t)
+
+	t.Setenv("PORT", "9090")
+	t.Setenv("HTTP_WRITE_TIMEOUT", "1m")
+	server := NewServer(http.NotFoundHandler())
+	asserts.Equal(":9090", server.Addr)
+	asserts.Equal(time.Minute, server.WriteTimeout, "timeouts should be configurable")
+	asserts.Equal(5*time.Second, server.ReadHeaderTimeout, "every timeout should have a default")
+	asserts.NotZero(server.ReadTimeout)
+	asserts.NotZero(server.IdleTimeout)
+	asserts.Equal(1<<20, server.MaxHeaderBytes)
+}
+
+// Send the default logger to a buffer for the length of the test.
+// Logs may be written from other goroutines while the test reads them.
+type logBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func (b *logBuffer) String() string {
+	return string(b.Bytes())
+}
+
+func (b *logBuffer) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.buf.Reset()
+}
+
+func captureLogs(t *testing.T) *logBuffer {
+	logs := &logBuffer{}
+	previous := slog.Default()
+	slog.SetDefault(NewLogger(logs))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return logs
+}
+
+func TestNewLogger(t *testing.T) {
+	asserts := assert.New(t)
+
+	var buf bytes.Buffer
+	logger := NewLogger(&buf)
+	logger.Info("login", "email", "jake@jake.jake", "password", "jakejake", slog.Group("headers", "Authorization", "Token abc"), "access_token", "xyz")
+	var record map[string]interface{}
+	asserts.NoError(json.Unmarshal(buf.Bytes(), &record), "logs should be json by default")
+	asserts.Equal("login", record["msg"])
+	asserts.Equal("jake@jake.jake", record["email"])
+	asserts.Equal("[REDACTED]", record["password"], "passwords should be redacted")
+	asserts.Equal("[REDACTED]", record["access_token"], "tokens should be redacted")
+	asserts.Equal(map[string]interface{}{"Authorization": "[REDACTED]"}, record["headers"], "secrets in groups should be redacted")
+
+	buf.Reset()
+	logger.Debug("hidden")
+	asserts.Empty(buf.String(), "debug should be off by default")
+
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LOG_FORMAT", "text")
+	buf.Reset()
+	logger = NewLogger(&buf)
+	logger.Info("hidden")
+	logger.Warn("shown", "token", "abc")
+	asserts.Equal(`level=WARN msg=shown token=[REDACTED]`, strings.TrimSpace(regexp.MustCompile(`^time=\S+ `).ReplaceAllString(buf.String(), "")))
+}
+
+func TestRequestID(t *testing.T) {
+	asserts := assert.New(t)
+	logs := captureLogs(t)
+
+	r := gin.New()
+	r.Use(RequestID(), AccessLog("/healthz"))
+	r.GET("/api/articles/:slug", func(c *gin.Context) {
+		Logger(c).Info("handler", "request_id_key", c.GetString("request_id"))
+		c.Status(http.StatusNotFound)
+	})
+	r.GET("/healthz", func(c *gin.Context) {})
+
+	req, _ := http.NewRequest("GET", "/api/articles/hello?access_token=secret", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	id := w.Header().Get("X-Request-ID")
+	asserts.Regexp(`^[0-9a-f]{32}$`, id, "a request id should be generated")
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	asserts.Len(lines, 2)
+	var handler, access map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &handler)
+	json.Unmarshal([]byte(lines[1]), &access)
+	asserts.Equal(id, handler["request_id"], "the logger of the request should carry its id")
+	asserts.Equal(id, handler["request_id_key"], "the id should be stored in the gin context")
+	asserts.Equal("WARN", access["level"], "client errors should be warnings")
+	asserts.Equal("/api/articles/:slug", access["route"])
+	asserts.Equal("/api/articles/hello", access["path"])
+	asserts.Equal(float64(404), access["status"])
+	asserts.Equal(id, access["request_id"])
+	asserts.NotContains(logs.String(), "secret", "query strings should not be logged")
+
+	logs.Reset()
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("X-Request-ID", "lb-1234")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal("lb-1234", w.Header().Get("X-Request-ID"), "ids of callers should be kept")
+	asserts.Empty(logs.String(), "skipped paths should not be logged")
+
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("X-Request-ID", "bad id\n")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Regexp(`^[0-9a-f]{32}$`, w.Header().Get("X-Request-ID"), "invalid ids should be replaced")
+}
+
+func TestRecovery(t *testing.T) {
+	asserts := assert.New(t)
+	logs := captureLogs(t)
+
+	r := gin.New()
+	r.Use(RequestID(), Recovery())
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusInternalServerError, w.Code)
+	var record map[string]interface{}
+	json.Unmarshal(logs.Bytes(), &record)
+	asserts.Equal("panic", record["msg"])
+	asserts.Equal("boom", record["err"])
+	asserts.Contains(record["stack"], "TestRecovery", "the stack trace should be logged")
+	asserts.Equal(w.Header().Get("X-Request-ID"), record["request_id"])
+}
+
+func TestSlowQueries(t *testing.T) {
+	asserts := assert.New(t)
+	logs := captureLogs(t)
+
+	t.Setenv("DB_SLOW_QUERY_THRESHOLD", "1ns")
+	db, err := gorm.Open("sqlite3", ":memory:")
+	asserts.NoError(err)
+	defer db.Close()
+	configureLogging(db)
+	db.Exec("CREATE TABLE notes (id integer primary key, body text)")
+
+	ctx := WithLogger(context.Background(), slog.Default().With("request_id", "req-1"))
+	var count int
+	db.Set(DBContextKey, ctx).Table("notes").Where("body = ?", "private").Count(&count)
+	var record map[string]interface{}
+	asserts.NoError(json.Unmarshal(logs.Bytes(), &record))
+	asserts.Equal("slow query", record["msg"], "queries over the threshold should be logged")
+	asserts.Equal("WARN", record["level"])
+	asserts.Equal("req-1", record["request_id"], "slow queries should be logged with the request")
+	asserts.Contains(record["sql"], "notes")
+	asserts.NotContains(logs.String(), "private", "bound values should be left out")
+
+	t.Setenv("DB_SLOW_QUERY_THRESHOLD", "1h")
+	logs.Reset()
+	quick, _ := gorm.Open("sqlite3", ":memory:")
+	defer quick.Close()
+	configureLogging(quick)
+	quick.Exec("CREATE TABLE notes (id integer primary key)")
+	quick.Table("notes").Count(&count)
+	asserts.Empty(logs.String(), "queries under the threshold should not be logged")
+}
+
+func TestDatabaseErrors(t *testing.T) {
+	asserts := assert.New(t)
+	logs := captureLogs(t)
+
+	t.Setenv("LOG_LEVEL", "info")
+	db, err := gorm.Open("sqlite3", ":memory:")
+	asserts.NoError(err)
+	defer db.Close()
+	configureLogging(db)
+	var count int
+	asserts.Error(db.Table("missing").Count(&count).Error)
+	// gorm prints errors from a goroutine of its own.
+	asserts.Eventually(func() bool {
+		return strings.Contains(logs.String(), `"msg":"db err: (gorm)"`)
+	}, time.Second, 10*time.Millisecond, "errors should be logged at info level")
+	asserts.Contains(logs.String(), "no such table: missing")
+	asserts.NotContains(logs.String(), `"msg":"query"`, "statements should only be logged at debug level")
+}
+
+func TestNewPublicClient(t *testing.T) {
+	asserts := assert.New(t)
+
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer internal.Close()
+	_, err := NewPublicClient(time.Second).Get(internal.URL)
+	asserts.ErrorContains(err, "refusing to connect to 127.0.0.1", "loopback addresses should be refused")
+
+	for _, target := range []string{"http://169.254.169.254/latest/meta-data", "http://10.0.0.1/", "http://[::1]/"} {
+		req := httptest.NewRequest("GET", target, nil)
+		asserts.Error(denyPrivateRedirects(req, nil), "redirects to %v should be refused", target)
+	}
+	asserts.NoError(denyPrivateRedirects(httptest.NewRequest("GET", "http://93.184.215.14/", nil), nil))
+	asserts.NoError(denyPrivateRedirects(httptest.NewRequest("GET", "http://example.com/", nil), nil), "names are checked when dialing")
+}
@@ -82,3 +82,18 @@ func Bind(c *gin.Context, obj interface{}) error {
 	b := binding.Default(c.Request.Method, c.ContentType())
 	return c.ShouldBindWith(obj, b)
 }
+
+// Scheme and host the request was sent to, used to make relative urls such as local uploads absolute.
+// Empty for contexts built outside of a request.
+//
+//	image := common.RequestBaseURL(c) + "/uploads/avatar.png"
+func RequestBaseURL(c *gin.Context) string {
+	if c.Request == nil {
+		return ""
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
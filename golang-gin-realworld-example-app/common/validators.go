@@ -0,0 +1,28 @@
+package common
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Custom binding tags shared by the modules, registered on gin's validator engine.
+//
+//	Image string `json:"image" binding:"omitempty,mediaurl"`
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterValidation("mediaurl", isMediaURL)
+	}
+}
+
+// A media url is either absolute http(s) or a path on this server such as "/uploads/2025/01/x.png".
+func isMediaURL(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+	if strings.HasPrefix(value, "/") {
+		return !strings.HasPrefix(value, "//") && !strings.ContainsAny(value, " \t\r\n")
+	}
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,140 @@
+package digest
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/jobs"
+	"realworld-backend/mail"
+	"realworld-backend/users"
+)
+
+// Frequencies of the digest, stored on the user. An empty frequency, as for users created before
+// digests existed, is off.
+const (
+	FrequencyOff    = "off"
+	FrequencyDaily  = "daily"
+	FrequencyWeekly = "weekly"
+)
+
+func period(frequency string) time.Duration {
+	switch frequency {
+	case FrequencyDaily:
+		return 24 * time.Hour
+	case FrequencyWeekly:
+		return 7 * 24 * time.Hour
+	}
+	return 0
+}
+
+// Digests are due a little before their full period, so one sent late by the schedule doesn't push
+// every following one later.
+const slack = time.Hour
+
+func due(user users.UserModel, now time.Time) bool {
+	p := period(user.DigestFrequency)
+	if p == 0 {
+		return false
+	}
+	return user.DigestSentAt == nil || now.Sub(*user.DigestSentAt) >= p-slack
+}
+
+// Looks for the users whose digest is due and queues a sendDigest for each, then queues itself for
+// the next DIGEST_SCHEDULE_INTERVAL.
+type scheduleDigests struct{}
+
+func (scheduleDigests) JobType() string { return "digest.schedule" }
+
+type sendDigest struct {
+	UserID uint `json:"userId"`
+}
+
+func (sendDigest) JobType() string { return "digest.send" }
+
+// Register the handlers of the digest jobs, call once at startup before the job workers start.
+func RegisterJobs() {
+	jobs.Register("default", runSchedule)
+	jobs.Register("mail", runSend)
+}
+
+// Queue the scheduling job for the current interval unless it is queued already, call once at
+// startup. Every instance can call it, the unique key keeps a single schedule.
+func Schedule() error {
+	return scheduleAt(time.Now())
+}
+
+func scheduleAt(at time.Time) error {
+	interval := common.GetenvDuration("DIGEST_SCHEDULE_INTERVAL", time.Hour)
+	slot := at.Truncate(interval)
+	_, err := jobs.Enqueue(scheduleDigests{}, jobs.Options{
+		RunAt:     at,
+		UniqueKey: "digest.schedule/" + strconv.FormatInt(slot.Unix(), 10),
+	})
+	return err
+}
+
+func runSchedule(ctx context.Context, job scheduleDigests) error {
+	now := time.Now()
+	for _, userID := range dueUserIDs(now) {
+		if _, err := jobs.Enqueue(sendDigest{UserID: userID}, jobs.Options{UniqueKey: "digest.send/" + strconv.FormatUint(uint64(userID), 10)}); err != nil {
+			return err
+		}
+	}
+	interval := common.GetenvDuration("DIGEST_SCHEDULE_INTERVAL", time.Hour)
+	return scheduleAt(now.Truncate(interval).Add(interval))
+}
+
+func dueUserIDs(now time.Time) []uint {
+	db := common.GetDB()
+	var ids []uint
+	db.Model(&users.UserModel{}).
+		Where("(digest_frequency = ? AND (digest_sent_at IS NULL OR digest_sent_at <= ?)) OR (digest_frequency = ? AND (digest_sent_at IS NULL OR digest_sent_at <= ?))",
+			FrequencyDaily, now.Add(slack-period(FrequencyDaily)), FrequencyWeekly, now.Add(slack-period(FrequencyWeekly))).
+		Pluck("id", &ids)
+	return ids
+}
+
+// Build the digest of the articles published since the last one and mail it. Nothing is sent when
+// there is nothing new, the period still counts as covered.
+func runSend(ctx context.Context, job sendDigest) error {
+	db := common.GetDB()
+	var user users.UserModel
+	db.First(&user, job.UserID)
+	now := time.Now()
+	if user.ID == 0 || !due(user, now) {
+		return nil
+	}
+	since := now.Add(-period(user.DigestFrequency))
+	if user.DigestSentAt != nil {
+		since = *user.DigestSentAt
+	}
+	articleUserModel := articles.GetArticleUserModel(ctx, user)
+	articleModels, err := articleUserModel.GetFollowedArticlesSince(ctx, since, common.GetenvInt("DIGEST_MAX_ARTICLES", 20))
+	if err != nil {
+		return err
+	}
+	if len(articleModels) > 0 {
+		message, err := render(user, articleModels)
+		if err != nil {
+			return err
+		}
+		if err := mail.Send(ctx, message); err != nil {
+			return err
+		}
+	}
+	return db.Model(&user).UpdateColumn("digest_sent_at", now).Error
+}
+
+// Change the frequency of the digest of `user`. Turning it on starts from now instead of sending
+// the articles of the whole period on the next schedule.
+func setFrequency(user *users.UserModel, frequency string) error {
+	db := common.GetDB()
+	columns := map[string]interface{}{"digest_frequency": frequency}
+	if period(user.DigestFrequency) == 0 && period(frequency) != 0 {
+		columns["digest_sent_at"] = time.Now()
+	}
+	return db.Model(user).UpdateColumns(columns).Error
+}
@@ -0,0 +1,17 @@
+/*
+The digest module emailing users a daily or weekly summary of the new articles of the authors they
+follow, through the job queue and the mailer.
+
+digest.go: frequencies, the scheduling job and the job building and sending one digest
+
+templates.go: rendering of the HTML and plain-text emails, and of the unsubscribe page, from templates/
+
+tokens.go: the signed tokens of the unsubscribe links
+
+routers.go: router binding and core logic
+
+serializers.go: definition the schema of return data
+
+validators.go: definition the validator of form data
+*/
+package digest
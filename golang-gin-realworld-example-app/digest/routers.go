@@ -0,0 +1,90 @@
+package digest
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// The unsubscribe links of the emails work without logging in, the token names the user.
+func DigestAnonymousRegister(router *gin.RouterGroup) {
+	router.GET("/unsubscribe", DigestUnsubscribeConfirm)
+	router.POST("/unsubscribe", DigestUnsubscribe)
+}
+
+func DigestRegister(router *gin.RouterGroup) {
+	router.GET("/", DigestRetrieve)
+	router.PUT("/", DigestUpdate)
+}
+
+func DigestRetrieve(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	serializer := DigestSerializer{c, myUserModel}
+	c.JSON(http.StatusOK, gin.H{"digest": serializer.Response()})
+}
+
+func DigestUpdate(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	digestValidator := NewDigestValidator()
+	if err := digestValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if err := setFrequency(&myUserModel, digestValidator.Digest.Frequency); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := DigestSerializer{c, myUserModel}
+	c.JSON(http.StatusOK, gin.H{"digest": serializer.Response()})
+}
+
+// The user named by `?token=`, from the link of a digest. Answers 404 when it is invalid.
+func unsubscribingUser(c *gin.Context) (users.UserModel, bool) {
+	var myUserModel users.UserModel
+	userID, err := parseUnsubscribeToken(c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("digest", err))
+		return myUserModel, false
+	}
+	common.GetDB().First(&myUserModel, userID)
+	if myUserModel.ID == 0 {
+		c.JSON(http.StatusNotFound, common.NewError("digest", errInvalidToken))
+		return myUserModel, false
+	}
+	return myUserModel, true
+}
+
+// People clicking the link of a digest get a form to confirm, a GET changes nothing since mail
+// scanners and link previews follow links too.
+func DigestUnsubscribeConfirm(c *gin.Context) {
+	myUserModel, ok := unsubscribingUser(c)
+	if !ok {
+		return
+	}
+	html, err := renderUnsubscribe(myUserModel, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewError("digest", err))
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", html)
+}
+
+// Posted by the confirmation form, and by mail clients for one click unsubscribe (RFC 8058).
+func DigestUnsubscribe(c *gin.Context) {
+	myUserModel, ok := unsubscribingUser(c)
+	if !ok {
+		return
+	}
+	if err := setFrequency(&myUserModel, FrequencyOff); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	html, err := renderUnsubscribe(myUserModel, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewError("digest", err))
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", html)
+}
@@ -0,0 +1,28 @@
+package digest
+
+import (
+	"github.com/gin-gonic/gin"
+	"realworld-backend/users"
+)
+
+type DigestResponse struct {
+	Frequency  string  `json:"frequency"`
+	LastSentAt *string `json:"lastSentAt"`
+}
+
+type DigestSerializer struct {
+	C *gin.Context
+	users.UserModel
+}
+
+func (s *DigestSerializer) Response() DigestResponse {
+	response := DigestResponse{Frequency: s.DigestFrequency}
+	if response.Frequency == "" {
+		response.Frequency = FrequencyOff
+	}
+	if s.DigestSentAt != nil {
+		sentAt := s.DigestSentAt.UTC().Format("2006-01-02T15:04:05.999Z")
+		response.LastSentAt = &sentAt
+	}
+	return response
+}
@@ -0,0 +1,130 @@
+package digest
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strconv"
+	texttemplate "text/template"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/mail"
+	"realworld-backend/users"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/digest.html"))
+	textTemplate = texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/digest.txt"))
+
+	unsubscribeTemplate = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/unsubscribe.html"))
+)
+
+type templateArticle struct {
+	Title       string
+	Description string
+	URL         string
+	Author      string
+	AuthorURL   string
+	ReadingTime int
+}
+
+type templateData struct {
+	Subject        string
+	SiteName       string
+	SiteURL        string
+	Username       string
+	Frequency      string
+	Period         string
+	Articles       []templateArticle
+	SettingsURL    string
+	UnsubscribeURL string
+}
+
+// The digest email of `articleModels` for `user`, links point to the frontend except the
+// unsubscribe one which works without logging in.
+func render(user users.UserModel, articleModels []articles.ArticleModel) (mail.Message, error) {
+	data := templateData{
+		Subject:        subject(len(articleModels)),
+		SiteName:       common.Getenv("SITE_NAME", "Conduit"),
+		SiteURL:        common.SiteURL("/"),
+		Username:       user.Username,
+		Frequency:      user.DigestFrequency,
+		Period:         "today",
+		SettingsURL:    common.SiteURL("/settings"),
+		UnsubscribeURL: unsubscribeURL(user.ID),
+	}
+	if user.DigestFrequency == FrequencyWeekly {
+		data.Period = "this week"
+	}
+	for _, articleModel := range articleModels {
+		description := articleModel.Description
+		if description == "" {
+			description = articleModel.Excerpt
+		}
+		author := articleModel.Author.UserModel.Username
+		data.Articles = append(data.Articles, templateArticle{
+			Title:       articleModel.Title,
+			Description: description,
+			URL:         common.SiteURL("/article/" + articleModel.Slug),
+			Author:      author,
+			AuthorURL:   common.SiteURL("/@" + author),
+			ReadingTime: articleModel.ReadingTime,
+		})
+	}
+
+	var html, text bytes.Buffer
+	if err := htmlTemplate.Execute(&html, data); err != nil {
+		return mail.Message{}, err
+	}
+	if err := textTemplate.Execute(&text, data); err != nil {
+		return mail.Message{}, err
+	}
+	return mail.Message{
+		To:      user.Email,
+		Subject: data.Subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			// One click unsubscribe (RFC 8058): mail clients POST to the link.
+			"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
+
+func subject(count int) string {
+	if count == 1 {
+		return "1 new article from authors you follow"
+	}
+	return strconv.Itoa(count) + " new articles from authors you follow"
+}
+
+type unsubscribeData struct {
+	SiteName       string
+	SiteURL        string
+	SettingsURL    string
+	Username       string
+	UnsubscribeURL string
+	Unsubscribed   bool
+}
+
+// The page of the unsubscribe link: a form to confirm, or the confirmation once unsubscribed.
+func renderUnsubscribe(user users.UserModel, unsubscribed bool) ([]byte, error) {
+	data := unsubscribeData{
+		SiteName:       common.Getenv("SITE_NAME", "Conduit"),
+		SiteURL:        common.SiteURL("/"),
+		SettingsURL:    common.SiteURL("/settings"),
+		Username:       user.Username,
+		UnsubscribeURL: unsubscribeURL(user.ID),
+		Unsubscribed:   unsubscribed,
+	}
+	var html bytes.Buffer
+	if err := unsubscribeTemplate.Execute(&html, data); err != nil {
+		return nil, err
+	}
+	return html.Bytes(), nil
+}
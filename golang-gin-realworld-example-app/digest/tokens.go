@@ -0,0 +1,47 @@
+package digest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"realworld-backend/common"
+)
+
+var errInvalidToken = errors.New("Invalid unsubscribe token")
+
+// A token naming `userID`, signed with the secret of the api so it can't be made up for another
+// user. It doesn't expire, links in old digests keep working.
+//
+//	token := digest.UnsubscribeToken(userModel.ID) // "42.Jd3k..."
+func UnsubscribeToken(userID uint) string {
+	id := strconv.FormatUint(uint64(userID), 10)
+	return id + "." + signature(id)
+}
+
+func signature(id string) string {
+	mac := hmac.New(sha256.New, []byte(common.NBSecretPassword))
+	mac.Write([]byte("digest.unsubscribe:" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// The user a token was made for.
+func parseUnsubscribeToken(token string) (uint, error) {
+	id, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signature(id))) {
+		return 0, errInvalidToken
+	}
+	userID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || userID == 0 {
+		return 0, errInvalidToken
+	}
+	return uint(userID), nil
+}
+
+func unsubscribeURL(userID uint) string {
+	return common.APIURL("/api/digest/unsubscribe?token=" + url.QueryEscape(UnsubscribeToken(userID)))
+}
@@ -0,0 +1,174 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/jobs"
+	"realworld-backend/mail"
+	"realworld-backend/users"
+)
+
+var test_db *gorm.DB
+
+func newUser(name string) users.UserModel {
+	userModel := users.UserModel{Username: name, Email: name + "@digest.test", PasswordHash: "x"}
+	test_db.Create(&userModel)
+	return userModel
+}
+
+func newArticle(title string, author users.UserModel, createdAt time.Time) {
+	article := articles.ArticleModel{Title: title, Slug: title, Description: "About " + title, Body: "Body", Author: articles.GetArticleUserModel(context.Background(), author)}
+	articles.SaveOne(&article)
+	test_db.Model(&article).UpdateColumn("created_at", createdAt)
+}
+
+func newRouter(current users.UserModel) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	DigestAnonymousRegister(r.Group("/api/digest"))
+	r.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, current.ID)
+	})
+	DigestRegister(r.Group("/api/digest"))
+	return r
+}
+
+func request(r *gin.Engine, method, url, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func reload(user users.UserModel) users.UserModel {
+	var userModel users.UserModel
+	test_db.First(&userModel, user.ID)
+	return userModel
+}
+
+func TestUnsubscribeToken(t *testing.T) {
+	asserts := assert.New(t)
+
+	token := UnsubscribeToken(42)
+	userID, err := parseUnsubscribeToken(token)
+	asserts.NoError(err)
+	asserts.Equal(uint(42), userID)
+
+	for _, invalid := range []string{"", "42", "43." + token[3:], token + "x", "0." + signature("0")} {
+		_, err := parseUnsubscribeToken(invalid)
+		asserts.Equal(errInvalidToken, err, "token %q should be refused", invalid)
+	}
+}
+
+func TestDue(t *testing.T) {
+	asserts := assert.New(t)
+
+	now := time.Now()
+	sentAt := now.Add(-23*time.Hour - time.Minute)
+	asserts.False(due(users.UserModel{}, now), "digests are off by default")
+	asserts.True(due(users.UserModel{DigestFrequency: FrequencyDaily}, now), "a first digest is due")
+	asserts.True(due(users.UserModel{DigestFrequency: FrequencyDaily, DigestSentAt: &sentAt}, now), "a digest sent a bit late should not delay the next one")
+	asserts.False(due(users.UserModel{DigestFrequency: FrequencyWeekly, DigestSentAt: &sentAt}, now))
+}
+
+func TestDigest(t *testing.T) {
+	asserts := assert.New(t)
+
+	captured := &mail.CaptureMailer{}
+	mail.SetMailer(captured)
+	defer mail.SetMailer(mail.LogMailer{})
+
+	writer := newUser("digestwriter")
+	muted := newUser("digestmuted")
+	stranger := newUser("digeststranger")
+	reader := newUser("digestreader")
+	test_db.Create(&users.FollowModel{FollowingID: writer.ID, FollowedByID: reader.ID})
+	test_db.Create(&users.FollowModel{FollowingID: muted.ID, FollowedByID: reader.ID})
+	test_db.Create(&users.MuteModel{MuterID: reader.ID, MutedID: muted.ID})
+
+	r := newRouter(reader)
+	w := request(r, "GET", "/api/digest/", "")
+	asserts.Equal(`{"digest":{"frequency":"off","lastSentAt":null}}`, w.Body.String(), "digests should be off by default")
+	w = request(r, "PUT", "/api/digest/", `{"digest":{"frequency":"hourly"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "unknown frequencies should be refused")
+	w = request(r, "PUT", "/api/digest/", `{"digest":{"frequency":"weekly"}}`)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"frequency":"weekly"`)
+	asserts.Empty(dueUserIDs(time.Now()), "a digest just turned on should wait a period")
+
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+	test_db.Model(&reader).UpdateColumn("digest_sent_at", lastWeek)
+	newArticle("before-last-digest", writer, lastWeek.Add(-time.Hour))
+	newArticle("first-new-article", writer, time.Now().Add(-2*time.Hour))
+	newArticle("second-new-article", writer, time.Now().Add(-time.Hour))
+	newArticle("muted-article", muted, time.Now().Add(-time.Hour))
+	newArticle("stranger-article", stranger, time.Now().Add(-time.Hour))
+	asserts.Equal([]uint{reader.ID}, dueUserIDs(time.Now()), "a digest should be due after its period")
+
+	asserts.NoError(runSchedule(context.Background(), scheduleDigests{}))
+	var queued jobs.JobModel
+	test_db.Where("type = ? AND status = ?", "digest.send", jobs.StatusPending).First(&queued)
+	asserts.Equal(`{"userId":`+strconv.FormatUint(uint64(reader.ID), 10)+`}`, queued.Payload, "the schedule should queue the due digests")
+	asserts.Equal("mail", queued.Queue)
+
+	asserts.NoError(runSend(context.Background(), sendDigest{UserID: reader.ID}))
+	messages := captured.Messages()
+	asserts.Len(messages, 1, "the digest should be sent")
+	message := messages[0]
+	asserts.Equal("digestreader@digest.test", message.To)
+	asserts.Equal("2 new articles from authors you follow", message.Subject)
+	asserts.Contains(message.Text, "first-new-article\nby digestwriter")
+	asserts.Less(strings.Index(message.Text, "first-new-article"), strings.Index(message.Text, "second-new-article"), "articles should be in publication order")
+	asserts.NotContains(message.Text, "before-last-digest", "articles of the previous digest should be left out")
+	asserts.NotContains(message.Text, "muted-article", "muted authors should be left out")
+	asserts.NotContains(message.Text, "stranger-article", "only followed authors should be in the digest")
+	asserts.Contains(message.HTML, `<a href="http://localhost:4100/article/first-new-article"`)
+	unsubscribe := "http://localhost:8080/api/digest/unsubscribe?token=" + url.QueryEscape(UnsubscribeToken(reader.ID))
+	asserts.Contains(message.Text, unsubscribe)
+	asserts.Equal("<"+unsubscribe+">", message.Headers["List-Unsubscribe"])
+	asserts.NotNil(reload(reader).DigestSentAt)
+	asserts.True(reload(reader).DigestSentAt.After(lastWeek.Add(time.Minute)), "the send time should be recorded")
+
+	captured.Reset()
+	asserts.NoError(runSend(context.Background(), sendDigest{UserID: reader.ID}))
+	asserts.Empty(captured.Messages(), "a digest should not be sent twice in a period")
+
+	w = request(r, "GET", "/api/digest/unsubscribe?token=1.forged", "")
+	asserts.Equal(http.StatusNotFound, w.Code, "forged tokens should be refused")
+	w = request(r, "GET", "/api/digest/unsubscribe?token="+url.QueryEscape(UnsubscribeToken(reader.ID)), "")
+	asserts.Equal(http.StatusOK, w.Code, "the unsubscribe link should work without logging in")
+	asserts.Contains(w.Body.String(), `<form method="post" action="`+unsubscribe+`">`, "opening the link should ask to confirm")
+	asserts.Equal(FrequencyWeekly, reload(reader).DigestFrequency, "opening the link should not unsubscribe")
+	w = request(r, "POST", "/api/digest/unsubscribe?token="+url.QueryEscape(UnsubscribeToken(reader.ID)), "List-Unsubscribe=One-Click")
+	asserts.Equal(http.StatusOK, w.Code, "one click unsubscribe should work without logging in")
+	asserts.Contains(w.Body.String(), "you will not get the digest anymore")
+	asserts.Equal(FrequencyOff, reload(reader).DigestFrequency)
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	users.AutoMigrate()
+	events.AutoMigrate()
+	articles.AutoMigrateArticles(test_db)
+	jobs.AutoMigrate()
+	RegisterJobs()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
@@ -0,0 +1,21 @@
+package digest
+
+import (
+	"github.com/gin-gonic/gin"
+	"realworld-backend/common"
+)
+
+// {"digest": {"frequency": "weekly"}}
+type DigestValidator struct {
+	Digest struct {
+		Frequency string `json:"frequency" binding:"required,oneof=off daily weekly"`
+	} `json:"digest"`
+}
+
+func NewDigestValidator() DigestValidator {
+	return DigestValidator{}
+}
+
+func (s *DigestValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, s)
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// A subscriber, Name identifies it in the outbox so a retry only runs the handlers that failed.
+type handler struct {
+	Name string
+	Func func(uint, Event) error
+}
+
+var (
+	mutex    sync.RWMutex
+	handlers = map[string][]handler{}
+	// Turn a recorded payload back into its event, one per subscribed event type.
+	decoders = map[string]func([]byte) (Event, error){}
+)
+
+// Call `handle` for every dispatched event of type T, register once at startup. `name` must be
+// stable across restarts, events recorded before a restart are retried by it.
+//
+//	events.Subscribe("notifications", func(event events.UserFollowed) error { ... })
+func Subscribe[T Event](name string, handle func(T) error) {
+	SubscribeWithID(name, func(id uint, event T) error {
+		return handle(event)
+	})
+}
+
+// Like Subscribe, with the outbox id of the event. It is the same on every retry, so a handler
+// can use it to record what it does only once.
+//
+//	events.SubscribeWithID("webhooks", func(id uint, event events.UserFollowed) error { ... })
+func SubscribeWithID[T Event](name string, handle func(uint, T) error) {
+	var zero T
+	mutex.Lock()
+	defer mutex.Unlock()
+	handlers[zero.EventName()] = append(handlers[zero.EventName()], handler{name, func(id uint, event Event) error {
+		return handle(id, event.(T))
+	}})
+	decoders[zero.EventName()] = func(payload []byte) (Event, error) {
+		var event T
+		err := json.Unmarshal(payload, &event)
+		return event, err
+	}
+}
+
+func subscribers(eventName string) []handler {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return handlers[eventName]
+}
+
+func decode(eventName string, payload []byte) (Event, bool, error) {
+	mutex.RLock()
+	decoder, ok := decoders[eventName]
+	mutex.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	event, err := decoder(payload)
+	return event, true, err
+}
@@ -0,0 +1,12 @@
+/*
+The events module containing the domain events raised when users interact, the outbox they are
+recorded in with the change that raised them, and the dispatcher handing them to subscribers such
+as notifications and webhooks.
+
+events.go: definition of the events
+
+bus.go: subscription of the handlers
+
+outbox.go: recording, dispatching with retries, and the dead letters
+*/
+package events
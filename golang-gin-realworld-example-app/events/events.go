@@ -0,0 +1,81 @@
+package events
+
+// Every event names itself, subscribers and webhooks refer to events by this name.
+type Event interface {
+	EventName() string
+}
+
+// Ids of users are UserModel ids, never ArticleUserModel ids.
+type UserFollowed struct {
+	FollowerID  uint `json:"followerId"`
+	FollowingID uint `json:"followingId"`
+}
+
+func (UserFollowed) EventName() string { return "user.followed" }
+
+type ArticleFavorited struct {
+	ArticleID uint `json:"articleId"`
+	AuthorID  uint `json:"authorId"`
+	UserID    uint `json:"userId"`
+}
+
+func (ArticleFavorited) EventName() string { return "article.favorited" }
+
+type CommentCreated struct {
+	CommentID       uint `json:"commentId"`
+	ArticleID       uint `json:"articleId"`
+	ArticleAuthorID uint `json:"articleAuthorId"`
+	AuthorID        uint `json:"authorId"`
+}
+
+func (CommentCreated) EventName() string { return "comment.created" }
+
+// CommentID is 0 for mentions in the body of the article.
+type UserMentioned struct {
+	ArticleID uint `json:"articleId"`
+	CommentID uint `json:"commentId"`
+	AuthorID  uint `json:"authorId"`
+	UserID    uint `json:"userId"`
+}
+
+func (UserMentioned) EventName() string { return "user.mentioned" }
+
+type ArticleCreated struct {
+	ArticleID uint   `json:"articleId"`
+	Slug      string `json:"slug"`
+	AuthorID  uint   `json:"authorId"`
+}
+
+func (ArticleCreated) EventName() string { return "article.created" }
+
+type ArticleUpdated struct {
+	ArticleID uint   `json:"articleId"`
+	Slug      string `json:"slug"`
+	AuthorID  uint   `json:"authorId"`
+}
+
+func (ArticleUpdated) EventName() string { return "article.updated" }
+
+type ArticleDeleted struct {
+	ArticleID uint   `json:"articleId"`
+	Slug      string `json:"slug"`
+	AuthorID  uint   `json:"authorId"`
+}
+
+func (ArticleDeleted) EventName() string { return "article.deleted" }
+
+type CommentDeleted struct {
+	CommentID uint `json:"commentId"`
+	ArticleID uint `json:"articleId"`
+	AuthorID  uint `json:"authorId"`
+}
+
+func (CommentDeleted) EventName() string { return "comment.deleted" }
+
+type ArticleUnfavorited struct {
+	ArticleID uint `json:"articleId"`
+	AuthorID  uint `json:"authorId"`
+	UserID    uint `json:"userId"`
+}
+
+func (ArticleUnfavorited) EventName() string { return "article.unfavorited" }
@@ -0,0 +1,276 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+)
+
+// An event waiting to be handed to its subscribers. It is written in the transaction of the change
+// that raised it, so an event is never lost nor sent for a change that was rolled back.
+type OutboxModel struct {
+	gorm.Model
+	Name          string `gorm:"size:64;index"`
+	Payload       string `gorm:"type:text"`
+	Handlers      string `gorm:"size:1024"` // comma separated names still to run, empty for all
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index"`
+	LockedUntil   *time.Time
+	LastError     string     `gorm:"size:1024"`
+	DispatchedAt  *time.Time `gorm:"index"`
+}
+
+// A handler that kept failing on an event, kept for inspection until it is retried.
+type DeadLetterModel struct {
+	gorm.Model
+	OutboxID  uint   `gorm:"index"`
+	Name      string `gorm:"size:64"`
+	Handler   string `gorm:"size:64"`
+	Payload   string `gorm:"type:text"`
+	Attempts  int
+	LastError string `gorm:"size:1024"`
+}
+
+func AutoMigrate() {
+	db := common.GetDB()
+
+	db.AutoMigrate(&OutboxModel{})
+	db.AutoMigrate(&DeadLetterModel{})
+}
+
+// Write `event` to the outbox within `tx`, the change raising it. Dispatch the returned id once
+// `tx` is committed, the dispatcher picks up what isn't.
+//
+//	tx := db.Begin()
+//	tx.Create(&follow)
+//	id, err := events.Record(tx, events.UserFollowed{FollowerID: u.ID, FollowingID: v.ID})
+//	...
+//	tx.Commit()
+//	events.Dispatch(id)
+func Record(tx *gorm.DB, event Event) (uint, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	model := OutboxModel{Name: event.EventName(), Payload: string(payload), NextAttemptAt: time.Now()}
+	err = tx.Create(&model).Error
+	return model.ID, err
+}
+
+// Record `event` on its own and dispatch it, for events that don't come with a change of their own.
+func Publish(event Event) {
+	id, err := Record(common.GetDB(), event)
+	if err != nil {
+		slog.Error("events err: (Publish)", "err", err)
+		return
+	}
+	Dispatch(id)
+}
+
+// Hand the recorded events `ids` to their subscribers in the calling goroutine, so what they do is
+// visible as soon as the request that raised them returns. Failures are retried by the dispatcher.
+func Dispatch(ids ...uint) {
+	for _, id := range ids {
+		if id == 0 {
+			continue
+		}
+		var model OutboxModel
+		if err := common.GetDB().First(&model, id).Error; err != nil {
+			continue
+		}
+		attempt(&model)
+	}
+}
+
+var wakeup = make(chan struct{}, 1)
+
+// Nudge the dispatcher instead of waiting for its next poll.
+func wake() {
+	select {
+	case wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Dispatch due events until ctx is done, started once from main. Events are polled from the
+// outbox, so the ones a crash left behind are dispatched after a restart.
+func RunDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(common.GetenvDuration("EVENTS_POLL_INTERVAL", 5*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wakeup:
+		}
+		DispatchDue(ctx)
+	}
+}
+
+// Dispatch every event whose next attempt is due, returns how many were fully handled.
+func DispatchDue(ctx context.Context) int {
+	db := common.GetDB()
+	var models []OutboxModel
+	now := time.Now()
+	db.Where("dispatched_at IS NULL AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until < ?)", now, now).
+		Order("id").Limit(100).Find(&models)
+	dispatched := 0
+	for i := range models {
+		if ctx.Err() != nil {
+			break
+		}
+		if attempt(&models[i]) {
+			dispatched++
+		}
+	}
+	return dispatched
+}
+
+// How long an attempt holds an event before another instance may take it over.
+const lease = time.Minute
+
+// Delay before the next attempt, doubling from EVENTS_RETRY_BASE up to an hour.
+func backoff(attempts int) time.Duration {
+	delay := common.GetenvDuration("EVENTS_RETRY_BASE", 10*time.Second)
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// Run the handlers of the event once and record the outcome. The handlers that failed are retried
+// alone, after EVENTS_MAX_ATTEMPTS they go to the dead letters. Returns whether the event is done.
+func attempt(model *OutboxModel) bool {
+	db := common.GetDB()
+	now := time.Now()
+	lockedUntil := now.Add(lease)
+	claim := db.Model(&OutboxModel{}).
+		Where("id = ? AND dispatched_at IS NULL AND (locked_until IS NULL OR locked_until < ?)", model.ID, now).
+		Update("locked_until", lockedUntil)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		// Dispatched already, or being dispatched somewhere else.
+		return false
+	}
+
+	failed := map[string]error{}
+	maxAttempts := common.GetenvInt("EVENTS_MAX_ATTEMPTS", 5)
+	event, known, err := decode(model.Name, []byte(model.Payload))
+	if err != nil {
+		// Retrying won't make the payload readable.
+		failed["decode"] = err
+		model.Attempts = maxAttempts - 1
+	} else if known {
+		pending := map[string]bool{}
+		for _, name := range strings.Split(model.Handlers, ",") {
+			if name != "" {
+				pending[name] = true
+			}
+		}
+		for _, h := range subscribers(model.Name) {
+			if len(pending) > 0 && !pending[h.Name] {
+				continue
+			}
+			if err := h.Func(model.ID, event); err != nil {
+				slog.Error("events err: (handle)", "event", model.Name, "handler", h.Name, "err", err)
+				failed[h.Name] = err
+			}
+		}
+	}
+
+	model.Attempts++
+	model.LockedUntil = nil
+	if len(failed) == 0 {
+		model.DispatchedAt = &now
+		model.Handlers = ""
+		model.LastError = ""
+	} else {
+		names := make([]string, 0, len(failed))
+		messages := make([]string, 0, len(failed))
+		for name, err := range failed {
+			names = append(names, name)
+			messages = append(messages, name+": "+err.Error())
+		}
+		model.Handlers = strings.Join(names, ",")
+		model.LastError = truncate(strings.Join(messages, "; "))
+		if model.Attempts >= maxAttempts {
+			if err := deadLetter(model, failed); err != nil {
+				slog.Error("events err: (deadLetter)", "err", err)
+				return false
+			}
+			model.DispatchedAt = &now
+		} else {
+			model.NextAttemptAt = now.Add(backoff(model.Attempts))
+		}
+	}
+	if err := db.Save(model).Error; err != nil {
+		slog.Error("events err: (attempt)", "err", err)
+		return false
+	}
+	return len(failed) == 0
+}
+
+func deadLetter(model *OutboxModel, failed map[string]error) error {
+	tx := common.GetDB().Begin()
+	for name, err := range failed {
+		letter := DeadLetterModel{
+			OutboxID:  model.ID,
+			Name:      model.Name,
+			Handler:   name,
+			Payload:   model.Payload,
+			Attempts:  model.Attempts,
+			LastError: truncate(err.Error()),
+		}
+		if err := tx.Create(&letter).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+func truncate(message string) string {
+	if len(message) > 1024 {
+		return message[:1024]
+	}
+	return message
+}
+
+// Dead letters, newest first.
+func DeadLetters(limit int) []DeadLetterModel {
+	var models []DeadLetterModel
+	common.GetDB().Order("id desc").Limit(limit).Find(&models)
+	return models
+}
+
+// Give the handler of a dead letter another round of attempts, once whatever made it fail is fixed.
+func Retry(deadLetterID uint) error {
+	db := common.GetDB()
+	var letter DeadLetterModel
+	if err := db.First(&letter, deadLetterID).Error; err != nil {
+		return err
+	}
+	tx := db.Begin()
+	model := OutboxModel{Name: letter.Name, Payload: letter.Payload, Handlers: letter.Handler, NextAttemptAt: time.Now()}
+	if err := tx.Create(&model).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(&letter).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	wake()
+	return nil
+}
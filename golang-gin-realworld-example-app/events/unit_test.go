@@ -0,0 +1,109 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+)
+
+var test_db *gorm.DB
+
+func TestPublish(t *testing.T) {
+	asserts := assert.New(t)
+
+	var followed []UserFollowed
+	Subscribe("failing", func(event UserFollowed) error {
+		return errors.New("failing subscriber")
+	})
+	Subscribe("recording", func(event UserFollowed) error {
+		followed = append(followed, event)
+		return nil
+	})
+	favorited := 0
+	Subscribe("counting", func(event ArticleFavorited) error {
+		favorited++
+		return nil
+	})
+
+	Publish(UserFollowed{FollowerID: 1, FollowingID: 2})
+	asserts.Equal([]UserFollowed{{FollowerID: 1, FollowingID: 2}}, followed, "a failing subscriber should not stop the others")
+	asserts.Equal(0, favorited, "subscribers should only get their type of event")
+	Publish(ArticleFavorited{ArticleID: 3})
+	asserts.Equal(1, favorited, "subscribers should get their type of event")
+	Publish(CommentCreated{CommentID: 4})
+
+	var model OutboxModel
+	test_db.Where(OutboxModel{Name: "user.followed"}).First(&model)
+	asserts.Nil(model.DispatchedAt, "an event with a failing handler should stay in the outbox")
+	asserts.Equal("failing", model.Handlers, "only the failing handler should be retried")
+	asserts.Equal(1, model.Attempts)
+	asserts.True(model.NextAttemptAt.After(time.Now()), "the retry should wait")
+
+	// Retries run the failing handler alone until it goes to the dead letters.
+	os.Setenv("EVENTS_MAX_ATTEMPTS", "3")
+	defer os.Unsetenv("EVENTS_MAX_ATTEMPTS")
+	for i := 0; i < 2; i++ {
+		test_db.Model(&model).Update("next_attempt_at", time.Now())
+		DispatchDue(context.Background())
+	}
+	asserts.Len(followed, 1, "handlers that succeeded should not run again")
+	test_db.First(&model, model.ID)
+	asserts.NotNil(model.DispatchedAt, "an event should be given up after the last attempt")
+	letters := DeadLetters(10)
+	asserts.Len(letters, 1, "the failing handler should be dead lettered")
+	asserts.Equal("failing", letters[0].Handler)
+	asserts.Equal("failing subscriber", letters[0].LastError)
+
+	asserts.NoError(Retry(letters[0].ID))
+	asserts.Len(DeadLetters(10), 0, "a retried dead letter should leave the table")
+	var retried OutboxModel
+	test_db.Order("id desc").First(&retried)
+	asserts.Equal("failing", retried.Handlers, "a retry should only run the dead lettered handler")
+}
+
+func TestRecord(t *testing.T) {
+	asserts := assert.New(t)
+
+	var created []ArticleCreated
+	Subscribe("recording", func(event ArticleCreated) error {
+		created = append(created, event)
+		return nil
+	})
+	var ids []uint
+	SubscribeWithID("identifying", func(id uint, event ArticleCreated) error {
+		ids = append(ids, id)
+		return nil
+	})
+
+	tx := test_db.Begin()
+	_, err := Record(tx, ArticleCreated{ArticleID: 1, Slug: "rolled-back"})
+	asserts.NoError(err)
+	tx.Rollback()
+	asserts.Equal(0, DispatchDue(context.Background()), "events of rolled back changes should not exist")
+
+	// A crash between the commit and the dispatch leaves the event to the dispatcher.
+	tx = test_db.Begin()
+	id, err := Record(tx, ArticleCreated{ArticleID: 2, Slug: "committed"})
+	asserts.NoError(err)
+	tx.Commit()
+	asserts.Equal(1, DispatchDue(context.Background()), "committed events should be dispatched")
+	asserts.Equal([]ArticleCreated{{ArticleID: 2, Slug: "committed"}}, created)
+	asserts.Equal([]uint{id}, ids, "handlers should get the outbox id of the event")
+
+	Dispatch(id)
+	asserts.Len(created, 1, "dispatched events should not be dispatched again")
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	AutoMigrate()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
@@ -0,0 +1,8 @@
+/*
+The feeds module containing the Atom, RSS and JSON Feed subscriptions to articles.
+
+routers.go: router binding, caching headers and core logic
+
+serializers.go: definition of the three feed formats
+*/
+package feeds
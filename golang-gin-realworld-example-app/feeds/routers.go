@@ -0,0 +1,159 @@
+package feeds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+func FeedsRegister(router *gin.RouterGroup) {
+	for _, format := range formats {
+		router.GET("/articles."+format, ArticlesFeed)
+	}
+	router.GET("/authors/:feed", AuthorFeed)
+	router.GET("/tags/:feed", TagFeed)
+}
+
+var formats = []string{"atom", "rss", "json"}
+
+// Number of articles in a feed, `?limit=` can ask for up to maxFeedLimit.
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+// Split "alice.rss" into "alice" and "rss", names without a known extension get Atom.
+func splitFormat(name string) (string, string) {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		for _, format := range formats {
+			if name[i+1:] == format {
+				return name[:i], format
+			}
+		}
+	}
+	return name, "atom"
+}
+
+func feedLimit(c *gin.Context) string {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultFeedLimit
+	}
+	if limit > maxFeedLimit {
+		limit = maxFeedLimit
+	}
+	return strconv.Itoa(limit)
+}
+
+func ArticlesFeed(c *gin.Context) {
+	_, format := splitFormat(c.Request.URL.Path)
+	serveFeed(c, format, common.Getenv("SITE_NAME", "Conduit"), common.SiteURL("/"), "", "")
+}
+
+func AuthorFeed(c *gin.Context) {
+	username, format := splitFormat(c.Param("feed"))
+	if _, err := users.FindOneUser(c.Request.Context(), &users.UserModel{Username: username}); err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("feed", errors.New("Invalid username")))
+		return
+	}
+	title := fmt.Sprintf("%v - %v", username, common.Getenv("SITE_NAME", "Conduit"))
+	serveFeed(c, format, title, common.SiteURL("/@"+username), "", username)
+}
+
+func TagFeed(c *gin.Context) {
+	tag, format := splitFormat(c.Param("feed"))
+	tagModel, err := articles.FindOneTag(tag)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("feed", errors.New("Invalid tag")))
+		return
+	}
+	title := fmt.Sprintf("#%v - %v", tagModel.Tag, common.Getenv("SITE_NAME", "Conduit"))
+	serveFeed(c, format, title, common.SiteURL("/"), tagModel.Tag, "")
+}
+
+func serveFeed(c *gin.Context, format, title, homeURL, tag, author string) {
+	articleModels, _, err := articles.FindManyArticle(c.Request.Context(), tag, author, feedLimit(c), "0", "", nil)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("feed", errors.New("Invalid param")))
+		return
+	}
+
+	feed := Feed{
+		Title:    title,
+		FeedURL:  common.RequestBaseURL(c) + c.Request.URL.RequestURI(),
+		HomeURL:  homeURL,
+		Articles: articleModels,
+	}
+	// The feed changes when an article is added, removed or edited, which is all the validators
+	// have to capture. A deleted article leaves no updated_at behind, its deleted_at counts instead.
+	hash := sha256.New()
+	fmt.Fprint(hash, format, feed.FeedURL)
+	for _, article := range articleModels {
+		fmt.Fprint(hash, article.ID, article.UpdatedAt.UnixNano())
+		if article.UpdatedAt.After(feed.Updated) {
+			feed.Updated = article.UpdatedAt
+		}
+	}
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Unix(0, 0)
+	}
+	lastModified := feed.Updated
+	if deleted := articles.LastArticleDeletion(c.Request.Context()); !deleted.IsZero() {
+		fmt.Fprint(hash, deleted.UnixNano())
+		if deleted.After(lastModified) {
+			lastModified = deleted
+		}
+	}
+	etag := `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "public, max-age=300")
+	if notModified(c, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	switch format {
+	case "rss":
+		renderXML(c, "application/rss+xml; charset=utf-8", feed.RSS())
+	case "json":
+		c.Header("Content-Type", "application/feed+json; charset=utf-8")
+		c.JSON(http.StatusOK, feed.JSONFeed())
+	default:
+		renderXML(c, "application/atom+xml; charset=utf-8", feed.Atom())
+	}
+}
+
+// Conditional GET, If-None-Match wins over If-Modified-Since as RFC 9110 asks.
+func notModified(c *gin.Context, etag string, updated time.Time) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	return err == nil && !updated.Truncate(time.Second).After(since)
+}
+
+func renderXML(c *gin.Context, contentType string, body interface{}) {
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	c.Writer.WriteString(xml.Header)
+	xml.NewEncoder(c.Writer).Encode(body)
+}
@@ -0,0 +1,248 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+)
+
+// The format independent content of a feed, rendered by one of the serializers below.
+type Feed struct {
+	Title    string
+	FeedURL  string
+	HomeURL  string
+	Updated  time.Time
+	Articles []articles.ArticleModel
+}
+
+// Uploaded images have urls relative to the api, feed readers fetch them from elsewhere.
+func absoluteURL(url string) string {
+	if strings.HasPrefix(url, "/") {
+		return common.APIURL(url)
+	}
+	return url
+}
+
+func avatarURL(article articles.ArticleModel) *string {
+	image := article.Author.UserModel.Image
+	if image == nil || *image == "" {
+		return nil
+	}
+	url := absoluteURL(*image)
+	return &url
+}
+
+func articleURL(article articles.ArticleModel) string {
+	return common.SiteURL("/article/" + article.Slug)
+}
+
+func authorURL(article articles.ArticleModel) string {
+	return common.SiteURL("/@" + article.Author.UserModel.Username)
+}
+
+func articleSummary(article articles.ArticleModel) string {
+	if article.Description != "" {
+		return article.Description
+	}
+	return article.Excerpt
+}
+
+func articleTags(article articles.ArticleModel) []string {
+	tags := make([]string, 0, len(article.Tags))
+	for _, tag := range article.Tags {
+		tags = append(tags, tag.Tag)
+	}
+	return tags
+}
+
+// Atom 1.0, https://www.rfc-editor.org/rfc/rfc4287
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []AtomLink  `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type AtomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       AtomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     AtomAuthor     `xml:"author"`
+	Summary    string         `xml:"summary,omitempty"`
+	Content    AtomContent    `xml:"content"`
+	Categories []AtomCategory `xml:"category"`
+}
+
+type AtomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func (feed Feed) Atom() AtomFeed {
+	response := AtomFeed{
+		Title:   feed.Title,
+		ID:      feed.FeedURL,
+		Updated: feed.Updated.UTC().Format(time.RFC3339),
+		Links: []AtomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: feed.FeedURL},
+			{Rel: "alternate", Type: "text/html", Href: feed.HomeURL},
+		},
+	}
+	for _, article := range feed.Articles {
+		entry := AtomEntry{
+			Title:     article.Title,
+			ID:        articleURL(article),
+			Link:      AtomLink{Rel: "alternate", Type: "text/html", Href: articleURL(article)},
+			Published: article.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   article.UpdatedAt.UTC().Format(time.RFC3339),
+			Author:    AtomAuthor{Name: article.Author.UserModel.Username, URI: authorURL(article)},
+			Summary:   articleSummary(article),
+			Content:   AtomContent{Type: "html", Body: common.RenderMarkdown(article.Body)},
+		}
+		for _, tag := range articleTags(article) {
+			entry.Categories = append(entry.Categories, AtomCategory{Term: tag})
+		}
+		response.Entries = append(response.Entries, entry)
+	}
+	return response
+}
+
+// RSS 2.0, https://www.rssboard.org/rss-specification
+type RSSFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	Channel   RSSChannel `xml:"channel"`
+}
+
+type RSSChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	AtomLink      AtomLink  `xml:"atom:link"`
+	Items         []RSSItem `xml:"item"`
+}
+
+type RSSItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        RSSGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Author      string   `xml:"author,omitempty"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"content:encoded"`
+	Categories  []string `xml:"category"`
+}
+
+type RSSGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (feed Feed) RSS() RSSFeed {
+	response := RSSFeed{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel: RSSChannel{
+			Title:         feed.Title,
+			Link:          feed.HomeURL,
+			Description:   feed.Title,
+			LastBuildDate: feed.Updated.UTC().Format(time.RFC1123Z),
+			AtomLink:      AtomLink{Rel: "self", Type: "application/rss+xml", Href: feed.FeedURL},
+		},
+	}
+	for _, article := range feed.Articles {
+		response.Channel.Items = append(response.Channel.Items, RSSItem{
+			Title:       article.Title,
+			Link:        articleURL(article),
+			GUID:        RSSGUID{IsPermaLink: true, Value: articleURL(article)},
+			PubDate:     article.CreatedAt.UTC().Format(time.RFC1123Z),
+			Description: articleSummary(article),
+			Content:     common.RenderMarkdown(article.Body),
+			Categories:  articleTags(article),
+		})
+	}
+	return response
+}
+
+// JSON Feed 1.1, https://www.jsonfeed.org/version/1.1/
+type JSONFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []JSONFeedItem `json:"items"`
+}
+
+type JSONFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	Summary       string           `json:"summary,omitempty"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []JSONFeedAuthor `json:"authors"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type JSONFeedAuthor struct {
+	Name   string  `json:"name"`
+	URL    string  `json:"url"`
+	Avatar *string `json:"avatar,omitempty"`
+}
+
+func (feed Feed) JSONFeed() JSONFeed {
+	response := JSONFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.HomeURL,
+		FeedURL:     feed.FeedURL,
+		Items:       []JSONFeedItem{},
+	}
+	for _, article := range feed.Articles {
+		response.Items = append(response.Items, JSONFeedItem{
+			ID:            articleURL(article),
+			URL:           articleURL(article),
+			Title:         article.Title,
+			ContentHTML:   common.RenderMarkdown(article.Body),
+			Summary:       articleSummary(article),
+			Image:         absoluteURL(article.CoverImage),
+			DatePublished: article.CreatedAt.UTC().Format(time.RFC3339),
+			DateModified:  article.UpdatedAt.UTC().Format(time.RFC3339),
+			Authors: []JSONFeedAuthor{{
+				Name:   article.Author.UserModel.Username,
+				URL:    authorURL(article),
+				Avatar: avatarURL(article),
+			}},
+			Tags: articleTags(article),
+		})
+	}
+	return response
+}
@@ -0,0 +1,195 @@
+package feeds
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gosimple/slug"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/users"
+)
+
+var test_db *gorm.DB
+
+func createMockArticle(title, body string, author users.UserModel, tags ...string) articles.ArticleModel {
+	article := articles.ArticleModel{
+		Title:  title,
+		Slug:   slug.Make(title),
+		Body:   body,
+		Author: articles.GetArticleUserModel(context.Background(), author),
+	}
+	articles.SaveOne(&article)
+	for _, tag := range tags {
+		tagModel := articles.TagModel{Tag: tag}
+		test_db.FirstOrCreate(&tagModel, tagModel)
+		test_db.Model(&article).Association("Tags").Append(tagModel)
+	}
+	return article
+}
+
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	FeedsRegister(r.Group("/feeds"))
+	return r
+}
+
+func get(r *gin.Engine, url string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSplitFormat(t *testing.T) {
+	asserts := assert.New(t)
+
+	var splitTests = []struct {
+		name   string
+		base   string
+		format string
+	}{
+		{"alice.rss", "alice", "rss"},
+		{"alice.json", "alice", "json"},
+		{"alice", "alice", "atom"},
+		{"node.js", "node.js", "atom"},
+		{"node.js.atom", "node.js", "atom"},
+		{"/feeds/articles.json", "/feeds/articles", "json"},
+	}
+	for _, testData := range splitTests {
+		base, format := splitFormat(testData.name)
+		asserts.Equal(testData.base, base, "name of "+testData.name)
+		asserts.Equal(testData.format, format, "format of "+testData.name)
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Setenv("SITE_URL", "https://conduit.test")
+	defer os.Unsetenv("SITE_URL")
+	os.Setenv("API_URL", "https://api.conduit.test")
+	defer os.Unsetenv("API_URL")
+
+	avatar := "/uploads/alice.png"
+	alice := users.UserModel{Username: "alice", Email: "alice@feeds.test", PasswordHash: "x", Image: &avatar}
+	bob := users.UserModel{Username: "bob", Email: "bob@feeds.test", PasswordHash: "x"}
+	test_db.Create(&alice)
+	test_db.Create(&bob)
+	createMockArticle("Older Golang Post", "first **post**", alice, "golang")
+	time.Sleep(10 * time.Millisecond)
+	createMockArticle("Newer Cooking Post", "second post", bob, "cooking")
+
+	r := newRouter()
+
+	w := get(r, "/feeds/articles.atom", nil)
+	asserts.Equal(http.StatusOK, w.Code, "atom feed should be served")
+	asserts.Equal("application/atom+xml; charset=utf-8", w.Header().Get("Content-Type"), "atom should have its media type")
+	var atom AtomFeed
+	asserts.NoError(xml.Unmarshal(w.Body.Bytes(), &atom), "atom should be valid xml")
+	asserts.Len(atom.Entries, 2, "atom should list every article")
+	asserts.Equal("Newer Cooking Post", atom.Entries[0].Title, "newest article should come first")
+	asserts.Equal("https://conduit.test/article/older-golang-post", atom.Entries[1].ID, "entries should link to the frontend")
+	asserts.Contains(atom.Entries[1].Content.Body, "<strong>post</strong>", "content should be rendered html")
+	asserts.Equal(atom.Entries[0].Updated, atom.Updated, "feed should be updated with its latest entry")
+
+	etag := w.Header().Get("ETag")
+	asserts.NotEmpty(etag, "feeds should have an etag")
+	asserts.Equal("public, max-age=300", w.Header().Get("Cache-Control"), "feeds should be cacheable")
+	w = get(r, "/feeds/articles.atom", map[string]string{"If-None-Match": etag})
+	asserts.Equal(http.StatusNotModified, w.Code, "unchanged feed should not be sent again")
+	w = get(r, "/feeds/articles.atom", map[string]string{"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)})
+	asserts.Equal(http.StatusNotModified, w.Code, "feed older than If-Modified-Since should not be sent")
+
+	w = get(r, "/feeds/articles.rss", nil)
+	asserts.Equal(http.StatusOK, w.Code, "rss feed should be served")
+	var rss RSSFeed
+	asserts.NoError(xml.Unmarshal(w.Body.Bytes(), &rss), "rss should be valid xml")
+	asserts.Equal("2.0", rss.Version, "rss version should be set")
+	asserts.Len(rss.Channel.Items, 2, "rss should list every article")
+	asserts.NotEqual(etag, w.Header().Get("ETag"), "formats should have different etags")
+
+	w = get(r, "/feeds/articles.json?limit=1", nil)
+	asserts.Equal(http.StatusOK, w.Code, "json feed should be served")
+	asserts.Equal("application/feed+json; charset=utf-8", w.Header().Get("Content-Type"), "json feed should have its media type")
+	var jsonFeed JSONFeed
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &jsonFeed), "json feed should be valid json")
+	asserts.Equal("https://jsonfeed.org/version/1.1", jsonFeed.Version, "json feed version should be 1.1")
+	asserts.Len(jsonFeed.Items, 1, "limit should be applied")
+	asserts.Equal("bob", jsonFeed.Items[0].Authors[0].Name, "items should have authors")
+	asserts.Equal([]string{"cooking"}, jsonFeed.Items[0].Tags, "items should have tags")
+
+	w = get(r, "/feeds/authors/alice.json", nil)
+	jsonFeed = JSONFeed{}
+	json.Unmarshal(w.Body.Bytes(), &jsonFeed)
+	asserts.Len(jsonFeed.Items, 1, "author feed should only list the author's articles")
+	asserts.Equal("Older Golang Post", jsonFeed.Items[0].Title, "author feed should list the author's articles")
+	asserts.Equal("https://conduit.test/@alice", jsonFeed.HomePageURL, "author feed should link to the profile")
+	if asserts.NotNil(jsonFeed.Items[0].Authors[0].Avatar, "authors should have their avatar") {
+		asserts.Equal("https://api.conduit.test/uploads/alice.png", *jsonFeed.Items[0].Authors[0].Avatar, "uploaded avatars should be absolute")
+	}
+
+	w = get(r, "/feeds/authors/nobody.rss", nil)
+	asserts.Equal(http.StatusNotFound, w.Code, "unknown author should return 404")
+
+	w = get(r, "/feeds/tags/cooking", nil)
+	atom = AtomFeed{}
+	asserts.NoError(xml.Unmarshal(w.Body.Bytes(), &atom), "tag feed should default to atom")
+	asserts.Len(atom.Entries, 1, "tag feed should only list tagged articles")
+	asserts.Equal("Newer Cooking Post", atom.Entries[0].Title, "tag feed should list tagged articles")
+
+	w = get(r, "/feeds/tags/Cooking.rss", nil)
+	asserts.Equal(http.StatusOK, w.Code, "tag feed should find the tag whatever its case")
+
+	w = get(r, "/feeds/tags/nothing.rss", nil)
+	asserts.Equal(http.StatusNotFound, w.Code, "unknown tag should return 404")
+}
+
+func TestFeedDeletion(t *testing.T) {
+	asserts := assert.New(t)
+
+	carol := users.UserModel{Username: "carol", Email: "carol@feeds.test", PasswordHash: "x"}
+	test_db.Create(&carol)
+	createMockArticle("Kept Post", "kept", carol)
+	deleted := createMockArticle("Deleted Post", "deleted", carol)
+	test_db.Exec("UPDATE article_models SET updated_at = ?", time.Now().Add(-time.Hour))
+
+	r := newRouter()
+	w := get(r, "/feeds/authors/carol.atom", nil)
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+
+	asserts.NoError(articles.DeleteArticleModel(&articles.ArticleModel{Slug: deleted.Slug}))
+	w = get(r, "/feeds/authors/carol.atom", map[string]string{"If-Modified-Since": lastModified})
+	asserts.Equal(http.StatusOK, w.Code, "a deletion should modify the feed")
+	asserts.NotEqual(lastModified, w.Header().Get("Last-Modified"), "a deletion should move Last-Modified")
+	w = get(r, "/feeds/authors/carol.atom", map[string]string{"If-None-Match": etag})
+	asserts.Equal(http.StatusOK, w.Code, "a deletion should change the etag")
+	var atom AtomFeed
+	xml.Unmarshal(w.Body.Bytes(), &atom)
+	asserts.Len(atom.Entries, 1, "deleted articles should leave the feed")
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	users.AutoMigrate()
+	events.AutoMigrate()
+	articles.AutoMigrateArticles(test_db)
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
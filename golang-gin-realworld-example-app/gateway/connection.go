@@ -0,0 +1,182 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"realworld-backend/realtime"
+)
+
+const (
+	// Time allowed to write a message to the client.
+	writeWait = 10 * time.Second
+	// Time allowed between two pongs, the connection is dropped past it.
+	pongWait = 60 * time.Second
+	// Pings go out more often than pongWait so a live client always answers in time.
+	pingPeriod = pongWait * 9 / 10
+	// Clients have nothing to say besides control frames, anything bigger is refused.
+	maxMessageSize = 512
+)
+
+// What a client receives, `id` is the lastEventId to reconnect with.
+type MessageResponse struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type connection struct {
+	userID uint
+	ws     *websocket.Conn
+	// Closed when the connection has to end, closeCode tells the client why, 0 when the client
+	// went away first.
+	done      chan struct{}
+	stopOnce  sync.Once
+	closeCode int
+	closeText string
+}
+
+func (conn *connection) stop(code int, text string) {
+	conn.stopOnce.Do(func() {
+		conn.closeCode = code
+		conn.closeText = text
+		close(conn.done)
+	})
+}
+
+// Discards what the client sends, reading is still needed to process pings, pongs and close frames.
+func (conn *connection) readPump() {
+	defer conn.stop(0, "")
+	conn.ws.SetReadLimit(maxMessageSize)
+	conn.ws.SetReadDeadline(time.Now().Add(pongWait))
+	conn.ws.SetPongHandler(func(string) error {
+		conn.ws.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		if _, _, err := conn.ws.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// The only goroutine writing to the connection. A client too slow to keep up is dropped by the hub,
+// it is then told to try again later and catches up from the history with its lastEventId.
+func (conn *connection) writePump(subscription *realtime.Subscription, backlog []realtime.Message) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for _, message := range backlog {
+		if err := conn.write(message); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case message, ok := <-subscription.C:
+			if !ok {
+				conn.stop(websocket.CloseTryAgainLater, "too slow")
+				conn.close()
+				return
+			}
+			if err := conn.write(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-conn.done:
+			conn.close()
+			return
+		}
+	}
+}
+
+func (conn *connection) write(message realtime.Message) error {
+	conn.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	err := conn.ws.WriteJSON(MessageResponse{ID: message.ID, Type: message.Event, Data: message.Data})
+	if err != nil {
+		slog.Error("gateway err: (write)", "err", err)
+	}
+	return err
+}
+
+// Send the close frame when the server ends the connection.
+func (conn *connection) close() {
+	if conn.closeCode == 0 {
+		return
+	}
+	message := websocket.FormatCloseMessage(conn.closeCode, conn.closeText)
+	conn.ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+}
+
+// Open connections by user, to cap them and to close them all on shutdown.
+var registry = struct {
+	sync.Mutex
+	connections  map[uint]map[*connection]bool
+	wait         sync.WaitGroup
+	shuttingDown bool
+}{connections: map[uint]map[*connection]bool{}}
+
+func register(userID uint) (*connection, error) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.shuttingDown {
+		return nil, errShuttingDown
+	}
+	if len(registry.connections[userID]) >= maxConnections {
+		return nil, errors.New("Too many open connections")
+	}
+	conn := &connection{userID: userID, done: make(chan struct{})}
+	if registry.connections[userID] == nil {
+		registry.connections[userID] = map[*connection]bool{}
+	}
+	registry.connections[userID][conn] = true
+	registry.wait.Add(1)
+	return conn, nil
+}
+
+func unregister(conn *connection) {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.connections[conn.userID], conn)
+	if len(registry.connections[conn.userID]) == 0 {
+		delete(registry.connections, conn.userID)
+	}
+	registry.wait.Done()
+}
+
+// Close every connection with "going away" so clients reconnect to another instance, and refuse
+// new ones. Returns once they are all closed or ctx is done.
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	gateway.Shutdown(ctx)
+func Shutdown(ctx context.Context) error {
+	registry.Lock()
+	registry.shuttingDown = true
+	for _, connections := range registry.connections {
+		for conn := range connections {
+			conn.stop(websocket.CloseGoingAway, "server shutting down")
+		}
+	}
+	registry.Unlock()
+
+	closed := make(chan struct{})
+	go func() {
+		registry.wait.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
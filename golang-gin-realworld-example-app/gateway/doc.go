@@ -0,0 +1,9 @@
+/*
+The gateway module holding the authenticated WebSocket connections of users, over which the server
+pushes their notifications, feed updates and mentions as they happen.
+
+routers.go: router binding, authentication and the upgrade of the connection
+
+connection.go: the pumps of a connection, backpressure and the shutdown of every connection
+*/
+package gateway
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"realworld-backend/common"
+	"realworld-backend/realtime"
+	"realworld-backend/users"
+)
+
+// Goes after AuthMiddleware(true), browsers can't set headers on a WebSocket so the token is
+// usually passed as `?access_token=`.
+//
+//	gateway.GatewayRegister(v1.Group("/ws"))
+func GatewayRegister(router *gin.RouterGroup) {
+	router.GET("", Connect)
+}
+
+// Connections a single user can keep open at once, one per tab or device.
+var maxConnections = common.GetenvInt("WS_MAX_CONNECTIONS", 5)
+
+// Pages allowed to open a connection besides the api's own host, comma separated.
+var allowedOrigins = strings.Split(common.Getenv("WS_ALLOWED_ORIGINS", "http://localhost:4100"), ",")
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkOrigin,
+}
+
+// The token travels with the request, so unlike plain requests a WebSocket must not be opened by
+// any page a logged in user visits. Clients that aren't browsers send no Origin.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(strings.TrimSpace(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Upgrade to a WebSocket streaming the messages of the current user, `?lastEventId=` replays what
+// was missed since that message after a reconnection.
+func Connect(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	conn, err := register(myUserModel.ID)
+	if err != nil {
+		status := http.StatusTooManyRequests
+		if err == errShuttingDown {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, common.NewError("ws", err))
+		return
+	}
+	defer unregister(conn)
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade already answered with the reason.
+		return
+	}
+	conn.ws = ws
+	defer ws.Close()
+
+	subscription, backlog := realtime.Subscribe(realtime.UserTopic(myUserModel.ID), c.Query("lastEventId"))
+	defer subscription.Close()
+
+	go conn.readPump()
+	conn.writePump(subscription, backlog)
+}
+
+var errShuttingDown = errors.New("Server is shutting down")
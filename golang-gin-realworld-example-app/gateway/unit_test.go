@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+	"realworld-backend/realtime"
+	"realworld-backend/users"
+)
+
+var test_db *gorm.DB
+
+func newUser(name string) users.UserModel {
+	userModel := users.UserModel{Username: name, Email: name + "@gateway.test", PasswordHash: "x"}
+	test_db.Create(&userModel)
+	return userModel
+}
+
+func newServer() *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	v1 := r.Group("/api")
+	v1.Use(users.AuthMiddleware(true))
+	GatewayRegister(v1.Group("/ws"))
+	return httptest.NewServer(r)
+}
+
+// Open the WebSocket of `user`, `query` is appended to the url.
+func dial(server *httptest.Server, user users.UserModel, query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ws?access_token=" + common.GenToken(user.ID) + query
+	return websocket.DefaultDialer.Dial(url, header)
+}
+
+func receive(asserts *assert.Assertions, ws *websocket.Conn) MessageResponse {
+	var message struct {
+		ID   string          `json:"id"`
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	asserts.NoError(ws.ReadJSON(&message), "message should be received")
+	return MessageResponse{ID: message.ID, Type: message.Type, Data: string(message.Data)}
+}
+
+func TestConnect(t *testing.T) {
+	asserts := assert.New(t)
+	server := newServer()
+	defer server.Close()
+
+	alice := newUser("wsalice")
+	bob := newUser("wsbob")
+
+	resp, err := http.Get(server.URL + "/api/ws")
+	asserts.NoError(err)
+	asserts.Equal(http.StatusUnauthorized, resp.StatusCode, "connecting should need a token")
+
+	ws, _, err := dial(server, alice, "", nil)
+	asserts.NoError(err, "alice should connect")
+	defer ws.Close()
+
+	realtime.Publish(realtime.UserTopic(bob.ID), "notification", map[string]int{"unreadCount": 9})
+	realtime.Publish(realtime.UserTopic(alice.ID), "notification", map[string]int{"unreadCount": 1})
+	first := receive(asserts, ws)
+	asserts.Equal("notification", first.Type)
+	asserts.Equal(`{"unreadCount":1}`, first.Data, "alice should only get her messages")
+
+	realtime.Publish(realtime.UserTopic(alice.ID), "feed.update", map[string]string{"slug": "missed"})
+	asserts.Equal("feed.update", receive(asserts, ws).Type)
+
+	// Reconnecting with the id of the last message received replays the ones after it.
+	again, _, err := dial(server, alice, "&lastEventId="+first.ID, nil)
+	asserts.NoError(err, "alice should reconnect")
+	defer again.Close()
+	replayed := receive(asserts, again)
+	asserts.Equal("feed.update", replayed.Type)
+	asserts.Equal(`{"slug":"missed"}`, replayed.Data, "missed messages should be replayed")
+
+	_, resp, err = dial(server, alice, "", http.Header{"Origin": {"http://evil.example"}})
+	asserts.Error(err)
+	asserts.Equal(http.StatusForbidden, resp.StatusCode, "other sites should not open connections")
+
+	allowed, _, err := dial(server, bob, "", http.Header{"Origin": {"http://localhost:4100"}})
+	asserts.NoError(err, "the front end should be allowed")
+	allowed.Close()
+}
+
+func TestMaxConnections(t *testing.T) {
+	asserts := assert.New(t)
+	server := newServer()
+	defer server.Close()
+
+	carol := newUser("wscarol")
+	saved := maxConnections
+	maxConnections = 1
+	defer func() { maxConnections = saved }()
+
+	ws, _, err := dial(server, carol, "", nil)
+	asserts.NoError(err)
+	_, resp, err := dial(server, carol, "", nil)
+	asserts.Error(err)
+	asserts.Equal(http.StatusTooManyRequests, resp.StatusCode, "connections per user should be capped")
+
+	// The slot is given back once the connection is closed.
+	ws.Close()
+	asserts.Eventually(func() bool {
+		ws, _, err = dial(server, carol, "", nil)
+		return err == nil
+	}, 5*time.Second, 20*time.Millisecond)
+	ws.Close()
+}
+
+func TestSlowClient(t *testing.T) {
+	asserts := assert.New(t)
+	server := newServer()
+	defer server.Close()
+
+	dave := newUser("wsdave")
+	ws, _, err := dial(server, dave, "", nil)
+	asserts.NoError(err)
+	defer ws.Close()
+
+	// The client doesn't read, the hub drops it once its buffer is full.
+	payload := strings.Repeat("x", 64*1024)
+	for i := 0; i < 200; i++ {
+		realtime.Publish(realtime.UserTopic(dave.ID), "notification", payload)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for err == nil {
+		_, _, err = ws.ReadMessage()
+	}
+	asserts.True(websocket.IsCloseError(err, websocket.CloseTryAgainLater), "a slow client should be told to try again later")
+}
+
+func TestShutdown(t *testing.T) {
+	asserts := assert.New(t)
+	server := newServer()
+	defer server.Close()
+	defer func() { registry.shuttingDown = false }()
+
+	erin := newUser("wserin")
+	ws, _, err := dial(server, erin, "", nil)
+	asserts.NoError(err)
+	defer ws.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	asserts.NoError(Shutdown(ctx), "connections should close before the deadline")
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = ws.ReadMessage()
+	asserts.True(websocket.IsCloseError(err, websocket.CloseGoingAway), "clients should be told the server is going away")
+
+	_, resp, err := dial(server, erin, "", nil)
+	asserts.Error(err)
+	asserts.Equal(http.StatusServiceUnavailable, resp.StatusCode, "new connections should be refused")
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	users.AutoMigrate()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
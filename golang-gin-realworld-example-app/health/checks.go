@@ -0,0 +1,143 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+)
+
+// A readiness check, it returns an error explaining what is wrong.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	Name  string
+	Check Check
+}
+
+var registry struct {
+	sync.Mutex
+	checks []namedCheck
+}
+
+// Add a check to /readyz, a check registered again under the same name replaces the first one.
+//
+//	health.Register("database", health.Database(db))
+func Register(name string, check Check) {
+	registry.Lock()
+	defer registry.Unlock()
+	for i := range registry.checks {
+		if registry.checks[i].Name == name {
+			registry.checks[i].Check = check
+			return
+		}
+	}
+	registry.checks = append(registry.checks, namedCheck{Name: name, Check: check})
+}
+
+func registered() []namedCheck {
+	registry.Lock()
+	defer registry.Unlock()
+	return append([]namedCheck{}, registry.checks...)
+}
+
+type result struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Run every check but the excluded ones at once, each within HEALTH_CHECK_TIMEOUT. Results keep
+// the order of Register.
+func run(ctx context.Context, excluded map[string]bool) []result {
+	checks := []namedCheck{}
+	for _, check := range registered() {
+		if !excluded[check.Name] {
+			checks = append(checks, check)
+		}
+	}
+	results := make([]result, len(checks))
+	timeout := common.GetenvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second)
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check namedCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := check.Check(checkCtx)
+			if err == nil && checkCtx.Err() != nil {
+				err = checkCtx.Err()
+			}
+			results[i] = result{Name: check.Name, Err: err, Duration: time.Since(start)}
+		}(i, check)
+	}
+	wg.Wait()
+	return results
+}
+
+// The database answers a ping.
+func Database(db *gorm.DB) Check {
+	return func(ctx context.Context) error {
+		return db.DB().PingContext(ctx)
+	}
+}
+
+// The tables and columns of `models` exist, that is the migrations of this version were applied.
+// A schema behind the code makes queries fail, as when an instance runs against a database that
+// wasn't migrated yet.
+//
+//	health.Register("migrations", health.Schema(db, &users.UserModel{}, &articles.ArticleModel{}))
+func Schema(db *gorm.DB, models ...interface{}) Check {
+	return func(ctx context.Context) error {
+		var missing []string
+		for _, model := range models {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			scope := db.NewScope(model)
+			tableName := scope.TableName()
+			if !scope.Dialect().HasTable(tableName) {
+				missing = append(missing, "table "+tableName)
+				continue
+			}
+			for _, field := range scope.GetModelStruct().StructFields {
+				if field.IsNormal && !field.IsIgnored && !scope.Dialect().HasColumn(tableName, field.DBName) {
+					missing = append(missing, "column "+tableName+"."+field.DBName)
+				}
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+}
+
+var errWorkerStopped = errors.New("stopped")
+
+// Wrap the background worker `run` so that /readyz fails once it returns, the workers started
+// from main are expected to run until shutdown.
+//
+//	go health.Watch("events.dispatcher", events.RunDispatcher)(ctx)
+func Watch(name string, run func(ctx context.Context)) func(ctx context.Context) {
+	var running atomic.Bool
+	Register("workers/"+name, func(ctx context.Context) error {
+		if !running.Load() {
+			return errWorkerStopped
+		}
+		return nil
+	})
+	return func(ctx context.Context) {
+		running.Store(true)
+		defer running.Store(false)
+		run(ctx)
+	}
+}
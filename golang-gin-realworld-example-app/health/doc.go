@@ -0,0 +1,12 @@
+/*
+The health module containing the probes of the api: liveness, readiness and build info.
+
+checks.go: the readiness checks, database, schema and background workers
+
+version.go: commit, build time and Go version of the binary
+
+routers.go: router binding of /healthz, /readyz and /version
+
+serializers.go: definition the schema of return data
+*/
+package health
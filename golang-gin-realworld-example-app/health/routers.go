@@ -0,0 +1,48 @@
+package health
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Probes live at the root, out of /api, as Kubernetes and load balancers expect:
+//
+//	livenessProbe:  {httpGet: {path: /healthz, port: 8080}}
+//	readinessProbe: {httpGet: {path: /readyz, port: 8080}}
+func HealthRegister(router *gin.RouterGroup) {
+	router.GET("/healthz", Liveness)
+	router.GET("/readyz", Readiness)
+	router.GET("/version", Version)
+}
+
+// The process serves requests. It doesn't depend on the database, restarting the api wouldn't
+// bring a database back.
+func Liveness(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, StatusResponse{Status: statusOK})
+}
+
+// The api can take traffic: 200 when every check passes, 503 with the failing checks otherwise.
+// `?exclude=workers/jobs` leaves checks out, several can be given comma separated.
+func Readiness(c *gin.Context) {
+	excluded := map[string]bool{}
+	if exclude := c.Query("exclude"); exclude != "" {
+		for _, name := range strings.Split(exclude, ",") {
+			excluded[strings.TrimSpace(name)] = true
+		}
+	}
+	results := run(c.Request.Context(), excluded)
+	response, ready := readinessResponse(results)
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, response)
+}
+
+func Version(c *gin.Context) {
+	c.JSON(http.StatusOK, versionResponse(readBuildInfo()))
+}
@@ -0,0 +1,58 @@
+package health
+
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+type CheckResponse struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+type ReadinessResponse struct {
+	Status string          `json:"status"`
+	Checks []CheckResponse `json:"checks"`
+}
+
+type VersionResponse struct {
+	Commit     string `json:"commit"`
+	CommitTime string `json:"commitTime,omitempty"`
+	Modified   bool   `json:"modified"`
+	BuildTime  string `json:"buildTime,omitempty"`
+	GoVersion  string `json:"goVersion"`
+}
+
+const (
+	statusOK     = "ok"
+	statusFailed = "failed"
+)
+
+func readinessResponse(results []result) (ReadinessResponse, bool) {
+	response := ReadinessResponse{Status: statusOK, Checks: []CheckResponse{}}
+	ready := true
+	for _, result := range results {
+		check := CheckResponse{Name: result.Name, Status: statusOK, DurationMs: float64(result.Duration.Microseconds()) / 1000}
+		if result.Err != nil {
+			check.Status = statusFailed
+			check.Error = result.Err.Error()
+			ready = false
+		}
+		response.Checks = append(response.Checks, check)
+	}
+	if !ready {
+		response.Status = statusFailed
+	}
+	return response, ready
+}
+
+func versionResponse(info buildInfo) VersionResponse {
+	return VersionResponse{
+		Commit:     info.Commit,
+		CommitTime: info.CommitTime,
+		Modified:   info.Modified,
+		BuildTime:  info.BuildTime,
+		GoVersion:  info.GoVersion,
+	}
+}
@@ -0,0 +1,159 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+)
+
+var test_db *gorm.DB
+
+type probeModel struct {
+	gorm.Model
+	Name    string
+	Skipped string `gorm:"-"`
+}
+
+type missingModel struct {
+	gorm.Model
+}
+
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	HealthRegister(r.Group(""))
+	return r
+}
+
+func request(r *gin.Engine, url string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLiveness(t *testing.T) {
+	asserts := assert.New(t)
+
+	w := request(newRouter(), "/healthz")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"status":"ok"}`, w.Body.String())
+	asserts.Equal("no-store", w.Header().Get("Cache-Control"), "probes should not be cached")
+}
+
+func TestReadiness(t *testing.T) {
+	asserts := assert.New(t)
+	registry.checks = nil
+	defer func() { registry.checks = nil }()
+
+	r := newRouter()
+	Register("database", Database(test_db))
+	Register("migrations", Schema(test_db, &probeModel{}))
+	w := request(r, "/readyz")
+	asserts.Equal(http.StatusOK, w.Code, "passing checks should be ready")
+	var response ReadinessResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	asserts.Equal("ok", response.Status)
+	asserts.Len(response.Checks, 2)
+	asserts.Equal("database", response.Checks[0].Name, "checks should keep their order")
+	asserts.Equal("ok", response.Checks[1].Status)
+
+	Register("cache", func(ctx context.Context) error { return errors.New("connection refused") })
+	Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	t.Setenv("HEALTH_CHECK_TIMEOUT", "10ms")
+	w = request(r, "/readyz")
+	asserts.Equal(http.StatusServiceUnavailable, w.Code, "a failing check should make the api unready")
+	response = ReadinessResponse{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	asserts.Equal("failed", response.Status)
+	asserts.Len(response.Checks, 4)
+	asserts.Equal(CheckResponse{Name: "cache", Status: "failed", Error: "connection refused", DurationMs: response.Checks[2].DurationMs}, response.Checks[2])
+	asserts.Equal("context deadline exceeded", response.Checks[3].Error, "checks should time out")
+
+	w = request(r, "/readyz?exclude=cache,%20slow")
+	asserts.Equal(http.StatusOK, w.Code, "excluded checks should not count")
+
+	Register("cache", func(ctx context.Context) error { return nil })
+	w = request(r, "/readyz?exclude=slow")
+	asserts.Equal(http.StatusOK, w.Code, "registering again should replace the check")
+}
+
+func TestSchema(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.NoError(Schema(test_db, &probeModel{})(context.Background()), "migrated models should pass")
+	err := Schema(test_db, &probeModel{}, &missingModel{})(context.Background())
+	asserts.EqualError(err, "missing table missing_models")
+
+	test_db.Exec("CREATE TABLE missing_models (id integer primary key)")
+	defer test_db.DropTable(&missingModel{})
+	err = Schema(test_db, &missingModel{})(context.Background())
+	asserts.EqualError(err, "missing column missing_models.created_at, column missing_models.updated_at, column missing_models.deleted_at")
+}
+
+func TestWatch(t *testing.T) {
+	asserts := assert.New(t)
+	registry.checks = nil
+	defer func() { registry.checks = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	done := make(chan struct{})
+	worker := Watch("test", func(ctx context.Context) {
+		close(running)
+		<-ctx.Done()
+	})
+	check := registered()[0]
+	asserts.Equal("workers/test", check.Name)
+	asserts.ErrorIs(check.Check(ctx), errWorkerStopped, "a worker isn't alive before it runs")
+
+	go func() {
+		worker(ctx)
+		close(done)
+	}()
+	<-running
+	asserts.NoError(check.Check(ctx), "a running worker should be alive")
+	cancel()
+	<-done
+	asserts.ErrorIs(check.Check(context.Background()), errWorkerStopped, "a returned worker should fail readiness")
+}
+
+func TestVersion(t *testing.T) {
+	asserts := assert.New(t)
+
+	commit, buildTime = "abc123", "2024-05-01T10:00:00Z"
+	defer func() { commit, buildTime = "", "" }()
+	w := request(newRouter(), "/version")
+	asserts.Equal(http.StatusOK, w.Code)
+	var response VersionResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	asserts.Equal("abc123", response.Commit, "the commit set at build time should be served")
+	asserts.Equal("2024-05-01T10:00:00Z", response.BuildTime)
+	asserts.Equal(runtime.Version(), response.GoVersion)
+
+	commit, buildTime = "", ""
+	info := readBuildInfo()
+	asserts.NotEmpty(info.Commit, "the commit should fall back to the embedded information")
+	asserts.NotEmpty(info.BuildTime, "the build time should fall back to the date of the binary")
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	test_db.AutoMigrate(&probeModel{})
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
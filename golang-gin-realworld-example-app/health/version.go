@@ -0,0 +1,54 @@
+package health
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Set when building a release, otherwise the commit is read from the version control information
+// Go embeds in binaries built from a checkout, and the build time is the date of the binary.
+//
+//	go build -ldflags "-X realworld-backend/health.commit=$(git rev-parse HEAD) -X realworld-backend/health.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	commit    string
+	buildTime string
+)
+
+type buildInfo struct {
+	Commit     string
+	CommitTime string
+	Modified   bool
+	BuildTime  string
+	GoVersion  string
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	if embedded, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range embedded.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				info.CommitTime = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if info.BuildTime == "" {
+		if executable, err := os.Executable(); err == nil {
+			if stat, err := os.Stat(executable); err == nil {
+				info.BuildTime = stat.ModTime().UTC().Format(time.RFC3339)
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+}
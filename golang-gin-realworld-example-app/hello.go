@@ -1,34 +1,154 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
 	"realworld-backend/articles"
 	"realworld-backend/common"
+	"realworld-backend/digest"
+	"realworld-backend/events"
+	"realworld-backend/feeds"
+	"realworld-backend/gateway"
+	"realworld-backend/health"
+	"realworld-backend/jobs"
+	"realworld-backend/mail"
+	"realworld-backend/metrics"
+	"realworld-backend/notifications"
+	"realworld-backend/ratelimit"
+	"realworld-backend/realtime"
+	"realworld-backend/seo"
+	"realworld-backend/tracing"
+	"realworld-backend/uploads"
 	"realworld-backend/users"
+	"realworld-backend/webhooks"
 
 	"github.com/jinzhu/gorm"
 )
 
 func Migrate(db *gorm.DB) {
 	users.AutoMigrate()
+	events.AutoMigrate()
 	db.AutoMigrate(&articles.ArticleModel{})
 	db.AutoMigrate(&articles.TagModel{})
+	db.AutoMigrate(&articles.TagFollowModel{})
 	db.AutoMigrate(&articles.FavoriteModel{})
 	db.AutoMigrate(&articles.ArticleUserModel{})
 	db.AutoMigrate(&articles.CommentModel{})
+	db.AutoMigrate(&articles.MentionModel{})
+	if err := articles.MigrateBodies(db); err != nil {
+		log.Fatalln("articles err: (MigrateBodies) ", err)
+	}
+	uploads.AutoMigrate()
+	notifications.AutoMigrate()
+	webhooks.AutoMigrate()
+	jobs.AutoMigrate()
+	ratelimit.AutoMigrate()
+	if err := articles.NormalizeTags(); err != nil {
+		log.Fatalln("articles err: (NormalizeTags) ", err)
+	}
 }
 
 func main() {
 
+	common.InitLogger()
 	db := common.Init()
 	Migrate(db)
 	defer db.Close()
 
-	r := gin.Default()
+	// `go run . jobs list` inspects the job queue instead of serving the api.
+	if len(os.Args) > 1 && os.Args[1] == "jobs" {
+		if err := jobs.Command(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalln("jobs err: ", err)
+		}
+		return
+	}
+
+	exporter, err := tracing.NewExporter(context.Background())
+	if err != nil {
+		log.Fatalln("tracing err: (NewExporter) ", err)
+	}
+	tracing.Init(exporter)
+	tracing.InstrumentDB(db)
+	metrics.InstrumentDB(db)
+	health.Register("database", health.Database(db))
+	health.Register("migrations", health.Schema(db,
+		&users.UserModel{}, &users.FollowModel{}, &users.BlockModel{}, &users.MuteModel{},
+		&events.OutboxModel{}, &events.DeadLetterModel{},
+		&articles.ArticleModel{}, &articles.TagModel{}, &articles.TagFollowModel{}, &articles.FavoriteModel{},
+		&articles.ArticleUserModel{}, &articles.CommentModel{}, &articles.MentionModel{},
+		&uploads.UploadModel{}, &uploads.ImageVariantModel{},
+		&notifications.NotificationModel{}, &notifications.NotificationActorModel{}, &notifications.NotificationPreferenceModel{},
+		&webhooks.WebhookModel{}, &webhooks.DeliveryModel{},
+		&jobs.JobModel{},
+		&ratelimit.BucketModel{},
+	))
+
+	storage, err := uploads.Init()
+	if err != nil {
+		log.Fatalln("uploads err: (Init) ", err)
+	}
+	// Background workers stop once the server has drained, not as soon as the signal arrives.
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	runWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
+	runWorker(health.Watch("uploads.variants", uploads.RunVariantWorker))
+	articles.SubscribeEvents()
+	notifications.SubscribeEvents()
+	webhooks.SubscribeEvents()
+	runWorker(health.Watch("events.dispatcher", events.RunDispatcher))
+	runWorker(health.Watch("webhooks.deliveries", webhooks.RunDeliveryWorker))
+	mail.Init()
+	digest.RegisterJobs()
+	jobWorker := jobs.NewWorker(jobs.Concurrency())
+	jobWorker.Start()
+	health.Register("workers/jobs", jobWorker.Check)
+	if err := digest.Schedule(); err != nil {
+		log.Fatalln("digest err: (Schedule) ", err)
+	}
+	runWorker(func(ctx context.Context) {
+		uploads.BackfillVariants(ctx, "avatar", users.ImageURLs())
+		uploads.BackfillVariants(ctx, "cover", articles.CoverImageURLs())
+	})
+
+	if err := ratelimit.Init(); err != nil {
+		log.Fatalln("ratelimit err: (Init) ", err)
+	}
+
+	r := gin.New()
+	// Client ips, which anonymous rate limits count against, are only read from X-Forwarded-For
+	// when sent by one of the TRUSTED_PROXIES, otherwise anybody could pick theirs.
+	var proxies []string
+	if value := common.Getenv("TRUSTED_PROXIES", ""); value != "" {
+		proxies = strings.Split(value, ",")
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Fatalln("server err: (SetTrustedProxies) ", err)
+	}
+	r.Use(tracing.Middleware())
+	r.Use(common.RequestID())
+	// Probes and scrapes come every few seconds, they would drown the log.
+	r.Use(common.AccessLog("/healthz", "/readyz", "/metrics"), common.Recovery())
+	r.Use(metrics.Middleware())
 
 	// Apply security headers middleware FIRST
 	r.Use(common.SecurityHeaders())
@@ -41,17 +161,36 @@ func main() {
 		AllowCredentials: true,
 	}))
 
-	v1 := r.Group("/api")
-	users.UsersRegister(v1.Group("/users"))
+	// Uploads check their own, larger, UPLOAD_MAX_BYTES so they stay out of the body limit of the api.
+	uploads.UploadsRegister(r.Group("/api/uploads", users.AuthMiddleware(true), ratelimit.Limit("uploads")))
+
+	v1 := r.Group("/api", common.LimitBody(common.MaxBodyBytes()))
+	users.UsersRegister(v1.Group("/users", ratelimit.Limit("auth")))
 	v1.Use(users.AuthMiddleware(false))
 	articles.ArticlesAnonymousRegister(v1.Group("/articles"))
 	articles.TagsAnonymousRegister(v1.Group("/tags"))
+	digest.DigestAnonymousRegister(v1.Group("/digest"))
 
 	v1.Use(users.AuthMiddleware(true))
+	v1.Use(ratelimit.LimitWrites("write"))
 	users.UserRegister(v1.Group("/user"))
 	users.ProfileRegister(v1.Group("/profiles"))
 
 	articles.ArticlesRegister(v1.Group("/articles"))
+	articles.TagsRegister(v1.Group("/tags"))
+	notifications.NotificationsRegister(v1.Group("/notifications"))
+	webhooks.WebhooksRegister(v1.Group("/webhooks"))
+	gateway.GatewayRegister(v1.Group("/ws"))
+	digest.DigestRegister(v1.Group("/digest"))
+
+	health.HealthRegister(r.Group(""))
+	metrics.MetricsRegister(r.Group(""))
+	feeds.FeedsRegister(r.Group("/feeds"))
+	seo.SitemapRegister(r.Group(""))
+
+	if localStorage, ok := storage.(*uploads.LocalStorage); ok {
+		uploads.FilesRegister(r.Group(localStorage.BaseURL))
+	}
 
 	testAuth := r.Group("/api/ping")
 
@@ -82,5 +221,55 @@ func main() {
 	//}).First(&userAA)
 	//fmt.Println(userAA)
 
-	r.Run() // listen and serve on 0.0.0.0:8080
+	server := common.NewServer(r)
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe() // listen and serve on 0.0.0.0:8080
+	}()
+	select {
+	case err := <-served:
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server err: (ListenAndServe)", "err", err)
+		}
+	case <-signals.Done():
+		slog.Info("shutting down")
+	}
+	stopSignals()
+	shutdown(server, stopWorkers, &workers, jobWorker)
+}
+
+// Let in-flight requests finish and background work stop within SHUTDOWN_TIMEOUT, so that the
+// database is only closed once nothing uses it anymore. A second signal kills the process.
+func shutdown(server *http.Server, stopWorkers context.CancelFunc, workers *sync.WaitGroup, jobWorker *jobs.Worker) {
+	ctx, cancel := context.WithTimeout(context.Background(), common.GetenvDuration("SHUTDOWN_TIMEOUT", 20*time.Second))
+	defer cancel()
+
+	// Long lived connections are not waited for by server.Shutdown, end them first.
+	if err := gateway.Shutdown(ctx); err != nil {
+		slog.Error("gateway err: (Shutdown)", "err", err)
+	}
+	realtime.Close()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("server err: (Shutdown)", "err", err)
+	}
+
+	stopWorkers()
+	if err := jobWorker.Drain(ctx); err != nil {
+		slog.Error("jobs err: (Drain)", "err", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Error("shutdown err: background workers still running", "err", ctx.Err())
+	}
+	if err := tracing.Shutdown(ctx); err != nil {
+		slog.Error("tracing err: (Shutdown)", "err", err)
+	}
 }
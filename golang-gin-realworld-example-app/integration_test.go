@@ -2,14 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"realworld-backend/articles"
 	"realworld-backend/common"
+	"realworld-backend/events"
 	"realworld-backend/users"
+	"realworld-backend/webhooks"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
@@ -25,11 +29,16 @@ func setupTestRouter() *gin.Engine {
 
 	// Run migrations
 	users.AutoMigrate()
+	events.AutoMigrate()
 	test_db.AutoMigrate(&articles.ArticleModel{})
 	test_db.AutoMigrate(&articles.TagModel{})
+	test_db.AutoMigrate(&articles.TagFollowModel{})
 	test_db.AutoMigrate(&articles.FavoriteModel{})
 	test_db.AutoMigrate(&articles.ArticleUserModel{})
 	test_db.AutoMigrate(&articles.CommentModel{})
+	test_db.AutoMigrate(&articles.MentionModel{})
+	webhooks.AutoMigrate()
+	subscribeOnce.Do(webhooks.SubscribeEvents)
 
 	r := gin.Default()
 	v1 := r.Group("/api")
@@ -51,10 +60,14 @@ func setupTestRouter() *gin.Engine {
 	users.UserRegister(v1.Group("/user"))
 	users.ProfileRegister(v1.Group("/profiles"))
 	articles.ArticlesRegister(v1.Group("/articles"))
+	webhooks.WebhooksRegister(v1.Group("/webhooks"))
 
 	return r
 }
 
+// Subscribers are process wide, the routers of every test share them.
+var subscribeOnce sync.Once
+
 // Task 2.1 - Test 1: User Registration
 func TestUserRegistrationIntegration(t *testing.T) {
 	router := setupTestRouter()
@@ -725,3 +738,37 @@ func TestDeleteCommentIntegration(t *testing.T) {
 
 	asserts.Equal(http.StatusOK, w.Code, "comment deletion should return 200")
 }
+
+func TestWebhookDeliveryIntegration(t *testing.T) {
+	router := setupTestRouter()
+	defer common.TestDBFree(test_db)
+
+	asserts := assert.New(t)
+
+	receiver := webhooks.NewTestReceiver("integration-test-secret")
+	defer receiver.Close()
+	webhooks.SetClient(receiver.Client())
+	defer webhooks.SetClient(nil)
+	token := createUserAndGetToken(router, "hookowner", "hookowner@example.com", "password123")
+
+	webhookBody := `{"webhook": {"url": "` + receiver.URL + `", "events": ["article.created"], "secret": "integration-test-secret"}}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/webhooks/", bytes.NewBufferString(webhookBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+token)
+	router.ServeHTTP(w, req)
+	asserts.Equal(http.StatusCreated, w.Code, "webhook creation should return 201")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(`{"article": {"title": "Hooked Article", "description": "d", "body": "b"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+token)
+	router.ServeHTTP(w, req)
+	asserts.Equal(http.StatusCreated, w.Code, "article creation should return 201")
+
+	asserts.Equal(1, webhooks.DeliverDue(context.Background()), "the event should be delivered")
+	received := receiver.Received()
+	asserts.Len(received, 1, "receiver should get the signed delivery")
+	asserts.Equal("article.created", received[0].Event, "event name should be sent")
+	asserts.Equal("hooked-article", received[0].Payload["data"].(map[string]interface{})["slug"], "payload should describe the article")
+}
@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: jobs <command> [flags]
+
+commands:
+  stats                                   count jobs by queue and status
+  list [-status s] [-queue q] [-limit n]  latest jobs
+  show <id>                               one job with its payload and last error
+  retry <id>... | retry -failed           queue failed jobs again
+  purge [-status done|failed] [-older-than 168h]
+                                          delete finished jobs
+`
+
+// The `jobs` subcommand, run against the database of the api instead of serving it.
+//
+//	go run . jobs list -status failed
+func Command(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(out, usage)
+		return errors.New("missing command")
+	}
+	switch args[0] {
+	case "stats":
+		return commandStats(out)
+	case "list":
+		return commandList(args[1:], out)
+	case "show":
+		return commandShow(args[1:], out)
+	case "retry":
+		return commandRetry(args[1:], out)
+	case "purge":
+		return commandPurge(args[1:], out)
+	}
+	fmt.Fprint(out, usage)
+	return errors.New("unknown command " + args[0])
+}
+
+func commandStats(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "QUEUE\tSTATUS\tCOUNT")
+	for _, count := range countJobs() {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", count.Queue, count.Status, count.Count)
+	}
+	return w.Flush()
+}
+
+func commandList(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.SetOutput(out)
+	status := flags.String("status", "", "pending, running, done or failed")
+	queue := flags.String("queue", "", "only this queue")
+	limit := flags.Int("limit", 20, "number of jobs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *status != "" && !validStatus(*status) {
+		return errors.New("unknown status " + *status)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tQUEUE\tTYPE\tSTATUS\tATTEMPTS\tRUN AT\tLAST ERROR")
+	for _, model := range listJobs(*status, *queue, *limit) {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d/%d\t%s\t%s\n", model.ID, model.Queue, model.Type, model.Status,
+			model.Attempts, model.MaxAttempts, model.RunAt.UTC().Format(time.RFC3339), model.LastError)
+	}
+	return w.Flush()
+}
+
+func parseIDs(args []string) ([]uint, error) {
+	ids := make([]uint, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			return nil, errors.New("invalid id " + arg)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+func commandShow(args []string, out io.Writer) error {
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return errors.New("show takes one id")
+	}
+	model, err := findJob(ids[0])
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "id\t%d\n", model.ID)
+	fmt.Fprintf(w, "queue\t%s\n", model.Queue)
+	fmt.Fprintf(w, "type\t%s\n", model.Type)
+	fmt.Fprintf(w, "status\t%s\n", model.Status)
+	fmt.Fprintf(w, "attempts\t%d/%d\n", model.Attempts, model.MaxAttempts)
+	fmt.Fprintf(w, "created at\t%s\n", model.CreatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "run at\t%s\n", model.RunAt.UTC().Format(time.RFC3339))
+	if model.FinishedAt != nil {
+		fmt.Fprintf(w, "finished at\t%s\n", model.FinishedAt.UTC().Format(time.RFC3339))
+	}
+	if model.UniqueKey != nil {
+		fmt.Fprintf(w, "unique key\t%s\n", *model.UniqueKey)
+	}
+	fmt.Fprintf(w, "payload\t%s\n", model.Payload)
+	fmt.Fprintf(w, "last error\t%s\n", model.LastError)
+	return w.Flush()
+}
+
+func commandRetry(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("retry", flag.ContinueOnError)
+	flags.SetOutput(out)
+	allFailed := flags.Bool("failed", false, "retry every failed job")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	ids, err := parseIDs(flags.Args())
+	if err != nil {
+		return err
+	}
+	if *allFailed {
+		for _, model := range listJobs(StatusFailed, "", -1) {
+			ids = append(ids, model.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return errors.New("retry takes ids or -failed")
+	}
+	for _, id := range ids {
+		model, err := findJob(id)
+		if err == nil {
+			err = model.retry()
+		}
+		if err != nil {
+			return fmt.Errorf("job %d: %w", id, err)
+		}
+		fmt.Fprintln(out, "retrying", model)
+	}
+	return nil
+}
+
+func commandPurge(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	flags.SetOutput(out)
+	status := flags.String("status", StatusDone, "done or failed")
+	olderThan := flags.Duration("older-than", 7*24*time.Hour, "only jobs finished longer ago")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	count, err := purgeJobs(*status, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "purged %d %s jobs\n", count, *status)
+	return nil
+}
@@ -0,0 +1,14 @@
+/*
+The jobs module containing a persistent queue of background work on the application database, for
+anything that shouldn't run in the request cycle: delayed jobs, retries with backoff, unique jobs
+and a number of workers per queue.
+
+models.go: definition of orm based data model and the queries of the command line
+
+queue.go: job types, their handlers and enqueueing
+
+worker.go: the workers claiming and running due jobs, and their drain on shutdown
+
+command.go: the `jobs` subcommand to inspect, retry and purge jobs
+*/
+package jobs
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+)
+
+// Status of a job.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+var statuses = []string{StatusPending, StatusRunning, StatusDone, StatusFailed}
+
+type JobModel struct {
+	gorm.Model
+	Queue   string `gorm:"size:64;index"`
+	Type    string `gorm:"size:64"`
+	Payload string `gorm:"type:text"`
+	Status  string `gorm:"size:16;index"`
+	// Set while the job is pending or running, so the unique index refuses a duplicate. Cleared
+	// once it is finished, the same job can be queued again.
+	UniqueKey   *string `gorm:"size:191;unique_index"`
+	Attempts    int
+	MaxAttempts int
+	RunAt       time.Time `gorm:"index"`
+	LockedUntil *time.Time
+	LastError   string `gorm:"size:1024"`
+	FinishedAt  *time.Time
+}
+
+func AutoMigrate() {
+	db := common.GetDB()
+
+	db.AutoMigrate(&JobModel{})
+}
+
+func validStatus(status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func findJob(id uint) (JobModel, error) {
+	db := common.GetDB()
+	var model JobModel
+	err := db.First(&model, id).Error
+	return model, err
+}
+
+// Jobs with `status` and in `queue`, both optional, latest first.
+func listJobs(status, queue string, limit int) []JobModel {
+	db := common.GetDB()
+	var models []JobModel
+	query := db.Order("id desc").Limit(limit)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if queue != "" {
+		query = query.Where("queue = ?", queue)
+	}
+	query.Find(&models)
+	return models
+}
+
+type queueCount struct {
+	Queue  string
+	Status string
+	Count  int
+}
+
+func countJobs() []queueCount {
+	db := common.GetDB()
+	var counts []queueCount
+	db.Model(&JobModel{}).Select("queue, status, count(*) as count").Group("queue, status").Order("queue, status").Scan(&counts)
+	return counts
+}
+
+// Queue a failed job again with a fresh set of attempts, it keeps its payload.
+func (model *JobModel) retry() error {
+	if model.Status != StatusFailed {
+		return errNotFailed
+	}
+	db := common.GetDB()
+	model.Status = StatusPending
+	model.Attempts = 0
+	model.RunAt = time.Now()
+	model.FinishedAt = nil
+	model.LastError = ""
+	if err := db.Save(model).Error; err != nil {
+		return err
+	}
+	wake(model.Queue)
+	return nil
+}
+
+// Delete the finished jobs with `status` (done or failed) finished before `before`, returns how many.
+func purgeJobs(status string, before time.Time) (int64, error) {
+	if status != StatusDone && status != StatusFailed {
+		return 0, errNotFinished
+	}
+	db := common.GetDB()
+	result := db.Unscoped().Where("status = ? AND finished_at < ?", status, before).Delete(&JobModel{})
+	return result.RowsAffected, result.Error
+}
+
+func (model JobModel) String() string {
+	return "#" + strconv.FormatUint(uint64(model.ID), 10) + " " + model.Type + " (" + model.Queue + ", " + model.Status + ")"
+}
@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"realworld-backend/common"
+)
+
+// Every job names its type, a handler is registered per type and the name is stored with the job.
+type Job interface {
+	JobType() string
+}
+
+type handler struct {
+	Queue  string
+	Handle func(ctx context.Context, payload []byte) error
+}
+
+var (
+	mutex    sync.RWMutex
+	handlers = map[string]handler{}
+)
+
+var (
+	errUnknownType = errors.New("No handler for this job type")
+	errNotFailed   = errors.New("Only failed jobs can be retried")
+	errNotFinished = errors.New("Only done or failed jobs can be purged")
+	errNotStarted  = errors.New("Worker not started")
+	errDraining    = errors.New("Worker draining")
+)
+
+// Run jobs of type T with `handle`, on `queue` unless they are enqueued on another one. Register
+// once at startup, before the workers start.
+//
+//	jobs.Register("mail", func(ctx context.Context, job SendDigest) error { ... })
+func Register[T Job](queue string, handle func(context.Context, T) error) {
+	var zero T
+	mutex.Lock()
+	defer mutex.Unlock()
+	handlers[zero.JobType()] = handler{queue, func(ctx context.Context, payload []byte) error {
+		var job T
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		return handle(ctx, job)
+	}}
+}
+
+func handlerOf(jobType string) (handler, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	h, ok := handlers[jobType]
+	return h, ok
+}
+
+// How a job is queued, the zero value runs it now on the queue of its type.
+type Options struct {
+	Queue string
+	// Run the job at RunAt instead of now.
+	RunAt time.Time
+	// While a job with the same key is pending or running, enqueueing returns it instead.
+	UniqueKey string
+	// Attempts before the job is marked failed, JOBS_MAX_ATTEMPTS by default.
+	MaxAttempts int
+}
+
+// Queue `job`, returns the job model, or the already queued one for a UniqueKey.
+//
+//	jobs.Enqueue(SendDigest{UserID: 1}, jobs.Options{RunAt: time.Now().Add(time.Hour), UniqueKey: "digest/1"})
+func Enqueue(job Job, options Options) (JobModel, error) {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return JobModel{}, err
+	}
+	model := JobModel{
+		Queue:       options.Queue,
+		Type:        job.JobType(),
+		Payload:     string(payload),
+		Status:      StatusPending,
+		MaxAttempts: options.MaxAttempts,
+		RunAt:       options.RunAt,
+	}
+	if model.Queue == "" {
+		model.Queue = "default"
+		if h, ok := handlerOf(model.Type); ok && h.Queue != "" {
+			model.Queue = h.Queue
+		}
+	}
+	if model.MaxAttempts <= 0 {
+		model.MaxAttempts = common.GetenvInt("JOBS_MAX_ATTEMPTS", 5)
+	}
+	if model.RunAt.IsZero() {
+		model.RunAt = time.Now()
+	}
+	db := common.GetDB()
+	if options.UniqueKey != "" {
+		model.UniqueKey = &options.UniqueKey
+		var existing JobModel
+		db.Where("unique_key = ?", options.UniqueKey).First(&existing)
+		if existing.ID != 0 {
+			return existing, nil
+		}
+	}
+	if err := db.Create(&model).Error; err != nil {
+		if options.UniqueKey != "" {
+			// Queued by someone else in between.
+			var existing JobModel
+			if db.Where("unique_key = ?", options.UniqueKey).First(&existing).Error == nil {
+				return existing, nil
+			}
+		}
+		return model, err
+	}
+	if !model.RunAt.After(time.Now()) {
+		wake(model.Queue)
+	}
+	return model, nil
+}
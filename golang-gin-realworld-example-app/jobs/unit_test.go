@@ -0,0 +1,280 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+)
+
+var test_db *gorm.DB
+
+type greet struct {
+	Name string `json:"name"`
+}
+
+func (greet) JobType() string { return "test.greet" }
+
+type flaky struct {
+	FailTimes int `json:"failTimes"`
+}
+
+func (flaky) JobType() string { return "test.flaky" }
+
+type slow struct{}
+
+func (slow) JobType() string { return "test.slow" }
+
+type long struct{}
+
+func (long) JobType() string { return "test.long" }
+
+var (
+	greeted     []string
+	flakyRuns   int
+	slowRunning = make(chan struct{}, 1)
+	slowDone    atomic.Bool
+	longRunning = make(chan struct{}, 1)
+	longRelease = make(chan struct{})
+)
+
+func init() {
+	Register("greetings", func(ctx context.Context, job greet) error {
+		greeted = append(greeted, job.Name)
+		return nil
+	})
+	Register("", func(ctx context.Context, job flaky) error {
+		flakyRuns++
+		if flakyRuns <= job.FailTimes {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	Register("slow", func(ctx context.Context, job slow) error {
+		slowRunning <- struct{}{}
+		select {
+		case <-time.After(200 * time.Millisecond):
+			slowDone.Store(true)
+		case <-ctx.Done():
+		}
+		return ctx.Err()
+	})
+	Register("long", func(ctx context.Context, job long) error {
+		longRunning <- struct{}{}
+		<-longRelease
+		return nil
+	})
+}
+
+// Claim and run every due job of `queue` in the test goroutine.
+func runDue(queue string) int {
+	count := 0
+	for {
+		model, ok := claim(queue)
+		if !ok {
+			return count
+		}
+		run(context.Background(), &model)
+		count++
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Setenv("JOBS_QUEUES", "default=3, mail=1,broken=x,=2")
+	defer os.Unsetenv("JOBS_QUEUES")
+	asserts.Equal(map[string]int{"default": 3, "mail": 1}, Concurrency())
+}
+
+func TestEnqueue(t *testing.T) {
+	asserts := assert.New(t)
+	greeted = nil
+
+	model, err := Enqueue(greet{Name: "alice"}, Options{})
+	asserts.NoError(err)
+	asserts.Equal("greetings", model.Queue, "jobs should go to the queue of their type")
+	asserts.Equal(5, model.MaxAttempts)
+	later, _ := Enqueue(greet{Name: "later"}, Options{RunAt: time.Now().Add(time.Hour)})
+	Enqueue(greet{Name: "elsewhere"}, Options{Queue: "other"})
+
+	asserts.Equal(1, runDue("greetings"), "delayed jobs should wait")
+	asserts.Equal([]string{"alice"}, greeted)
+	model, _ = findJob(model.ID)
+	asserts.Equal(StatusDone, model.Status)
+	asserts.NotNil(model.FinishedAt)
+
+	test_db.Model(&later).Update("run_at", time.Now())
+	asserts.Equal(1, runDue("greetings"), "delayed jobs should run once due")
+	asserts.Equal(1, runDue("other"), "the queue can be chosen when enqueueing")
+	asserts.Equal([]string{"alice", "later", "elsewhere"}, greeted)
+}
+
+func TestUniqueJobs(t *testing.T) {
+	asserts := assert.New(t)
+	greeted = nil
+
+	first, err := Enqueue(greet{Name: "bob"}, Options{UniqueKey: "greet/bob"})
+	asserts.NoError(err)
+	second, err := Enqueue(greet{Name: "bob"}, Options{UniqueKey: "greet/bob"})
+	asserts.NoError(err)
+	asserts.Equal(first.ID, second.ID, "a pending unique job should not be queued twice")
+
+	runDue("greetings")
+	third, err := Enqueue(greet{Name: "bob"}, Options{UniqueKey: "greet/bob"})
+	asserts.NoError(err)
+	asserts.NotEqual(first.ID, third.ID, "a finished unique job can be queued again")
+	runDue("greetings")
+	asserts.Equal([]string{"bob", "bob"}, greeted)
+}
+
+func TestRetries(t *testing.T) {
+	asserts := assert.New(t)
+	flakyRuns = 0
+
+	model, _ := Enqueue(flaky{FailTimes: 1}, Options{MaxAttempts: 2})
+	asserts.Equal("default", model.Queue, "jobs without a queue should go to the default one")
+	runDue("default")
+	model, _ = findJob(model.ID)
+	asserts.Equal(StatusPending, model.Status, "a failed attempt should be retried")
+	asserts.Equal("not yet", model.LastError)
+	asserts.True(model.RunAt.After(time.Now()), "the retry should wait")
+	asserts.Equal(0, runDue("default"), "the retry should not run before its time")
+
+	test_db.Model(&model).Update("run_at", time.Now())
+	runDue("default")
+	model, _ = findJob(model.ID)
+	asserts.Equal(StatusDone, model.Status, "the job should succeed on its second attempt")
+
+	flakyRuns = 0
+	failing, _ := Enqueue(flaky{FailTimes: 9}, Options{MaxAttempts: 1})
+	runDue("default")
+	failing, _ = findJob(failing.ID)
+	asserts.Equal(StatusFailed, failing.Status, "a job should fail after its last attempt")
+
+	var out bytes.Buffer
+	asserts.NoError(Command([]string{"retry", strconv.FormatUint(uint64(failing.ID), 10)}, &out))
+	asserts.Contains(out.String(), "retrying #"+strconv.FormatUint(uint64(failing.ID), 10))
+	failing, _ = findJob(failing.ID)
+	asserts.Equal(StatusPending, failing.Status, "a retried job should be pending again")
+	asserts.Equal(0, failing.Attempts, "a retried job should get its attempts back")
+	asserts.Error(Command([]string{"retry", strconv.FormatUint(uint64(model.ID), 10)}, &out), "only failed jobs can be retried")
+}
+
+func TestUnknownType(t *testing.T) {
+	asserts := assert.New(t)
+
+	test_db.Create(&JobModel{Queue: "orphans", Type: "test.unknown", Status: StatusPending, MaxAttempts: 1, RunAt: time.Now()})
+	runDue("orphans")
+	var model JobModel
+	test_db.Where(JobModel{Queue: "orphans"}).First(&model)
+	asserts.Equal(StatusFailed, model.Status, "jobs nobody handles should fail")
+	asserts.Equal(errUnknownType.Error(), model.LastError)
+}
+
+func TestWorkerDrain(t *testing.T) {
+	asserts := assert.New(t)
+
+	worker := NewWorker(map[string]int{"slow": 1})
+	asserts.ErrorIs(worker.Check(context.Background()), errNotStarted, "a worker isn't ready before it starts")
+	worker.Start()
+	asserts.NoError(worker.Check(context.Background()), "a started worker should be ready")
+	Enqueue(slow{}, Options{})
+	<-slowRunning
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	asserts.NoError(worker.Drain(ctx), "draining should wait for the running job")
+	asserts.ErrorIs(worker.Check(context.Background()), errDraining, "a draining worker isn't ready")
+	asserts.True(slowDone.Load(), "the running job should finish")
+
+	model, _ := Enqueue(slow{}, Options{})
+	time.Sleep(50 * time.Millisecond)
+	model, _ = findJob(model.ID)
+	asserts.Equal(StatusPending, model.Status, "a drained worker should not take new jobs")
+
+	slowDone.Store(false)
+	worker = NewWorker(map[string]int{"slow": 1})
+	worker.Start()
+	<-slowRunning
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	asserts.ErrorIs(worker.Drain(ctx), context.DeadlineExceeded, "draining should give up at the deadline")
+	asserts.Eventually(func() bool {
+		model, _ = findJob(model.ID)
+		return model.Status == StatusPending && model.Attempts == 1
+	}, time.Second, 10*time.Millisecond, "the canceled job should be retried")
+	asserts.False(slowDone.Load())
+}
+
+func TestLeaseRenewal(t *testing.T) {
+	asserts := assert.New(t)
+
+	defaultLease := lease
+	lease = 30 * time.Millisecond
+	defer func() { lease = defaultLease }()
+
+	model, _ := Enqueue(long{}, Options{})
+	claimed, ok := claim("long")
+	asserts.True(ok)
+	done := make(chan struct{})
+	go func() {
+		run(context.Background(), &claimed)
+		close(done)
+	}()
+	<-longRunning
+	time.Sleep(4 * lease)
+	_, ok = claim("long")
+	asserts.False(ok, "a job running longer than the lease should keep it")
+	close(longRelease)
+	<-done
+
+	model, _ = findJob(model.ID)
+	asserts.Equal(StatusDone, model.Status)
+	asserts.Equal(1, model.Attempts, "the job should have run once")
+	asserts.Nil(model.LockedUntil, "renewals should stop with the job")
+}
+
+func TestCommand(t *testing.T) {
+	asserts := assert.New(t)
+
+	var out bytes.Buffer
+	asserts.NoError(Command([]string{"stats"}, &out))
+	asserts.Contains(out.String(), "greetings")
+
+	out.Reset()
+	asserts.NoError(Command([]string{"list", "-queue", "greetings", "-status", "done", "-limit", "1"}, &out))
+	asserts.Contains(out.String(), "test.greet")
+	asserts.Error(Command([]string{"list", "-status", "lost"}, &out), "unknown statuses should be refused")
+
+	model, _ := Enqueue(greet{Name: "shown"}, Options{Queue: "shown"})
+	out.Reset()
+	asserts.NoError(Command([]string{"show", strconv.FormatUint(uint64(model.ID), 10)}, &out))
+	asserts.Contains(out.String(), `{"name":"shown"}`, "show should print the payload")
+
+	test_db.Model(&JobModel{}).Where("status = ?", StatusDone).Update("finished_at", time.Now().Add(-48*time.Hour))
+	out.Reset()
+	asserts.NoError(Command([]string{"purge", "-older-than", "24h"}, &out))
+	var done int
+	test_db.Model(&JobModel{}).Where("status = ?", StatusDone).Count(&done)
+	asserts.Equal(0, done, "old done jobs should be purged")
+	asserts.Error(Command([]string{"purge", "-status", "pending"}, &out), "unfinished jobs can't be purged")
+
+	asserts.Error(Command([]string{}, &out))
+	asserts.Error(Command([]string{"explode"}, &out))
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	AutoMigrate()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
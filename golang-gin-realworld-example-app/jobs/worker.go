@@ -0,0 +1,254 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"realworld-backend/common"
+)
+
+// How long a running job is held before another worker may take it over, as if its worker died.
+// Workers renew the lease of the jobs they run every third of it, however long they take.
+var lease = 10 * time.Minute
+
+// Workers per queue, from JOBS_QUEUES: comma separated `queue=count`.
+//
+//	JOBS_QUEUES=default=2,mail=1
+func Concurrency() map[string]int {
+	queues := map[string]int{}
+	for _, item := range strings.Split(common.Getenv("JOBS_QUEUES", "default=2,mail=1"), ",") {
+		name, count, _ := strings.Cut(strings.TrimSpace(item), "=")
+		n, err := strconv.Atoi(count)
+		if name == "" || err != nil || n <= 0 {
+			continue
+		}
+		queues[name] = n
+	}
+	return queues
+}
+
+var (
+	wakeupMutex sync.Mutex
+	wakeups     = map[string]chan struct{}{}
+)
+
+func wakeupOf(queue string) chan struct{} {
+	wakeupMutex.Lock()
+	defer wakeupMutex.Unlock()
+	if wakeups[queue] == nil {
+		wakeups[queue] = make(chan struct{}, 1)
+	}
+	return wakeups[queue]
+}
+
+// Nudge an idle worker of `queue` instead of waiting for its next poll.
+func wake(queue string) {
+	select {
+	case wakeupOf(queue) <- struct{}{}:
+	default:
+	}
+}
+
+type Worker struct {
+	queues   map[string]int
+	started  atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup
+	// Context of the running jobs, only canceled when draining runs out of time.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewWorker(queues map[string]int) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Worker{queues: queues, stop: make(chan struct{}), ctx: ctx, cancel: cancel}
+}
+
+// Start the workers of every queue, started once from main.
+//
+//	worker := jobs.NewWorker(jobs.Concurrency())
+//	worker.Start()
+//	defer worker.Drain(ctx)
+func (w *Worker) Start() {
+	w.started.Store(true)
+	for queue, count := range w.queues {
+		for i := 0; i < count; i++ {
+			w.running.Add(1)
+			go w.work(queue)
+		}
+	}
+}
+
+// Stop taking jobs and wait for the running ones to finish. When ctx is done first, the jobs are
+// canceled through their context, those that don't return are taken over by another worker once
+// their lease expires.
+func (w *Worker) Drain(ctx context.Context) error {
+	w.stopOnce.Do(func() { close(w.stop) })
+	drained := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		return ctx.Err()
+	}
+}
+
+// Readiness of the worker, it fails until Start and once Drain is called.
+//
+//	health.Register("workers/jobs", worker.Check)
+func (w *Worker) Check(ctx context.Context) error {
+	select {
+	case <-w.stop:
+		return errDraining
+	default:
+	}
+	if !w.started.Load() {
+		return errNotStarted
+	}
+	return nil
+}
+
+func (w *Worker) work(queue string) {
+	defer w.running.Done()
+	poll := common.GetenvDuration("JOBS_POLL_INTERVAL", 5*time.Second)
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+		if model, ok := claim(queue); ok {
+			run(w.ctx, &model)
+			continue
+		}
+		timer := time.NewTimer(poll)
+		select {
+		case <-w.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-wakeupOf(queue):
+			timer.Stop()
+		}
+	}
+}
+
+// Take the next due job of `queue`, or a running one whose worker stopped renewing its lease.
+func claim(queue string) (JobModel, bool) {
+	db := common.GetDB()
+	now := time.Now()
+	var candidates []JobModel
+	db.Where("queue = ? AND ((status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?))", queue, StatusPending, now, StatusRunning, now).
+		Order("run_at, id").Limit(5).Find(&candidates)
+	for _, model := range candidates {
+		lockedUntil := now.Add(lease)
+		result := db.Model(&JobModel{}).
+			Where("id = ? AND (status = ? OR (status = ? AND locked_until < ?))", model.ID, StatusPending, StatusRunning, now).
+			Updates(map[string]interface{}{"status": StatusRunning, "locked_until": lockedUntil})
+		if result.Error == nil && result.RowsAffected == 1 {
+			model.Status = StatusRunning
+			model.LockedUntil = &lockedUntil
+			return model, true
+		}
+	}
+	return JobModel{}, false
+}
+
+// Delay before the next attempt, doubling from JOBS_RETRY_BASE up to an hour.
+func backoff(attempts int) time.Duration {
+	delay := common.GetenvDuration("JOBS_RETRY_BASE", 10*time.Second)
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// Run a claimed job once and record the outcome, a failed job is retried until MaxAttempts.
+func run(ctx context.Context, model *JobModel) {
+	stopRenewing := renewLease(model.ID)
+	err := handle(ctx, model)
+	stopRenewing()
+	now := time.Now()
+	model.Attempts++
+	model.LockedUntil = nil
+	if err == nil {
+		model.Status = StatusDone
+		model.FinishedAt = &now
+		model.UniqueKey = nil
+		model.LastError = ""
+	} else {
+		slog.Error("jobs err: (run)", "type", model.Type, "job", model.ID, "err", err)
+		model.LastError = err.Error()
+		if len(model.LastError) > 1024 {
+			model.LastError = model.LastError[:1024]
+		}
+		if model.Attempts >= model.MaxAttempts {
+			model.Status = StatusFailed
+			model.FinishedAt = &now
+			model.UniqueKey = nil
+		} else {
+			model.Status = StatusPending
+			model.RunAt = now.Add(backoff(model.Attempts))
+		}
+	}
+	if err := common.GetDB().Save(model).Error; err != nil {
+		slog.Error("jobs err: (save)", "job", model.ID, "err", err)
+	}
+}
+
+// Push back the lease of running job `id` until the returned func is called, which waits for
+// the last renewal so it doesn't overwrite the outcome of the job.
+func renewLease(id uint) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := common.GetDB().Model(&JobModel{}).Where("id = ? AND status = ?", id, StatusRunning).
+					UpdateColumn("locked_until", time.Now().Add(lease)).Error
+				if err != nil {
+					slog.Error("jobs err: (renewLease)", "job", id, "err", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// A panicking handler fails its job instead of the worker.
+func handle(ctx context.Context, model *JobModel) (err error) {
+	h, ok := handlerOf(model.Type)
+	if !ok {
+		return errUnknownType
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h.Handle(ctx, []byte(model.Payload))
+}
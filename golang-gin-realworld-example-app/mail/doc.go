@@ -0,0 +1,9 @@
+/*
+The mail module sending emails through a Mailer: SMTP when configured, the log otherwise, and an
+in-memory one capturing messages for tests.
+
+mailer.go: the Mailer interface, the one in use and the log and capturing mailers
+
+smtp.go: the SMTP mailer and the MIME encoding of messages
+*/
+package mail
@@ -0,0 +1,104 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"realworld-backend/common"
+)
+
+// An email with a plain-text and an optional HTML body, sent as alternatives of each other.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// Extra headers, such as List-Unsubscribe.
+	Headers map[string]string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+var (
+	mutex  sync.RWMutex
+	mailer Mailer = LogMailer{}
+)
+
+// Select the mailer from the configuration: SMTP when SMTP_HOST is set, the log otherwise.
+func Init() Mailer {
+	if host := common.Getenv("SMTP_HOST", ""); host != "" {
+		SetMailer(&SMTPMailer{
+			Host:     host,
+			Port:     common.GetenvInt("SMTP_PORT", 587),
+			Username: common.Getenv("SMTP_USERNAME", ""),
+			Password: common.Getenv("SMTP_PASSWORD", ""),
+			From:     From(),
+		})
+	}
+	return GetMailer()
+}
+
+// Replace the mailer, tests set a CaptureMailer.
+func SetMailer(m Mailer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	mailer = m
+}
+
+func GetMailer() Mailer {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return mailer
+}
+
+// Send `message` with the mailer in use.
+func Send(ctx context.Context, message Message) error {
+	return GetMailer().Send(ctx, message)
+}
+
+// Sender of every email, MAIL_FROM.
+func From() string {
+	return common.Getenv("MAIL_FROM", "Conduit <no-reply@localhost>")
+}
+
+// Logs messages instead of sending them, the default until SMTP is configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, message Message) error {
+	slog.Info("mail", "to", message.To, "subject", message.Subject, "text", message.Text)
+	return nil
+}
+
+// Keeps messages in memory.
+//
+//	captured := &mail.CaptureMailer{}
+//	mail.SetMailer(captured)
+//	...
+//	captured.Messages()
+type CaptureMailer struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func (m *CaptureMailer) Send(ctx context.Context, message Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// The messages sent so far, oldest first.
+func (m *CaptureMailer) Messages() []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Message{}, m.messages...)
+}
+
+func (m *CaptureMailer) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = nil
+}
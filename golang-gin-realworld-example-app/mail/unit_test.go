@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	asserts := assert.New(t)
+
+	body, err := encode("Conduit <no-reply@localhost>", Message{
+		To:      "alice@example.com",
+		Subject: "Your digest: 2 new articles",
+		Text:    "Hello alice",
+		HTML:    "<p>Hello <b>alice</b></p>",
+		Headers: map[string]string{"List-Unsubscribe": "<http://localhost:8080/unsubscribe>"},
+	})
+	asserts.NoError(err)
+	parsed, err := mail.ReadMessage(bytes.NewReader(body))
+	asserts.NoError(err, "the message should parse")
+	asserts.Equal("alice@example.com", parsed.Header.Get("To"))
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	asserts.Equal("Your digest: 2 new articles", subject)
+	asserts.Equal("<http://localhost:8080/unsubscribe>", parsed.Header.Get("List-Unsubscribe"))
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	asserts.NoError(err)
+	asserts.Equal("multipart/alternative", mediaType)
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	parts := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		content, _ := io.ReadAll(quotedprintable.NewReader(part))
+		parts[part.Header.Get("Content-Type")] = string(content)
+	}
+	asserts.Equal("Hello alice", parts["text/plain; charset=utf-8"])
+	asserts.Equal("<p>Hello <b>alice</b></p>", parts["text/html; charset=utf-8"])
+
+	body, err = encode("Conduit <no-reply@localhost>", Message{To: "bob@example.com", Subject: "Plain", Text: "Just text"})
+	asserts.NoError(err)
+	parsed, _ = mail.ReadMessage(bytes.NewReader(body))
+	asserts.Equal("text/plain; charset=utf-8", parsed.Header.Get("Content-Type"))
+	content, _ := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	asserts.Equal("Just text", string(content), "a message without HTML should be plain text")
+}
+
+func TestCaptureMailer(t *testing.T) {
+	asserts := assert.New(t)
+
+	captured := &CaptureMailer{}
+	SetMailer(captured)
+	defer SetMailer(LogMailer{})
+
+	asserts.NoError(Send(context.Background(), Message{To: "alice@example.com", Subject: "Hi"}))
+	asserts.Len(captured.Messages(), 1, "sent messages should be captured")
+	asserts.Equal("Hi", captured.Messages()[0].Subject)
+	captured.Reset()
+	asserts.Len(captured.Messages(), 0)
+}
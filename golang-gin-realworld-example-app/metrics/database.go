@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+var queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "gorm_query_duration_seconds",
+	Help:    "Time of the queries run through GORM, by operation and table. Raw queries have no table.",
+	Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"operation", "table"})
+
+const startKey = "metrics:start"
+
+// Export the connection pool statistics of `db` as go_sql_* and time its queries, call once at
+// startup with the database of common.Init.
+func InstrumentDB(db *gorm.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db.DB(), "main"))
+
+	callback := db.Callback()
+	callback.Create().Before("gorm:create").Register("metrics:before_create", start)
+	callback.Create().After("gorm:create").Register("metrics:after_create", observe("create"))
+	callback.Query().Before("gorm:query").Register("metrics:before_query", start)
+	callback.Query().After("gorm:query").Register("metrics:after_query", observe("query"))
+	callback.Update().Before("gorm:update").Register("metrics:before_update", start)
+	callback.Update().After("gorm:update").Register("metrics:after_update", observe("update"))
+	callback.Delete().Before("gorm:delete").Register("metrics:before_delete", start)
+	callback.Delete().After("gorm:delete").Register("metrics:after_delete", observe("delete"))
+	callback.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", start)
+	callback.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", observe("row_query"))
+}
+
+func start(scope *gorm.Scope) {
+	scope.Set(startKey, time.Now())
+}
+
+func observe(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		value, ok := scope.Get(startKey)
+		if !ok {
+			return
+		}
+		table := ""
+		if scope.Value != nil {
+			table = scope.TableName()
+		}
+		queryDuration.WithLabelValues(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+	}
+}
@@ -0,0 +1,10 @@
+/*
+The metrics module containing the Prometheus metrics of the api, served under /metrics.
+
+metrics.go: the registry, http metrics and business counters
+
+database.go: connection pool statistics and query durations
+
+routers.go: router binding of /metrics
+*/
+package metrics
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Metrics are kept out of the global registry of client_golang so tests start from a known set.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Requests served, by method, route template and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time to serve requests, by method, route template and status. Event streams and WebSockets last as long as the connection.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	httpInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Requests being served.",
+	})
+
+	// Business counters, incremented where the change is saved.
+	Registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "realworld_registrations_total",
+		Help: "Users registered.",
+	})
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "realworld_logins_total",
+		Help: "Login attempts, by result: success or failure.",
+	}, []string{"result"})
+	ArticlesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "realworld_articles_created_total",
+		Help: "Articles created.",
+	})
+	Favorites = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "realworld_favorites_total",
+		Help: "Articles favorited, favoriting twice counts once.",
+	})
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "realworld_rate_limited_total",
+		Help: "Requests refused by a rate limit, by policy.",
+	}, []string{"policy"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, httpInFlight,
+		Registrations, Logins, ArticlesCreated, Favorites, RateLimited,
+		queryDuration,
+	)
+}
+
+// Middleware recording the http metrics. Requests are labeled by route template such as
+// "/api/articles/:slug" to keep the number of series bounded, unknown urls as "unmatched".
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
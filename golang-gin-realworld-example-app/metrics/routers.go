@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"realworld-backend/common"
+)
+
+// Serve the metrics for Prometheus to scrape. When METRICS_TOKEN is set, scrapers must send it as
+// `Authorization: Bearer <token>`, otherwise keep /metrics off the public network.
+func MetricsRegister(router *gin.RouterGroup) {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+	router.GET("/metrics", func(c *gin.Context) {
+		token := common.Getenv("METRICS_TOKEN", "")
+		if token != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	})
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+)
+
+var test_db *gorm.DB
+
+type probeModel struct {
+	gorm.Model
+	Name string
+}
+
+func request(r *gin.Engine, url string, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
+	if header != nil {
+		req.Header = header
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	asserts := assert.New(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/api/articles/:slug", func(c *gin.Context) {
+		asserts.Equal(float64(1), testutil.ToFloat64(httpInFlight), "the request should be in flight while served")
+		c.Status(http.StatusTeapot)
+	})
+
+	request(r, "/api/articles/hello", nil)
+	request(r, "/api/articles/world", nil)
+	request(r, "/nowhere", nil)
+	asserts.Equal(float64(2), testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/api/articles/:slug", "418")), "requests should be counted by route template")
+	asserts.Equal(float64(1), testutil.ToFloat64(httpRequests.WithLabelValues("GET", "unmatched", "404")), "unknown urls should share one label")
+	asserts.Equal(2, testutil.CollectAndCount(httpDuration), "durations should be observed by route and status")
+	asserts.Equal(float64(0), testutil.ToFloat64(httpInFlight))
+}
+
+func TestInstrumentDB(t *testing.T) {
+	asserts := assert.New(t)
+
+	test_db.Create(&probeModel{Name: "probe"})
+	var found probeModel
+	test_db.First(&found)
+	test_db.Model(&found).Update("name", "updated")
+	test_db.Delete(&found)
+
+	r := gin.New()
+	MetricsRegister(r.Group(""))
+	w := request(r, "/metrics", nil)
+	asserts.Equal(http.StatusOK, w.Code)
+	for _, operation := range []string{"create", "query", "update", "delete"} {
+		asserts.Contains(w.Body.String(), `gorm_query_duration_seconds_count{operation="`+operation+`",table="probe_models"} 1`, operation+" should be timed by table")
+	}
+	asserts.Contains(w.Body.String(), `go_sql_open_connections{db_name="main"}`, "pool statistics should be exported")
+	asserts.Contains(w.Body.String(), "realworld_registrations_total 0", "business counters should be exported")
+}
+
+func TestMetricsToken(t *testing.T) {
+	asserts := assert.New(t)
+
+	t.Setenv("METRICS_TOKEN", "s3cret")
+	r := gin.New()
+	MetricsRegister(r.Group(""))
+	asserts.Equal(http.StatusUnauthorized, request(r, "/metrics", nil).Code, "scrapes without the token should be refused")
+	asserts.Equal(http.StatusUnauthorized, request(r, "/metrics", http.Header{"Authorization": {"Bearer wrong"}}).Code)
+	asserts.Equal(http.StatusOK, request(r, "/metrics", http.Header{"Authorization": {"Bearer s3cret"}}).Code)
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	test_db.AutoMigrate(&probeModel{})
+	InstrumentDB(test_db)
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
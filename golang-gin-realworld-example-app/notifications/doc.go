@@ -0,0 +1,15 @@
+/*
+The notifications module turning follows, favorites, comments and mentions into notifications of the users
+they concern, grouping the noisy ones ("12 people favorited X").
+
+models.go: definition of orm based data model, grouping and preferences
+
+routers.go: router binding and core logic
+
+serializers.go: definition the schema of return data
+
+validators.go: definition the validator of form data
+
+events.go: subscription to the events the notifications are made of
+*/
+package notifications
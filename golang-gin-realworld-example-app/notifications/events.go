@@ -0,0 +1,39 @@
+package notifications
+
+import (
+	"github.com/gin-gonic/gin"
+	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/realtime"
+	"realworld-backend/users"
+)
+
+// Register the notifications of follows, favorites, comments and mentions, call once at startup.
+func SubscribeEvents() {
+	events.Subscribe("notifications", func(event events.UserFollowed) error {
+		return notify(event.FollowingID, event.FollowerID, TypeFollow, 0, 0)
+	})
+	events.Subscribe("notifications", func(event events.ArticleFavorited) error {
+		return notify(event.AuthorID, event.UserID, TypeFavorite, event.ArticleID, 0)
+	})
+	events.Subscribe("notifications", func(event events.CommentCreated) error {
+		return notify(event.ArticleAuthorID, event.AuthorID, TypeComment, event.ArticleID, event.CommentID)
+	})
+	events.Subscribe("notifications", func(event events.UserMentioned) error {
+		return notify(event.UserID, event.AuthorID, TypeMention, event.ArticleID, event.CommentID)
+	})
+}
+
+// Send a new or regrouped notification to the WebSocket connections of its recipient, serialized
+// as the recipient sees it, together with the new unread count.
+func push(notification NotificationModel) error {
+	var recipient users.UserModel
+	common.GetDB().First(&recipient, notification.UserID)
+	c := &gin.Context{}
+	c.Set("my_user_model", recipient)
+	serializer := NotificationSerializer{c, notification}
+	return realtime.Publish(realtime.UserTopic(notification.UserID), "notification", gin.H{
+		"notification": serializer.Response(),
+		"unreadCount":  unreadCount(notification.UserID),
+	})
+}
@@ -0,0 +1,253 @@
+package notifications
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// Kinds of notification, also the keys of the preferences.
+const (
+	TypeFollow   = "follow"
+	TypeFavorite = "favorite"
+	TypeComment  = "comment"
+	TypeMention  = "mention"
+)
+
+var notificationTypes = []string{TypeFollow, TypeFavorite, TypeComment, TypeMention}
+
+// An unread notification collects every actor of the same GroupKey until it is read, the next
+// event starts a new one. ActorID, CommentID and UpdatedAt are those of the latest event.
+type NotificationModel struct {
+	gorm.Model
+	UserID      uint   `gorm:"index"`
+	Type        string `gorm:"size:32"`
+	GroupKey    string `gorm:"size:64;index"`
+	ActorID     uint
+	ArticleID   uint
+	CommentID   uint
+	ActorsCount int
+	ReadAt      *time.Time
+}
+
+// Distinct actors of a notification, so favoriting twice doesn't count twice.
+type NotificationActorModel struct {
+	ID             uint `gorm:"primary_key"`
+	NotificationID uint `gorm:"index"`
+	ActorID        uint
+}
+
+// Only the types a user turned off are stored, everything is on by default.
+type NotificationPreferenceModel struct {
+	gorm.Model
+	UserID  uint   `gorm:"index"`
+	Type    string `gorm:"size:32"`
+	Enabled bool
+}
+
+func AutoMigrate() {
+	db := common.GetDB()
+
+	db.AutoMigrate(&NotificationModel{})
+	db.AutoMigrate(&NotificationActorModel{})
+	db.AutoMigrate(&NotificationPreferenceModel{})
+}
+
+func validType(kind string) bool {
+	for _, t := range notificationTypes {
+		if t == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Follows are grouped together, favorites and comments per article, mentions per article or comment.
+func groupKey(kind string, articleID, commentID uint) string {
+	key := kind
+	if articleID != 0 {
+		key += ":" + strconv.FormatUint(uint64(articleID), 10)
+	}
+	if kind == TypeMention {
+		key += ":" + strconv.FormatUint(uint64(commentID), 10)
+	}
+	return key
+}
+
+// Record that `actorID` did `kind` to `userID`. Nothing is recorded for your own actions, for
+// actors you blocked, muted or are blocked by, or when the type is turned off.
+//
+//	err := notify(authorID, favoriterID, TypeFavorite, article.ID, 0)
+func notify(userID, actorID uint, kind string, articleID, commentID uint) error {
+	if userID == 0 || userID == actorID || !enabled(userID, kind) {
+		return nil
+	}
+	for _, id := range (users.UserModel{ID: userID}).HiddenUserIDs(context.Background()) {
+		if id == actorID {
+			return nil
+		}
+	}
+
+	tx := common.GetDB().Begin()
+	var notification NotificationModel
+	tx.Where("user_id = ? AND group_key = ? AND read_at IS NULL", userID, groupKey(kind, articleID, commentID)).First(&notification)
+	if notification.ID == 0 {
+		notification = NotificationModel{
+			UserID:    userID,
+			Type:      kind,
+			GroupKey:  groupKey(kind, articleID, commentID),
+			ArticleID: articleID,
+		}
+		if err := tx.Create(&notification).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	var actor NotificationActorModel
+	tx.Where(NotificationActorModel{NotificationID: notification.ID, ActorID: actorID}).First(&actor)
+	if actor.ID == 0 {
+		if err := tx.Create(&NotificationActorModel{NotificationID: notification.ID, ActorID: actorID}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		notification.ActorsCount++
+	}
+	notification.ActorID = actorID
+	notification.CommentID = commentID
+	if err := tx.Save(&notification).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	return push(notification)
+}
+
+// Number of notifications per page, `?limit=` can ask for up to maxNotificationLimit.
+const (
+	defaultNotificationLimit = 20
+	maxNotificationLimit     = 100
+)
+
+// A page of the notifications of `userID`, last updated first, with their total count.
+//
+//	notifications, count, err := getNotificationsPage(myUserModel.ID, false, "20", "0")
+func getNotificationsPage(userID uint, unread bool, limit, offset string) ([]NotificationModel, int, error) {
+	db := common.GetDB()
+	var models []NotificationModel
+	var count int
+
+	offset_int, err := strconv.Atoi(offset)
+	if err != nil || offset_int < 0 {
+		offset_int = 0
+	}
+	limit_int, err := strconv.Atoi(limit)
+	if err != nil || limit_int <= 0 {
+		limit_int = defaultNotificationLimit
+	}
+	if limit_int > maxNotificationLimit {
+		limit_int = maxNotificationLimit
+	}
+
+	query := db.Model(&NotificationModel{}).Where("user_id = ?", userID)
+	if unread {
+		query = query.Where("read_at IS NULL")
+	}
+	query.Count(&count)
+	err = query.Order("updated_at desc, id desc").Offset(offset_int).Limit(limit_int).Find(&models).Error
+	return models, count, err
+}
+
+func unreadCount(userID uint) int {
+	db := common.GetDB()
+	var count int
+	db.Model(&NotificationModel{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count)
+	return count
+}
+
+func findNotification(userID, id uint) (NotificationModel, error) {
+	db := common.GetDB()
+	var model NotificationModel
+	err := db.Where("user_id = ? AND id = ?", userID, id).First(&model).Error
+	return model, err
+}
+
+func (model *NotificationModel) markRead() error {
+	if model.ReadAt != nil {
+		return nil
+	}
+	db := common.GetDB()
+	now := time.Now()
+	err := db.Model(model).UpdateColumn("read_at", now).Error
+	if err == nil {
+		model.ReadAt = &now
+	}
+	return err
+}
+
+func markAllRead(userID uint) error {
+	db := common.GetDB()
+	return db.Model(&NotificationModel{}).Where("user_id = ? AND read_at IS NULL", userID).UpdateColumn("read_at", time.Now()).Error
+}
+
+// The latest `max` actors of each notification, most recent first.
+func recentActors(notificationIDs []uint, max int) map[uint][]uint {
+	db := common.GetDB()
+	actors := map[uint][]uint{}
+	if len(notificationIDs) == 0 {
+		return actors
+	}
+	var models []NotificationActorModel
+	db.Where("notification_id in (?)", notificationIDs).Order("id desc").Find(&models)
+	for _, model := range models {
+		if len(actors[model.NotificationID]) < max {
+			actors[model.NotificationID] = append(actors[model.NotificationID], model.ActorID)
+		}
+	}
+	return actors
+}
+
+func enabled(userID uint, kind string) bool {
+	db := common.GetDB()
+	var preference NotificationPreferenceModel
+	db.Where(NotificationPreferenceModel{UserID: userID, Type: kind}).First(&preference)
+	return preference.ID == 0 || preference.Enabled
+}
+
+// Every type with its setting.
+func getPreferences(userID uint) map[string]bool {
+	db := common.GetDB()
+	preferences := map[string]bool{}
+	for _, kind := range notificationTypes {
+		preferences[kind] = true
+	}
+	var models []NotificationPreferenceModel
+	db.Where(NotificationPreferenceModel{UserID: userID}).Find(&models)
+	for _, model := range models {
+		if validType(model.Type) {
+			preferences[model.Type] = model.Enabled
+		}
+	}
+	return preferences
+}
+
+func setPreferences(userID uint, preferences map[string]bool) error {
+	tx := common.GetDB().Begin()
+	for kind, on := range preferences {
+		var preference NotificationPreferenceModel
+		tx.Where(NotificationPreferenceModel{UserID: userID, Type: kind}).First(&preference)
+		preference.UserID = userID
+		preference.Type = kind
+		preference.Enabled = on
+		if err := tx.Save(&preference).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,93 @@
+package notifications
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+func NotificationsRegister(router *gin.RouterGroup) {
+	router.GET("/", NotificationList)
+	router.GET("/unread", NotificationUnreadCount)
+	router.POST("/read", NotificationReadAll)
+	router.POST("/:id/read", NotificationRead)
+	router.GET("/preferences", PreferencesRetrieve)
+	router.PUT("/preferences", PreferencesUpdate)
+}
+
+// `?unread=true` leaves out the notifications already read.
+func NotificationList(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	notificationModels, modelCount, err := getNotificationsPage(myUserModel.ID, c.Query("unread") == "true", c.Query("limit"), c.Query("offset"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("notifications", errors.New("Invalid param")))
+		return
+	}
+	serializer := NotificationsSerializer{c, notificationModels}
+	c.JSON(http.StatusOK, gin.H{
+		"notifications":      serializer.Response(),
+		"notificationsCount": modelCount,
+		"unreadCount":        unreadCount(myUserModel.ID),
+	})
+}
+
+func NotificationUnreadCount(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	c.JSON(http.StatusOK, gin.H{"unreadCount": unreadCount(myUserModel.ID)})
+}
+
+func NotificationRead(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("notification", errors.New("Invalid id")))
+		return
+	}
+	notificationModel, err := findNotification(myUserModel.ID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("notification", errors.New("Invalid id")))
+		return
+	}
+	if err := notificationModel.markRead(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := NotificationSerializer{c, notificationModel}
+	c.JSON(http.StatusOK, gin.H{"notification": serializer.Response(), "unreadCount": unreadCount(myUserModel.ID)})
+}
+
+func NotificationReadAll(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err := markAllRead(myUserModel.ID); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unreadCount": 0})
+}
+
+func PreferencesRetrieve(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	c.JSON(http.StatusOK, gin.H{"preferences": getPreferences(myUserModel.ID)})
+}
+
+func PreferencesUpdate(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	preferencesValidator := NewPreferencesValidator()
+	if err := preferencesValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if err := preferencesValidator.checkTypes(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("preferences", err))
+		return
+	}
+	if err := setPreferences(myUserModel.ID, preferencesValidator.Preferences); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"preferences": getPreferences(myUserModel.ID)})
+}
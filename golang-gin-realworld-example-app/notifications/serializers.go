@@ -0,0 +1,141 @@
+package notifications
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// Number of actors shown with a grouped notification, actorsCount tells how many there are.
+const shownActors = 3
+
+type NotificationArticleResponse struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+type NotificationResponse struct {
+	ID          uint                         `json:"id"`
+	Type        string                       `json:"type"`
+	Message     string                       `json:"message"`
+	Read        bool                         `json:"read"`
+	Actors      []users.ProfileResponse      `json:"actors"`
+	ActorsCount int                          `json:"actorsCount"`
+	Article     *NotificationArticleResponse `json:"article"`
+	CommentID   uint                         `json:"commentId,omitempty"`
+	CreatedAt   string                       `json:"createdAt"`
+	UpdatedAt   string                       `json:"updatedAt"`
+}
+
+type NotificationSerializer struct {
+	C *gin.Context
+	NotificationModel
+}
+
+func (s *NotificationSerializer) Response() NotificationResponse {
+	serializer := NotificationsSerializer{s.C, []NotificationModel{s.NotificationModel}}
+	return serializer.Response()[0]
+}
+
+type NotificationsSerializer struct {
+	C             *gin.Context
+	Notifications []NotificationModel
+}
+
+// Actors and articles of the whole page are loaded at once instead of per notification.
+func (s *NotificationsSerializer) Response() []NotificationResponse {
+	db := common.GetDB()
+	ids := make([]uint, 0, len(s.Notifications))
+	articleIDs := []uint{}
+	for _, notification := range s.Notifications {
+		ids = append(ids, notification.ID)
+		if notification.ArticleID != 0 {
+			articleIDs = append(articleIDs, notification.ArticleID)
+		}
+	}
+
+	actors := recentActors(ids, shownActors)
+	actorIDs := []uint{}
+	for _, list := range actors {
+		actorIDs = append(actorIDs, list...)
+	}
+	var userModels []users.UserModel
+	if len(actorIDs) > 0 {
+		db.Where("id in (?)", actorIDs).Find(&userModels)
+	}
+	profilesSerializer := users.ProfilesSerializer{C: s.C, Users: userModels}
+	profiles := map[uint]users.ProfileResponse{}
+	for _, profile := range profilesSerializer.Response() {
+		profiles[profile.ID] = profile
+	}
+
+	var articleModels []articles.ArticleModel
+	if len(articleIDs) > 0 {
+		db.Select("id, slug, title").Where("id in (?)", articleIDs).Find(&articleModels)
+	}
+	articleResponses := map[uint]*NotificationArticleResponse{}
+	for _, articleModel := range articleModels {
+		articleResponses[articleModel.ID] = &NotificationArticleResponse{Slug: articleModel.Slug, Title: articleModel.Title}
+	}
+
+	response := []NotificationResponse{}
+	for _, notification := range s.Notifications {
+		item := NotificationResponse{
+			ID:          notification.ID,
+			Type:        notification.Type,
+			Read:        notification.ReadAt != nil,
+			Actors:      []users.ProfileResponse{},
+			ActorsCount: notification.ActorsCount,
+			Article:     articleResponses[notification.ArticleID],
+			CommentID:   notification.CommentID,
+			CreatedAt:   notification.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+			UpdatedAt:   notification.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		}
+		for _, actorID := range actors[notification.ID] {
+			if profile, ok := profiles[actorID]; ok {
+				item.Actors = append(item.Actors, profile)
+			}
+		}
+		item.Message = message(item)
+		response = append(response, item)
+	}
+	return response
+}
+
+// "alice followed you", "alice and bob favorited "X"", "alice and 11 others commented on "X"",
+// "alice mentioned you in a comment on "X"".
+func message(notification NotificationResponse) string {
+	who := "Someone"
+	if len(notification.Actors) > 0 {
+		who = notification.Actors[0].Username
+	}
+	switch others := notification.ActorsCount - 1; {
+	case others == 1 && len(notification.Actors) > 1:
+		who += " and " + notification.Actors[1].Username
+	case others == 1:
+		who += " and 1 other"
+	case others > 1:
+		who += fmt.Sprintf(" and %d others", others)
+	}
+	title := "your article"
+	if notification.Article != nil {
+		title = fmt.Sprintf("%q", notification.Article.Title)
+	}
+	switch notification.Type {
+	case TypeFollow:
+		return who + " followed you"
+	case TypeFavorite:
+		return who + " favorited " + title
+	case TypeComment:
+		return who + " commented on " + title
+	case TypeMention:
+		if notification.CommentID != 0 {
+			return who + " mentioned you in a comment on " + title
+		}
+		return who + " mentioned you in " + title
+	}
+	return who
+}
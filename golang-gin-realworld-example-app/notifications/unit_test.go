@@ -0,0 +1,187 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/realtime"
+	"realworld-backend/users"
+)
+
+var test_db *gorm.DB
+
+type listResponse struct {
+	Notifications      []NotificationResponse `json:"notifications"`
+	NotificationsCount int                    `json:"notificationsCount"`
+	UnreadCount        int                    `json:"unreadCount"`
+}
+
+func newUser(name string) users.UserModel {
+	userModel := users.UserModel{Username: name, Email: name + "@notifications.test", PasswordHash: "x"}
+	test_db.Create(&userModel)
+	return userModel
+}
+
+func newRouter(current users.UserModel) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, current.ID)
+	})
+	NotificationsRegister(r.Group("/api/notifications"))
+	return r
+}
+
+func request(r *gin.Engine, method, url, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func list(r *gin.Engine, url string) listResponse {
+	var response listResponse
+	json.Unmarshal(request(r, "GET", url, "").Body.Bytes(), &response)
+	return response
+}
+
+func TestMessage(t *testing.T) {
+	asserts := assert.New(t)
+
+	alice := users.ProfileResponse{Username: "alice"}
+	bob := users.ProfileResponse{Username: "bob"}
+	article := &NotificationArticleResponse{Title: "Hello"}
+	var messageTests = []struct {
+		notification NotificationResponse
+		expected     string
+	}{
+		{NotificationResponse{Type: TypeFollow, Actors: []users.ProfileResponse{alice}, ActorsCount: 1}, "alice followed you"},
+		{NotificationResponse{Type: TypeFavorite, Actors: []users.ProfileResponse{alice, bob}, ActorsCount: 2, Article: article}, `alice and bob favorited "Hello"`},
+		{NotificationResponse{Type: TypeFavorite, Actors: []users.ProfileResponse{alice, bob}, ActorsCount: 12, Article: article}, `alice and 11 others favorited "Hello"`},
+		{NotificationResponse{Type: TypeComment, Actors: []users.ProfileResponse{alice}, ActorsCount: 2}, "alice and 1 other commented on your article"},
+		{NotificationResponse{Type: TypeMention, Actors: []users.ProfileResponse{alice}, ActorsCount: 1, Article: article, CommentID: 3}, `alice mentioned you in a comment on "Hello"`},
+	}
+	for _, testData := range messageTests {
+		asserts.Equal(testData.expected, message(testData.notification))
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	asserts := assert.New(t)
+
+	alice := newUser("alice")
+	bob := newUser("bob")
+	carol := newUser("carol")
+	dave := newUser("dave")
+	article := articles.ArticleModel{Title: "Hello", Slug: "hello", Author: articles.GetArticleUserModel(context.Background(), alice)}
+	articles.SaveOne(&article)
+
+	events.Publish(events.UserFollowed{FollowerID: bob.ID, FollowingID: alice.ID})
+	events.Publish(events.ArticleFavorited{ArticleID: article.ID, AuthorID: alice.ID, UserID: bob.ID})
+	events.Publish(events.ArticleFavorited{ArticleID: article.ID, AuthorID: alice.ID, UserID: carol.ID})
+	events.Publish(events.ArticleFavorited{ArticleID: article.ID, AuthorID: alice.ID, UserID: bob.ID})
+	events.Publish(events.ArticleFavorited{ArticleID: article.ID, AuthorID: alice.ID, UserID: alice.ID})
+	events.Publish(events.CommentCreated{CommentID: 7, ArticleID: article.ID, ArticleAuthorID: alice.ID, AuthorID: carol.ID})
+
+	r := newRouter(alice)
+	response := list(r, "/api/notifications/")
+	asserts.Equal(3, response.NotificationsCount, "favorites of an article should be grouped")
+	asserts.Equal(3, response.UnreadCount, "new notifications should be unread")
+	comment, favorite, follow := response.Notifications[0], response.Notifications[1], response.Notifications[2]
+	asserts.Equal(TypeComment, comment.Type, "latest notification should come first")
+	asserts.Equal(uint(7), comment.CommentID, "comment notification should point to the comment")
+	asserts.Equal(2, favorite.ActorsCount, "favoriting twice or your own article shouldn't count")
+	asserts.Equal("carol", favorite.Actors[0].Username, "latest actor should come first")
+	asserts.Equal(`carol and bob favorited "Hello"`, favorite.Message)
+	asserts.Equal("hello", favorite.Article.Slug, "favorite notification should link the article")
+	asserts.Equal("bob followed you", follow.Message)
+	asserts.Nil(follow.Article, "follow notification has no article")
+
+	w := request(r, "POST", "/api/notifications/"+strconv.FormatUint(uint64(favorite.ID), 10)+"/read", "")
+	asserts.Equal(http.StatusOK, w.Code, "notification should be marked read")
+	asserts.Equal(2, list(r, "/api/notifications/").UnreadCount, "read notification should not be unread")
+	asserts.Len(list(r, "/api/notifications/?unread=true").Notifications, 2, "unread filter should leave out read notifications")
+
+	events.Publish(events.ArticleFavorited{ArticleID: article.ID, AuthorID: alice.ID, UserID: dave.ID})
+	response = list(r, "/api/notifications/")
+	asserts.Equal(4, response.NotificationsCount, "favorite after reading should start a new group")
+	asserts.Equal(1, response.Notifications[0].ActorsCount, "new group should only have the new actor")
+
+	other := newRouter(bob)
+	w = request(other, "POST", "/api/notifications/"+strconv.FormatUint(uint64(favorite.ID), 10)+"/read", "")
+	asserts.Equal(http.StatusNotFound, w.Code, "notifications of others can't be read")
+	w = request(r, "POST", "/api/notifications/abc/read", "")
+	asserts.Equal(http.StatusNotFound, w.Code, "invalid id should return 404")
+
+	w = request(r, "POST", "/api/notifications/read", "")
+	asserts.Equal(http.StatusOK, w.Code, "all notifications should be marked read")
+	w = request(r, "GET", "/api/notifications/unread", "")
+	asserts.Equal(`{"unreadCount":0}`, w.Body.String(), "nothing should be unread")
+
+	w = request(r, "PUT", "/api/notifications/preferences", `{"preferences":{"favorite":false}}`)
+	asserts.Equal(http.StatusOK, w.Code, "preferences should be updated")
+	asserts.Equal(`{"preferences":{"comment":true,"favorite":false,"follow":true,"mention":true}}`, w.Body.String())
+	events.Publish(events.ArticleFavorited{ArticleID: article.ID, AuthorID: alice.ID, UserID: carol.ID})
+	asserts.Equal(0, list(r, "/api/notifications/").UnreadCount, "turned off types should not notify")
+	w = request(r, "PUT", "/api/notifications/preferences", `{"preferences":{"likes":false}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "unknown types should be refused")
+
+	events.Publish(events.UserMentioned{ArticleID: article.ID, CommentID: 3, AuthorID: bob.ID, UserID: alice.ID})
+	events.Publish(events.UserMentioned{ArticleID: article.ID, CommentID: 4, AuthorID: bob.ID, UserID: alice.ID})
+	response = list(r, "/api/notifications/?unread=true")
+	asserts.Equal(2, response.UnreadCount, "mentions should not be grouped")
+	asserts.Equal(`bob mentioned you in a comment on "Hello"`, response.Notifications[0].Message)
+	request(r, "POST", "/api/notifications/read", "")
+
+	test_db.Create(&users.MuteModel{MuterID: alice.ID, MutedID: dave.ID})
+	events.Publish(events.UserFollowed{FollowerID: dave.ID, FollowingID: alice.ID})
+	asserts.Equal(0, list(r, "/api/notifications/").UnreadCount, "muted users should not notify")
+	events.Publish(events.UserFollowed{FollowerID: carol.ID, FollowingID: alice.ID})
+	asserts.Equal(1, list(r, "/api/notifications/").UnreadCount, "others still notify")
+}
+
+func TestPush(t *testing.T) {
+	asserts := assert.New(t)
+
+	erin := newUser("erin")
+	frank := newUser("frank")
+	subscription, _ := realtime.Subscribe(realtime.UserTopic(erin.ID), "")
+	defer subscription.Close()
+
+	events.Publish(events.UserFollowed{FollowerID: frank.ID, FollowingID: erin.ID})
+	asserts.Len(subscription.C, 1, "new notifications should be pushed to their recipient")
+	message := <-subscription.C
+	asserts.Equal("notification", message.Event)
+	var pushed struct {
+		Notification NotificationResponse `json:"notification"`
+		UnreadCount  int                  `json:"unreadCount"`
+	}
+	asserts.NoError(json.Unmarshal(message.Data, &pushed))
+	asserts.Equal("frank followed you", pushed.Notification.Message, "the notification should be serialized")
+	asserts.Equal(1, pushed.UnreadCount, "the push should carry the unread count")
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	users.AutoMigrate()
+	events.AutoMigrate()
+	articles.AutoMigrateArticles(test_db)
+	AutoMigrate()
+	SubscribeEvents()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
@@ -0,0 +1,31 @@
+package notifications
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"realworld-backend/common"
+)
+
+// Only the types sent are changed, e.g. {"preferences": {"favorite": false}}.
+type PreferencesValidator struct {
+	Preferences map[string]bool `json:"preferences" binding:"required"`
+}
+
+func NewPreferencesValidator() PreferencesValidator {
+	return PreferencesValidator{}
+}
+
+func (s *PreferencesValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, s)
+}
+
+// Keys of the preferences must be notification types.
+func (s *PreferencesValidator) checkTypes() error {
+	for kind := range s.Preferences {
+		if !validType(kind) {
+			return errors.New("Unknown notification type " + kind)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+/*
+The ratelimit module containing token bucket rate limiting of the api, by user or client ip.
+
+policy.go: policies from RATE_LIMITS and the token bucket itself
+
+store.go: where buckets are kept, in memory or in the database shared by every instance
+
+middleware.go: the middlewares limiting route groups and the RateLimit-* headers
+
+models.go: definition of orm based data model of the database store
+*/
+package ratelimit
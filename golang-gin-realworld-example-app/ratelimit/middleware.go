@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/common"
+	"realworld-backend/metrics"
+)
+
+var errTooManyRequests = errors.New("Too many requests, try again later")
+
+// Middleware spending a token of the `name` policy for every request. Callers are told their
+// budget in the RateLimit-* headers, and get a 429 with Retry-After once it is spent.
+//
+// Behind users.AuthMiddleware requests count against the user, otherwise against the client ip.
+// Unknown and disabled policies let everything through.
+//
+//	users.UsersRegister(v1.Group("/users", ratelimit.Limit("auth")))
+func Limit(name string) gin.HandlerFunc {
+	return limit(name, false)
+}
+
+// Same as Limit, only for requests changing something: GET, HEAD and OPTIONS go through freely.
+func LimitWrites(name string) gin.HandlerFunc {
+	return limit(name, true)
+}
+
+func limit(name string, writesOnly bool) gin.HandlerFunc {
+	policy, ok := Policies()[name]
+	if !ok {
+		return func(c *gin.Context) {}
+	}
+	return func(c *gin.Context) {
+		if writesOnly && isSafe(c.Request.Method) {
+			return
+		}
+		result, err := getStore().Take(c.Request.Context(), name+":"+keyOf(c), policy)
+		if err != nil {
+			// A broken store should not take the api down with it.
+			slog.Error("ratelimit err: (Take)", "policy", name, "err", err)
+			return
+		}
+		header := c.Writer.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+		header.Set("RateLimit-Policy", strconv.Itoa(policy.Limit)+";w="+ceilSeconds(policy.Period))
+		if !result.Allowed {
+			metrics.RateLimited.WithLabelValues(name).Inc()
+			header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, common.NewError("rateLimit", errTooManyRequests))
+		}
+	}
+}
+
+// The user set by users.AuthMiddleware, or the client ip for anonymous requests.
+func keyOf(c *gin.Context) string {
+	if id := c.GetUint("my_user_id"); id != 0 {
+		return "user:" + strconv.FormatUint(uint64(id), 10)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func isSafe(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,23 @@
+package ratelimit
+
+import (
+	"time"
+
+	"realworld-backend/common"
+)
+
+// A bucket of the database store. Version changes on every update, so two instances taking a
+// token at once don't both win.
+type BucketModel struct {
+	Key       string `gorm:"primary_key;size:191"`
+	Tokens    float64
+	UpdatedAt time.Time
+	Version   int
+}
+
+// Migrate the model of the database store.
+func AutoMigrate() {
+	db := common.GetDB()
+
+	db.AutoMigrate(&BucketModel{})
+}
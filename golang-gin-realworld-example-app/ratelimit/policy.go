@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"realworld-backend/common"
+)
+
+// Callers get `Limit` requests at once, and the bucket refills at Limit per Period.
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// Policies from RATE_LIMITS: comma separated `name=limit/period`, they replace the defaults of the
+// same name. A limit of 0 turns the policy off.
+//
+//	RATE_LIMITS=auth=10/1m,write=30/1m,uploads=30/1h
+func Policies() map[string]Policy {
+	policies := map[string]Policy{}
+	for _, config := range []string{"auth=10/1m,write=30/1m,uploads=30/1h", common.Getenv("RATE_LIMITS", "")} {
+		for _, item := range strings.Split(config, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+			limit, period, _ := strings.Cut(value, "/")
+			n, err := strconv.Atoi(limit)
+			if name == "" || err != nil || n < 0 {
+				continue
+			}
+			if n == 0 {
+				delete(policies, name)
+				continue
+			}
+			d, err := time.ParseDuration(period)
+			if err != nil || d <= 0 {
+				continue
+			}
+			policies[name] = Policy{Name: name, Limit: n, Period: d}
+		}
+	}
+	return policies
+}
+
+// Outcome of taking a token. Reset is when the bucket is full again, RetryAfter when the next
+// token is available to a refused caller.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Tokens left in a bucket when it was last updated.
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Refill `b` for the time elapsed since its update and take a token if there is one. A new
+// bucket, with a zero UpdatedAt, starts full.
+func take(b bucket, policy Policy, now time.Time) (bucket, Result) {
+	perSecond := float64(policy.Limit) / policy.Period.Seconds()
+	tokens := float64(policy.Limit)
+	if !b.UpdatedAt.IsZero() {
+		tokens = math.Min(tokens, b.Tokens+now.Sub(b.UpdatedAt).Seconds()*perSecond)
+	}
+	result := Result{Limit: policy.Limit}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / perSecond)
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = seconds((float64(policy.Limit) - tokens) / perSecond)
+	return bucket{Tokens: tokens, UpdatedAt: now}, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"realworld-backend/common"
+)
+
+// Keeps the buckets. Take must refill the bucket of `key` and take a token from it atomically,
+// whatever the number of instances sharing the store.
+//
+// The MemoryStore is enough for a single instance, behind a load balancer use the DatabaseStore
+// or implement Store on top of Redis and pass it to SetStore.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+var (
+	errUnknownStore = errors.New("unknown RATE_LIMIT_STORE, expected memory or database")
+	errContention   = errors.New("bucket updated by others too many times")
+)
+
+var (
+	storeMutex sync.RWMutex
+	store      Store = NewMemoryStore()
+)
+
+// Pick the store named by RATE_LIMIT_STORE: "memory" (the default) or "database".
+func Init() error {
+	switch common.Getenv("RATE_LIMIT_STORE", "memory") {
+	case "memory":
+		SetStore(NewMemoryStore())
+	case "database":
+		SetStore(DatabaseStore{})
+	default:
+		return errUnknownStore
+	}
+	return nil
+}
+
+// Use `s` for the buckets, call once at startup.
+func SetStore(s Store) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	store = s
+}
+
+func getStore() Store {
+	storeMutex.RLock()
+	defer storeMutex.RUnlock()
+	return store
+}
+
+// Buckets of this process, forgotten once full again.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]bucket
+	periods   map[string]time.Duration
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]bucket{}, periods: map[string]time.Duration{}, now: time.Now}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy) (Result, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	b, result := take(s.buckets[key], policy, now)
+	s.buckets[key] = b
+	s.periods[key] = policy.Period
+	s.prune(now)
+	return result, nil
+}
+
+// Forget the buckets untouched for a whole period, they are full again. At most once a minute.
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+	for key, b := range s.buckets {
+		if now.Sub(b.UpdatedAt) > s.periods[key] {
+			delete(s.buckets, key)
+			delete(s.periods, key)
+		}
+	}
+}
+
+// Buckets in the bucket_models table, shared by the instances using the same database. A bucket
+// is updated only if nobody else did since it was read, and read again otherwise.
+type DatabaseStore struct{}
+
+func (DatabaseStore) Take(ctx context.Context, key string, policy Policy) (Result, error) {
+	db := common.GetDBContext(ctx)
+	for i := 0; i < 5; i++ {
+		var model BucketModel
+		found := db.Where("key = ?", key).First(&model).Error == nil
+		b, result := take(bucket{Tokens: model.Tokens, UpdatedAt: model.UpdatedAt}, policy, time.Now())
+		if !found {
+			model = BucketModel{Key: key, Tokens: b.Tokens, UpdatedAt: b.UpdatedAt, Version: 1}
+			// Fails when another instance created the bucket first, it is then read again.
+			if db.Create(&model).Error == nil {
+				return result, nil
+			}
+			continue
+		}
+		update := db.Model(&BucketModel{}).Where("key = ? AND version = ?", key, model.Version).
+			Updates(map[string]interface{}{"tokens": b.Tokens, "updated_at": b.UpdatedAt, "version": model.Version + 1})
+		if update.Error != nil {
+			return Result{}, update.Error
+		}
+		if update.RowsAffected == 1 {
+			return result, nil
+		}
+	}
+	return Result{}, errContention
+}
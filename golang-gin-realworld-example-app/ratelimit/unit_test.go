@@ -0,0 +1,196 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+)
+
+var test_db *gorm.DB
+
+func TestPolicies(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Equal(Policy{Name: "auth", Limit: 10, Period: time.Minute}, Policies()["auth"])
+
+	os.Setenv("RATE_LIMITS", "auth=5/10s, uploads=0,search=100/1m,broken=x/1m,bad=3/soon,=1/1s")
+	defer os.Unsetenv("RATE_LIMITS")
+	policies := Policies()
+	asserts.Equal(Policy{Name: "auth", Limit: 5, Period: 10 * time.Second}, policies["auth"], "configured policies should replace the defaults")
+	asserts.Equal(Policy{Name: "write", Limit: 30, Period: time.Minute}, policies["write"], "other defaults should be kept")
+	asserts.Equal(Policy{Name: "search", Limit: 100, Period: time.Minute}, policies["search"])
+	asserts.NotContains(policies, "uploads", "a limit of 0 should turn the policy off")
+	asserts.Len(policies, 3)
+}
+
+func TestTake(t *testing.T) {
+	asserts := assert.New(t)
+
+	policy := Policy{Name: "test", Limit: 2, Period: 10 * time.Second}
+	now := time.Now()
+	b, result := take(bucket{}, policy, now)
+	asserts.Equal(Result{Allowed: true, Limit: 2, Remaining: 1, Reset: 5 * time.Second}, result, "a new bucket should start full")
+	b, result = take(b, policy, now)
+	asserts.True(result.Allowed)
+	asserts.Equal(0, result.Remaining)
+	b, result = take(b, policy, now.Add(time.Second))
+	asserts.False(result.Allowed, "an empty bucket should refuse")
+	asserts.Equal(4*time.Second, result.RetryAfter, "the next token comes after period/limit")
+	asserts.InDelta(0.2, b.Tokens, 0.0001, "refused requests should not spend the refill")
+
+	b, result = take(b, policy, now.Add(5*time.Second))
+	asserts.True(result.Allowed, "the bucket should refill over time")
+	_, result = take(b, policy, now.Add(time.Hour))
+	asserts.Equal(1, result.Remaining, "the bucket should not refill past its limit")
+}
+
+func TestMemoryStore(t *testing.T) {
+	asserts := assert.New(t)
+
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	policy := Policy{Name: "test", Limit: 1, Period: time.Minute}
+	result, _ := store.Take(context.Background(), "a", policy)
+	asserts.True(result.Allowed)
+	result, _ = store.Take(context.Background(), "a", policy)
+	asserts.False(result.Allowed)
+	result, _ = store.Take(context.Background(), "b", policy)
+	asserts.True(result.Allowed, "buckets should be kept by key")
+
+	now = now.Add(2 * time.Minute)
+	store.Take(context.Background(), "b", policy)
+	asserts.NotContains(store.buckets, "a", "full buckets should be forgotten")
+	asserts.Contains(store.buckets, "b")
+}
+
+func TestDatabaseStore(t *testing.T) {
+	asserts := assert.New(t)
+
+	test_db.Delete(&BucketModel{})
+	store := DatabaseStore{}
+	policy := Policy{Name: "test", Limit: 5, Period: time.Hour}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	allowed := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := store.Take(context.Background(), "shared", policy)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err == nil && result.Allowed {
+				allowed++
+			}
+		}()
+	}
+	wg.Wait()
+	asserts.LessOrEqual(allowed, 5, "concurrent takes should not spend more than the bucket holds")
+
+	result, err := store.Take(context.Background(), "other", policy)
+	asserts.NoError(err)
+	asserts.Equal(4, result.Remaining)
+	var model BucketModel
+	asserts.NoError(test_db.Where("key = ?", "other").First(&model).Error)
+	asserts.InDelta(4, model.Tokens, 0.01)
+}
+
+func newRouter(middleware gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if c.GetHeader("X-User") == "1" {
+			c.Set("my_user_id", uint(1))
+		}
+	})
+	r.Use(middleware)
+	r.Any("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	return r
+}
+
+func TestLimit(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Setenv("RATE_LIMITS", "test=2/1m")
+	defer os.Unsetenv("RATE_LIMITS")
+	SetStore(NewMemoryStore())
+	r := newRouter(Limit("test"))
+	request := func(user string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.Header.Set("X-User", user)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal("2", w.Header().Get("RateLimit-Limit"))
+	asserts.Equal("1", w.Header().Get("RateLimit-Remaining"))
+	asserts.Equal("30", w.Header().Get("RateLimit-Reset"))
+	asserts.Equal("2;w=60", w.Header().Get("RateLimit-Policy"))
+	request("")
+	w = request("")
+	asserts.Equal(http.StatusTooManyRequests, w.Code)
+	asserts.Equal("30", w.Header().Get("Retry-After"))
+	asserts.Contains(w.Body.String(), `"rateLimit"`)
+
+	asserts.Equal(http.StatusOK, request("1").Code, "users should not share the bucket of their ip")
+}
+
+func TestLimitWrites(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Setenv("RATE_LIMITS", "test=1/1m")
+	defer os.Unsetenv("RATE_LIMITS")
+	SetStore(NewMemoryStore())
+	r := newRouter(LimitWrites("test"))
+	for _, method := range []string{"GET", "GET", "POST", "PUT"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/", nil)
+		r.ServeHTTP(w, req)
+		if method == "PUT" {
+			asserts.Equal(http.StatusTooManyRequests, w.Code, "writes should share the budget")
+		} else {
+			asserts.Equal(http.StatusOK, w.Code, method)
+		}
+	}
+
+	r = newRouter(Limit("missing"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/", nil)
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code, "unknown policies should not limit")
+	asserts.Empty(w.Header().Get("RateLimit-Limit"))
+}
+
+func TestInit(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Setenv("RATE_LIMIT_STORE", "database")
+	asserts.NoError(Init())
+	asserts.Equal(DatabaseStore{}, getStore())
+	os.Setenv("RATE_LIMIT_STORE", "redis")
+	asserts.ErrorIs(Init(), errUnknownStore)
+	os.Unsetenv("RATE_LIMIT_STORE")
+	asserts.NoError(Init())
+	asserts.IsType(&MemoryStore{}, getStore())
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	AutoMigrate()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
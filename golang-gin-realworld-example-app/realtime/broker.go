@@ -0,0 +1,26 @@
+package realtime
+
+// Carries messages between the instances of the api. Publish must hand every message to the
+// `receive` func of every instance, the publishing one included, and keep their order.
+//
+// The LocalBroker is enough for a single instance, behind a load balancer implement Broker on
+// top of Redis pub/sub, NATS or Postgres LISTEN/NOTIFY and pass it to SetBroker.
+type Broker interface {
+	Start(receive func(Message)) error
+	Publish(message Message) error
+}
+
+// Hands messages straight back to the hub of this process.
+type LocalBroker struct {
+	receive func(Message)
+}
+
+func (b *LocalBroker) Start(receive func(Message)) error {
+	b.receive = receive
+	return nil
+}
+
+func (b *LocalBroker) Publish(message Message) error {
+	b.receive(message)
+	return nil
+}
@@ -0,0 +1,9 @@
+/*
+The realtime module containing the pub/sub hub that pushes messages to connected clients, such as
+the Server-Sent Events stream of an article.
+
+hub.go: topics, subscriptions and the history replayed on reconnection
+
+broker.go: how messages travel between instances of the api
+*/
+package realtime
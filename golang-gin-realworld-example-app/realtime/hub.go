@@ -0,0 +1,202 @@
+package realtime
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"realworld-backend/common"
+)
+
+type Message struct {
+	ID    string          `json:"id"`
+	Topic string          `json:"topic"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+	// The user the message is about, such as the author of a comment, for streams to leave it out
+	// for subscribers who blocked or muted them. Zero when it is about nobody, never sent to clients.
+	UserID uint `json:"userId,omitempty"`
+}
+
+// Messages a subscriber can fall behind before it is dropped, it then reconnects with the id of
+// the last message it got and catches up from the history.
+const subscriptionBuffer = 32
+
+type Subscription struct {
+	C     chan Message
+	hub   *Hub
+	topic string
+}
+
+// Stop receiving messages, C is closed. Safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+	s.hub.remove(s)
+}
+
+type topic struct {
+	recent      []Message
+	lastMessage time.Time
+	subscribers map[*Subscription]bool
+}
+
+// Topics are created on first use and forgotten once idle for historyTTL without subscribers.
+type Hub struct {
+	mutex      sync.Mutex
+	broker     Broker
+	topics     map[string]*topic
+	history    int
+	historyTTL time.Duration
+	sequence   uint64
+	lastPrune  time.Time
+	closed     bool
+}
+
+func NewHub(broker Broker, history int, historyTTL time.Duration) *Hub {
+	hub := &Hub{broker: broker, topics: map[string]*topic{}, history: history, historyTTL: historyTTL}
+	broker.Start(hub.receive)
+	return hub
+}
+
+var defaultHub = NewHub(&LocalBroker{}, common.GetenvInt("REALTIME_HISTORY", 100), common.GetenvDuration("REALTIME_HISTORY_TTL", 10*time.Minute))
+
+// Use `broker` to share messages with the other instances, call once at startup.
+func SetBroker(broker Broker) {
+	defaultHub = NewHub(broker, defaultHub.history, defaultHub.historyTTL)
+}
+
+// Send `data` as JSON to the subscribers of `topic`, on every instance.
+//
+//	err := realtime.Publish("articles/12", "comment.created", gin.H{"comment": comment})
+func Publish(topicName, event string, data interface{}) error {
+	return defaultHub.Publish(topicName, event, data)
+}
+
+// Like Publish for a message about what `userID` did, see Message.UserID.
+//
+//	err := realtime.PublishFrom("articles/12", "comment.created", authorID, gin.H{"comment": comment})
+func PublishFrom(topicName, event string, userID uint, data interface{}) error {
+	return defaultHub.PublishFrom(topicName, event, userID, data)
+}
+
+// See Hub.Subscribe.
+func Subscribe(topicName, lastEventID string) (*Subscription, []Message) {
+	return defaultHub.Subscribe(topicName, lastEventID)
+}
+
+// See Hub.Close.
+func Close() {
+	defaultHub.Close()
+}
+
+func (h *Hub) Publish(topicName, event string, data interface{}) error {
+	return h.PublishFrom(topicName, event, 0, data)
+}
+
+func (h *Hub) PublishFrom(topicName, event string, userID uint, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	h.sequence++
+	id := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(h.sequence, 36)
+	h.mutex.Unlock()
+	return h.broker.Publish(Message{ID: id, Topic: topicName, Event: event, Data: body, UserID: userID})
+}
+
+// Messages of `topic` from now on. When `lastEventID` is set, the messages published after it
+// are returned to be sent first, all the history is when it's too old to be found.
+func (h *Hub) Subscribe(topicName, lastEventID string) (*Subscription, []Message) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		subscription := &Subscription{C: make(chan Message), hub: h, topic: topicName}
+		close(subscription.C)
+		return subscription, []Message{}
+	}
+	t := h.topic(topicName)
+	backlog := []Message{}
+	if lastEventID != "" {
+		start := 0
+		for i, message := range t.recent {
+			if message.ID == lastEventID {
+				start = i + 1
+			}
+		}
+		backlog = append(backlog, t.recent[start:]...)
+	}
+	subscription := &Subscription{C: make(chan Message, subscriptionBuffer), hub: h, topic: topicName}
+	t.subscribers[subscription] = true
+	return subscription, backlog
+}
+
+// Close every subscription on shutdown so streams end, later subscriptions are closed right away.
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.closed = true
+	for _, t := range h.topics {
+		for subscription := range t.subscribers {
+			h.remove(subscription)
+		}
+	}
+}
+
+func (h *Hub) topic(name string) *topic {
+	t, ok := h.topics[name]
+	if !ok {
+		t = &topic{subscribers: map[*Subscription]bool{}}
+		h.topics[name] = t
+	}
+	return t
+}
+
+// Called by the broker for every message, of any instance.
+func (h *Hub) receive(message Message) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	t := h.topic(message.Topic)
+	t.recent = append(t.recent, message)
+	if len(t.recent) > h.history {
+		t.recent = t.recent[len(t.recent)-h.history:]
+	}
+	t.lastMessage = time.Now()
+	for subscription := range t.subscribers {
+		select {
+		case subscription.C <- message:
+		default:
+			h.remove(subscription)
+		}
+	}
+	h.prune()
+}
+
+func (h *Hub) remove(subscription *Subscription) {
+	t, ok := h.topics[subscription.topic]
+	if !ok || !t.subscribers[subscription] {
+		return
+	}
+	delete(t.subscribers, subscription)
+	close(subscription.C)
+}
+
+// Forget idle topics, at most once a minute.
+func (h *Hub) prune() {
+	if time.Since(h.lastPrune) < time.Minute {
+		return
+	}
+	h.lastPrune = time.Now()
+	for name, t := range h.topics {
+		if len(t.subscribers) == 0 && time.Since(t.lastMessage) > h.historyTTL {
+			delete(h.topics, name)
+		}
+	}
+}
+
+// Topic of the messages pushed to one user, over the WebSocket gateway.
+func UserTopic(userID uint) string {
+	return "users/" + strconv.FormatUint(uint64(userID), 10)
+}
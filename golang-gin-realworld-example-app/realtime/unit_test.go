@@ -0,0 +1,75 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Records what it carries, like a broker shared with other instances would.
+type recordingBroker struct {
+	LocalBroker
+	published []Message
+}
+
+func (b *recordingBroker) Publish(message Message) error {
+	b.published = append(b.published, message)
+	return b.LocalBroker.Publish(message)
+}
+
+func TestHub(t *testing.T) {
+	asserts := assert.New(t)
+
+	broker := &recordingBroker{}
+	hub := NewHub(broker, 3, time.Minute)
+
+	subscription, backlog := hub.Subscribe("articles/1", "")
+	asserts.Empty(backlog, "new subscribers have no backlog")
+	other, _ := hub.Subscribe("articles/2", "")
+
+	asserts.NoError(hub.Publish("articles/1", "comment.created", map[string]int{"id": 1}))
+	message := <-subscription.C
+	asserts.Equal("comment.created", message.Event, "subscribers should get the messages of their topic")
+	asserts.JSONEq(`{"id":1}`, string(message.Data), "data should be sent as json")
+	asserts.Len(broker.published, 1, "messages should go through the broker")
+	asserts.Len(other.C, 0, "subscribers should not get other topics")
+
+	for i := 2; i <= 5; i++ {
+		hub.Publish("articles/1", "comment.created", map[string]int{"id": i})
+	}
+	subscription.Close()
+	subscription.Close()
+
+	_, backlog = hub.Subscribe("articles/1", broker.published[2].ID)
+	asserts.Len(backlog, 2, "messages after the last event id should be replayed")
+	asserts.Equal(broker.published[3].ID, backlog[0].ID, "replay should start after the last event id")
+	_, backlog = hub.Subscribe("articles/1", "unknown")
+	asserts.Len(backlog, 3, "the whole history should be replayed for unknown ids")
+
+	slow, _ := hub.Subscribe("articles/3", "")
+	for i := 0; i <= subscriptionBuffer; i++ {
+		hub.Publish("articles/3", "favorites.changed", i)
+	}
+	received := 0
+	for range slow.C {
+		received++
+	}
+	asserts.Equal(subscriptionBuffer, received, "slow subscribers should be dropped once their buffer is full")
+}
+
+func TestHubClose(t *testing.T) {
+	asserts := assert.New(t)
+
+	hub := NewHub(&LocalBroker{}, 3, time.Minute)
+	subscription, _ := hub.Subscribe("articles/1", "")
+	hub.Close()
+	_, ok := <-subscription.C
+	asserts.False(ok, "closing the hub should end its subscriptions")
+	subscription.Close()
+
+	late, backlog := hub.Subscribe("articles/1", "")
+	_, ok = <-late.C
+	asserts.False(ok, "subscriptions after closing should be closed right away")
+	asserts.Empty(backlog)
+}
@@ -0,0 +1,8 @@
+/*
+The seo module containing what search engines crawl: the sitemap and robots.txt.
+
+routers.go: router binding, sitemap pagination and core logic
+
+serializers.go: definition of the sitemap xml documents
+*/
+package seo
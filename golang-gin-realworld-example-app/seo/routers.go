@@ -0,0 +1,126 @@
+package seo
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// Mounted at the root, the frontend proxies these paths so crawlers find them on SITE_URL.
+func SitemapRegister(router *gin.RouterGroup) {
+	router.GET("/robots.txt", RobotsTxt)
+	router.GET("/sitemap.xml", Sitemap)
+	router.GET("/sitemaps/:page", SitemapPage)
+}
+
+// Search engines read at most 50,000 urls per sitemap, bigger sites are split in pages listed by an index.
+var sitemapPageSize = 50000
+
+// Pages of the frontend that are private or only forms.
+var disallowedPaths = []string{"/editor", "/settings", "/login", "/register"}
+
+func RobotsTxt(c *gin.Context) {
+	var robots strings.Builder
+	robots.WriteString("User-agent: *\n")
+	for _, path := range disallowedPaths {
+		fmt.Fprintf(&robots, "Disallow: %v\n", path)
+	}
+	fmt.Fprintf(&robots, "\nSitemap: %v\n", common.SiteURL("/sitemap.xml"))
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.String(http.StatusOK, robots.String())
+}
+
+// The urls of the site are numbered in a fixed order: the home page, every article, then every profile.
+// A page of the sitemap is a window over that sequence.
+func sitemapURLs(offset, limit, articleCount int) ([]SitemapURL, error) {
+	urls := []SitemapURL{}
+	if offset == 0 {
+		urls = append(urls, SitemapURL{Loc: common.SiteURL("/")})
+		limit--
+	} else {
+		offset--
+	}
+
+	if offset < articleCount {
+		articleModels, err := articles.FindArticleSlugs(offset, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, article := range articleModels {
+			urls = append(urls, SitemapURL{
+				Loc:     common.SiteURL("/article/" + article.Slug),
+				LastMod: article.UpdatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+		limit -= len(articleModels)
+		offset = 0
+	} else {
+		offset -= articleCount
+	}
+
+	if limit > 0 {
+		usernames, err := users.FindUsernames(offset, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, username := range usernames {
+			urls = append(urls, SitemapURL{Loc: common.SiteURL("/@" + username)})
+		}
+	}
+	return urls, nil
+}
+
+func Sitemap(c *gin.Context) {
+	articleCount := articles.CountArticles()
+	total := 1 + articleCount + users.CountUsers()
+	if total <= sitemapPageSize {
+		urls, err := sitemapURLs(0, sitemapPageSize, articleCount)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, common.NewError("sitemap", err))
+			return
+		}
+		renderXML(c, newURLSet(urls))
+		return
+	}
+
+	pages := (total + sitemapPageSize - 1) / sitemapPageSize
+	sitemaps := make([]SitemapURL, 0, pages)
+	for page := 1; page <= pages; page++ {
+		sitemaps = append(sitemaps, SitemapURL{Loc: common.SiteURL(fmt.Sprintf("/sitemaps/%d.xml", page))})
+	}
+	renderXML(c, newSitemapIndex(sitemaps))
+}
+
+// One page of a sitemap index, "/sitemaps/2.xml" lists urls 50,000 to 99,999.
+func SitemapPage(c *gin.Context) {
+	articleCount := articles.CountArticles()
+	total := 1 + articleCount + users.CountUsers()
+	page, err := strconv.Atoi(strings.TrimSuffix(c.Param("page"), ".xml"))
+	if err != nil || page < 1 || (page-1)*sitemapPageSize >= total {
+		c.JSON(http.StatusNotFound, common.NewError("sitemap", errors.New("Invalid page")))
+		return
+	}
+	urls, err := sitemapURLs((page-1)*sitemapPageSize, sitemapPageSize, articleCount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewError("sitemap", err))
+		return
+	}
+	renderXML(c, newURLSet(urls))
+}
+
+func renderXML(c *gin.Context, body interface{}) {
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Status(http.StatusOK)
+	c.Writer.WriteString(xml.Header)
+	xml.NewEncoder(c.Writer).Encode(body)
+}
@@ -0,0 +1,34 @@
+package seo
+
+import (
+	"encoding/xml"
+)
+
+// https://www.sitemaps.org/protocol.html
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type URLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []SitemapURL `xml:"url"`
+}
+
+type SitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Listed by /sitemap.xml instead of the urls themselves once they don't fit in one sitemap.
+type SitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Sitemaps []SitemapURL `xml:"sitemap"`
+}
+
+func newURLSet(urls []SitemapURL) URLSet {
+	return URLSet{XMLNS: sitemapNamespace, URLs: urls}
+}
+
+func newSitemapIndex(sitemaps []SitemapURL) SitemapIndex {
+	return SitemapIndex{XMLNS: sitemapNamespace, Sitemaps: sitemaps}
+}
@@ -0,0 +1,125 @@
+package seo
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/users"
+)
+
+var test_db *gorm.DB
+
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	SitemapRegister(r.Group(""))
+	return r
+}
+
+func get(r *gin.Engine, url string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func locs(urls []SitemapURL) []string {
+	result := []string{}
+	for _, url := range urls {
+		result = append(result, url.Loc)
+	}
+	return result
+}
+
+func TestRobotsTxt(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Setenv("SITE_URL", "https://conduit.test")
+	defer os.Unsetenv("SITE_URL")
+
+	w := get(newRouter(), "/robots.txt")
+	asserts.Equal(http.StatusOK, w.Code, "robots.txt should be served")
+	asserts.Contains(w.Body.String(), "User-agent: *\n", "robots.txt should apply to every crawler")
+	asserts.Contains(w.Body.String(), "Disallow: /settings\n", "private pages should be disallowed")
+	asserts.Contains(w.Body.String(), "Sitemap: https://conduit.test/sitemap.xml\n", "robots.txt should point to the sitemap")
+}
+
+func TestSitemap(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Setenv("SITE_URL", "https://conduit.test")
+	defer os.Unsetenv("SITE_URL")
+
+	for i := 0; i < 2; i++ {
+		user := users.UserModel{Username: fmt.Sprintf("writer%v", i), Email: fmt.Sprintf("writer%v@seo.test", i), PasswordHash: "x"}
+		test_db.Create(&user)
+		article := articles.ArticleModel{Title: fmt.Sprintf("Post %v", i), Slug: fmt.Sprintf("post-%v", i), Body: "body", Author: articles.GetArticleUserModel(context.Background(), user)}
+		articles.SaveOne(&article)
+	}
+	r := newRouter()
+	expected := []string{
+		"https://conduit.test/",
+		"https://conduit.test/article/post-0",
+		"https://conduit.test/article/post-1",
+		"https://conduit.test/@writer0",
+		"https://conduit.test/@writer1",
+	}
+
+	w := get(r, "/sitemap.xml")
+	asserts.Equal(http.StatusOK, w.Code, "sitemap should be served")
+	asserts.Equal("application/xml; charset=utf-8", w.Header().Get("Content-Type"), "sitemap should be xml")
+	var urlSet URLSet
+	asserts.NoError(xml.Unmarshal(w.Body.Bytes(), &urlSet), "sitemap should be valid xml")
+	asserts.Equal(sitemapNamespace, urlSet.XMLNS, "sitemap should declare its namespace")
+	asserts.Equal(expected, locs(urlSet.URLs), "small sites should be listed in one sitemap")
+	asserts.NotEmpty(urlSet.URLs[1].LastMod, "articles should have a last modification time")
+
+	sitemapPageSize = 2
+	defer func() { sitemapPageSize = 50000 }()
+
+	w = get(r, "/sitemap.xml")
+	var index SitemapIndex
+	asserts.NoError(xml.Unmarshal(w.Body.Bytes(), &index), "sitemap index should be valid xml")
+	asserts.Equal([]string{
+		"https://conduit.test/sitemaps/1.xml",
+		"https://conduit.test/sitemaps/2.xml",
+		"https://conduit.test/sitemaps/3.xml",
+	}, locs(index.Sitemaps), "big sites should be split in pages")
+
+	var paged []string
+	for page := 1; page <= 3; page++ {
+		w = get(r, fmt.Sprintf("/sitemaps/%v.xml", page))
+		asserts.Equal(http.StatusOK, w.Code, "sitemap page should be served")
+		urlSet = URLSet{}
+		xml.Unmarshal(w.Body.Bytes(), &urlSet)
+		asserts.LessOrEqual(len(urlSet.URLs), sitemapPageSize, "pages should not exceed the page size")
+		paged = append(paged, locs(urlSet.URLs)...)
+	}
+	asserts.Equal(expected, paged, "pages should list every url once")
+
+	for _, page := range []string{"0.xml", "4.xml", "abc.xml"} {
+		w = get(r, "/sitemaps/"+page)
+		asserts.Equal(http.StatusNotFound, w.Code, "page "+page+" should not exist")
+	}
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	users.AutoMigrate()
+	events.AutoMigrate()
+	articles.AutoMigrateArticles(test_db)
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
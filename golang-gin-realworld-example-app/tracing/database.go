@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"realworld-backend/common"
+)
+
+const spanKey = "tracing:span"
+
+// Names of the gorm dialects in the semantic conventions.
+var systemNames = map[string]string{"sqlite3": "sqlite", "postgres": "postgresql", "mysql": "mysql", "mssql": "microsoft.sql_server"}
+
+// Record a span for every query of `db`. Queries run with a traced context from
+// common.GetDBContext, such as the Related calls listing articles, are children of the request.
+// The others, from background workers for instance, are recorded as root spans. Call once at
+// startup with the database of common.Init.
+func InstrumentDB(db *gorm.DB) {
+	callback := db.Callback()
+	callback.Create().Before("gorm:create").Register("tracing:before_create", start("create"))
+	callback.Create().After("gorm:create").Register("tracing:after_create", end("create"))
+	callback.Query().Before("gorm:query").Register("tracing:before_query", start("query"))
+	callback.Query().After("gorm:query").Register("tracing:after_query", end("query"))
+	callback.Update().Before("gorm:update").Register("tracing:before_update", start("update"))
+	callback.Update().After("gorm:update").Register("tracing:after_update", end("update"))
+	callback.Delete().Before("gorm:delete").Register("tracing:before_delete", start("delete"))
+	callback.Delete().After("gorm:delete").Register("tracing:after_delete", end("delete"))
+	callback.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", start("row_query"))
+	callback.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", end("row_query"))
+}
+
+func start(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		ctx := context.Background()
+		if value, ok := scope.Get(common.DBContextKey); ok {
+			if c, ok := value.(context.Context); ok && c != nil {
+				ctx = c
+			}
+		}
+		table := ""
+		if scope.Value != nil {
+			table = scope.TableName()
+		}
+		name := "gorm." + operation
+		if table != "" {
+			name += " " + table
+		}
+		_, span := tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemNameKey.String(systemNames[scope.Dialect().GetName()]),
+				semconv.DBOperationName(operation),
+				semconv.DBCollectionName(table),
+			),
+		)
+		scope.Set(spanKey, span)
+	}
+}
+
+func end(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		value, ok := scope.Get(spanKey)
+		if !ok {
+			return
+		}
+		span := value.(trace.Span)
+		defer span.End()
+		// The statement has placeholders, the values bound to them are left out.
+		span.SetAttributes(semconv.DBQueryText(scope.SQL))
+		if operation == "query" {
+			span.SetAttributes(semconv.DBResponseReturnedRows(int(scope.DB().RowsAffected)))
+		}
+		if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+}
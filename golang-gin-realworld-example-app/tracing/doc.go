@@ -0,0 +1,10 @@
+/*
+The tracing module containing the OpenTelemetry spans of requests and database queries.
+
+tracing.go: tracer provider, exporters and W3C trace context propagation
+
+middleware.go: a span for every request, continuing the trace of the caller
+
+database.go: a span for every GORM query, a child of the request when run with its context
+*/
+package tracing
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"realworld-backend/common"
+)
+
+const instrumentationName = "realworld-backend"
+
+var errUnknownExporter = errors.New("unknown OTEL_TRACES_EXPORTER, expected otlp, stdout or none")
+
+var provider *sdktrace.TracerProvider
+
+// Exporter named by OTEL_TRACES_EXPORTER: "otlp" sends spans over http to OTEL_EXPORTER_OTLP_ENDPOINT
+// (http://localhost:4318 by default), "stdout" prints them, "none" (the default) turns tracing off
+// and returns a nil exporter.
+func NewExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	switch common.Getenv("OTEL_TRACES_EXPORTER", "none") {
+	case "otlp":
+		return otlptracehttp.New(ctx)
+	case "stdout", "console":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case "none":
+		return nil, nil
+	default:
+		return nil, errUnknownExporter
+	}
+}
+
+// Send the spans to `exporter`, call once at startup. The W3C traceparent of callers is followed
+// even when `exporter` is nil and nothing is recorded. Tests pass a tracetest.InMemoryExporter.
+//
+//	exporter, err := tracing.NewExporter(ctx)
+//	tracing.Init(exporter)
+//	defer tracing.Shutdown(ctx)
+func Init(exporter sdktrace.SpanExporter) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if exporter == nil {
+		return
+	}
+	serviceName := resource.NewSchemaless(semconv.ServiceName(common.Getenv("OTEL_SERVICE_NAME", instrumentationName)))
+	res, err := resource.Merge(resource.Default(), serviceName)
+	if err != nil {
+		res = serviceName
+	}
+	// The sampler follows OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG, every trace by default.
+	provider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+}
+
+// Send the spans still buffered and stop exporting, on shutdown.
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
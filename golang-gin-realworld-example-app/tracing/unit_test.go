@@ -0,0 +1,195 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+var test_db *gorm.DB
+
+var exporter = tracetest.NewInMemoryExporter()
+
+type probeModel struct {
+	gorm.Model
+	Name string
+}
+
+func spans() tracetest.SpanStubs {
+	provider.ForceFlush(context.Background())
+	defer exporter.Reset()
+	return exporter.GetSpans()
+}
+
+func attributeOf(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/api/probes/:name", func(c *gin.Context) {
+		var models []probeModel
+		common.GetDBContext(c.Request.Context()).Where(&probeModel{Name: c.Param("name")}).Find(&models)
+		c.JSON(http.StatusOK, gin.H{"count": len(models)})
+	})
+	r.GET("/api/failing", func(c *gin.Context) {
+		c.Status(http.StatusBadGateway)
+	})
+	return r
+}
+
+func TestMiddleware(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := newRouter()
+	req, _ := http.NewRequest("GET", "/api/probes/alice", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	recorded := spans()
+	asserts.Len(recorded, 2, "the request and its query should be traced")
+	query, request := recorded[0], recorded[1]
+	asserts.Equal("GET /api/probes/:name", request.Name, "spans should be named after the route template")
+	asserts.Equal("4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext.TraceID().String(), "the trace of the caller should be continued")
+	asserts.Equal("00f067aa0ba902b7", request.Parent.SpanID().String())
+	asserts.Equal(int64(200), attributeOf(request, "http.response.status_code").AsInt64())
+	asserts.Equal("/api/probes/alice", attributeOf(request, "url.path").AsString())
+
+	asserts.Equal("gorm.query probe_models", query.Name)
+	asserts.Equal(request.SpanContext.SpanID(), query.Parent.SpanID(), "queries should be children of the request")
+	asserts.Equal("sqlite", attributeOf(query, "db.system.name").AsString())
+	asserts.Contains(attributeOf(query, "db.query.text").AsString(), "probe_models")
+	asserts.NotContains(attributeOf(query, "db.query.text").AsString(), "alice", "bound values should be left out")
+
+	req, _ = http.NewRequest("GET", "/api/failing", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	recorded = spans()
+	asserts.Len(recorded, 1)
+	asserts.False(recorded[0].Parent.IsValid(), "requests without traceparent should start a trace")
+	asserts.Equal(codes.Error, recorded[0].Status.Code, "server errors should mark the span")
+}
+
+func TestInstrumentDB(t *testing.T) {
+	asserts := assert.New(t)
+
+	test_db.Create(&probeModel{Name: "untraced"})
+	recorded := spans()
+	asserts.Len(recorded, 1, "queries without a request context should be traced too")
+	asserts.Equal("gorm.create probe_models", recorded[0].Name)
+	asserts.False(recorded[0].Parent.IsValid(), "queries without a request context should be root spans")
+
+	ctx, span := tracer().Start(context.Background(), "job")
+	db := common.GetDBContext(ctx)
+	model := probeModel{Name: "traced"}
+	db.Create(&model)
+	tx := db.Begin()
+	tx.Model(&model).Update("name", "renamed")
+	tx.Commit()
+	var count int
+	db.Model(&probeModel{}).Count(&count)
+	db.Exec("SELECT 1")
+	db.Delete(&model)
+	span.End()
+
+	var names []string
+	for _, recorded := range spans() {
+		names = append(names, recorded.Name)
+	}
+	asserts.Equal([]string{"gorm.create probe_models", "gorm.update probe_models", "gorm.row_query probe_models", "gorm.delete probe_models", "job"}, names,
+		"every operation should be traced, transactions keep the context")
+
+	db.Where("id = ?", 999999).First(&probeModel{})
+	asserts.Equal(codes.Unset, spans()[0].Status.Code, "a record not found is not an error")
+}
+
+func TestSerializerSpans(t *testing.T) {
+	asserts := assert.New(t)
+
+	userModel := users.UserModel{Username: "spanauthor", Email: "spanauthor@example.com"}
+	test_db.Create(&userModel)
+	author := articles.ArticleUserModel{UserModel: userModel, UserModelID: userModel.ID}
+	test_db.Create(&author)
+	test_db.Create(&articles.ArticleModel{Slug: "traced-article", Title: "Traced article", Body: "body", Author: author, AuthorID: author.ID})
+	spans()
+
+	r := gin.New()
+	r.Use(Middleware())
+	r.Use(users.AuthMiddleware(false))
+	articles.ArticlesAnonymousRegister(r.Group("/api/articles"))
+	req, _ := http.NewRequest("GET", "/api/articles/", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+
+	recorded := spans()
+	request := recorded[len(recorded)-1]
+	asserts.Equal("GET /api/articles/", request.Name)
+	children := map[string]bool{}
+	for _, span := range recorded[:len(recorded)-1] {
+		asserts.Equal(request.SpanContext.SpanID(), span.Parent.SpanID(), "%v should be a child of the request", span.Name)
+		children[span.Name] = true
+	}
+	asserts.True(children["gorm.query favorite_models"], "whether the article is favorited should be traced")
+	asserts.True(children["gorm.row_query favorite_models"], "the favorites count should be traced")
+	asserts.True(children["gorm.query user_models"], "the mentions should be traced")
+}
+
+func TestProfileSpans(t *testing.T) {
+	asserts := assert.New(t)
+
+	userModel := users.UserModel{Username: "spanprofile", Email: "spanprofile@example.com"}
+	test_db.Create(&userModel)
+	spans()
+
+	r := gin.New()
+	r.Use(Middleware())
+	r.Use(users.AuthMiddleware(false))
+	users.ProfileRegister(r.Group("/api/profiles"))
+	req, _ := http.NewRequest("GET", "/api/profiles/spanprofile", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+
+	recorded := spans()
+	request := recorded[len(recorded)-1]
+	asserts.Equal("GET /api/profiles/:username", request.Name)
+	names := map[string]bool{}
+	for _, span := range recorded[:len(recorded)-1] {
+		asserts.Equal(request.SpanContext.SpanID(), span.Parent.SpanID(), "%v should be a child of the request", span.Name)
+		names[span.Name] = true
+	}
+	asserts.True(names["gorm.query user_models"], "finding the user should be traced")
+	asserts.True(names["gorm.query mute_models"], "whether the user is muted should be traced")
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	test_db.AutoMigrate(&probeModel{})
+	users.AutoMigrate()
+	articles.AutoMigrateArticles(test_db)
+	Init(exporter)
+	InstrumentDB(test_db)
+	exitVal := m.Run()
+	Shutdown(context.Background())
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
@@ -0,0 +1,18 @@
+/*
+The uploads module containing media uploads and the storage backends behind them.
+
+models.go: definition of orm based data model
+
+routers.go: router binding and core logic
+
+serializers.go: definition the schema of return data
+
+validators.go: definition the validator of form data, including the magic bytes checks
+
+storage.go: the Storage interface with local filesystem and S3 compatible backends
+
+metadata.go: EXIF and other metadata stripping
+
+images.go, pipeline.go: thumbnail variants of avatars and covers, generated by a background worker
+*/
+package uploads
@@ -0,0 +1,136 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	"realworld-backend/common"
+)
+
+// Thumbnail widths of each preset, avatars are cropped to squares and covers keep their aspect ratio.
+// Override them with a comma separated list in IMAGE_AVATAR_SIZES or IMAGE_COVER_SIZES.
+var defaultPresetSizes = map[string]string{
+	"avatar": "64,128",
+	"cover":  "400,1200",
+}
+
+var errUnknownPreset = errors.New("unknown image preset")
+
+func presetSizes(preset string) []int {
+	fallback, ok := defaultPresetSizes[preset]
+	if !ok {
+		return nil
+	}
+	var sizes []int
+	for _, field := range strings.Split(common.Getenv("IMAGE_"+strings.ToUpper(preset)+"_SIZES", fallback), ",") {
+		if size, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+// Remote images are fetched through this client, so a profile image url can't be used to probe
+// internal services.
+var fetchClient = common.NewPublicClient(10 * time.Second)
+
+// Read the original bytes of an image, from the storage for our own uploads and over http otherwise.
+func loadSource(ctx context.Context, sourceURL string) ([]byte, error) {
+	maxBytes := maxUploadBytes()
+	var body io.ReadCloser
+	if prefix := storage.URL(""); strings.HasPrefix(sourceURL, prefix) {
+		file, err := storage.Open(ctx, strings.TrimPrefix(sourceURL, prefix))
+		if err != nil {
+			return nil, err
+		}
+		body = file
+	} else if strings.HasPrefix(sourceURL, "http://") || strings.HasPrefix(sourceURL, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := fetchClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("fetching %v: %v", sourceURL, res.Status)
+		}
+		body = res.Body
+	} else {
+		return nil, errUnsupportedType
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errFileTooLarge
+	}
+	if _, ok := allowedTypes[http.DetectContentType(data)]; !ok {
+		return nil, errUnsupportedType
+	}
+	return data, nil
+}
+
+func decodeImage(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, errImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errUnsupportedType
+	}
+	return img, nil
+}
+
+// Scale `src` down to `size` pixels wide, cropping the centered square first when `square` is set.
+// Images are never scaled up and transparent areas are flattened on white for JPEG.
+func resizeImage(src image.Image, size int, square bool) *image.RGBA {
+	bounds := src.Bounds()
+	if square {
+		side := bounds.Dx()
+		if bounds.Dy() < side {
+			side = bounds.Dy()
+		}
+		x := bounds.Min.X + (bounds.Dx()-side)/2
+		y := bounds.Min.Y + (bounds.Dy()-side)/2
+		bounds = image.Rect(x, y, x+side, y+side)
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size {
+		height = max(1, height*size/width)
+		width = size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
+
+// IMAGE_VARIANT_FORMAT picks "jpeg" (default) or "webp", WebP variants are lossless.
+func encodeVariant(w io.Writer, img image.Image) (contentType, ext string, err error) {
+	if common.Getenv("IMAGE_VARIANT_FORMAT", "jpeg") == "webp" {
+		return "image/webp", ".webp", nativewebp.Encode(w, img, nil)
+	}
+	return "image/jpeg", ".jpg", jpeg.Encode(w, img, &jpeg.Options{Quality: 82})
+}
@@ -0,0 +1,109 @@
+package uploads
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+)
+
+// One uploaded file, the bytes themselves live in the Storage under Key.
+type UploadModel struct {
+	gorm.Model
+	Key         string `gorm:"unique_index"`
+	ContentType string
+	Size        int64
+	Width       int
+	Height      int
+	OwnerID     uint `gorm:"index"` // users.UserModel id of the uploader
+}
+
+// A resized copy of an image referenced by url (an avatar or a cover), generated by the variant pipeline.
+type ImageVariantModel struct {
+	gorm.Model
+	SourceURL   string `gorm:"index;size:1024"`
+	Preset      string // "avatar" or "cover"
+	Size        int    // the preset size, the image itself is smaller when the original was
+	Width       int
+	Height      int
+	Key         string
+	ContentType string
+}
+
+// Migrate the schema of database if needed
+func AutoMigrate() {
+	db := common.GetDB()
+
+	db.AutoMigrate(&UploadModel{})
+	db.AutoMigrate(&ImageVariantModel{})
+}
+
+func SaveOne(data interface{}) error {
+	db := common.GetDB()
+	err := db.Save(data).Error
+	return err
+}
+
+func FindOneUpload(condition interface{}) (UploadModel, error) {
+	db := common.GetDB()
+	var model UploadModel
+	err := db.Where(condition).First(&model).Error
+	return model, err
+}
+
+// Urls of the variants of an image keyed by preset size, nil until the pipeline has processed it.
+//
+//	variants := uploads.FindVariants(ctx, *userModel.Image, "avatar") // {"64": "/uploads/variants/.../avatar-64.jpg"}
+func FindVariants(ctx context.Context, sourceURL, preset string) map[string]string {
+	if sourceURL == "" {
+		return nil
+	}
+	return FindVariantsOf(ctx, preset, []string{sourceURL})[sourceURL]
+}
+
+// Variants of several images keyed by source url, an image without any is missing.
+type Variants map[string]map[string]string
+
+// Load the variants of all the images of a page in one query, never nil so a serializer can tell
+// loaded from not loaded.
+//
+//	avatars := uploads.FindVariantsOf(ctx, "avatar", imageURLs)
+//	avatars[*userModel.Image] // {"64": "/uploads/variants/.../avatar-64.jpg"}
+func FindVariantsOf(ctx context.Context, preset string, sourceURLs []string) Variants {
+	variants := Variants{}
+	if len(sourceURLs) == 0 || storage == nil {
+		return variants
+	}
+	db := common.GetDBContext(ctx)
+	var models []ImageVariantModel
+	db.Where("preset = ? AND source_url IN (?)", preset, sourceURLs).Order("size").Find(&models)
+	for _, model := range models {
+		if variants[model.SourceURL] == nil {
+			variants[model.SourceURL] = map[string]string{}
+		}
+		variants[model.SourceURL][strconv.Itoa(model.Size)] = storage.URL(model.Key)
+	}
+	return variants
+}
+
+// Replace the variants of an image in one transaction so readers never see a partial set.
+func saveVariants(sourceURL, preset string, models []ImageVariantModel) error {
+	db := common.GetDB()
+	tx := db.Begin()
+	tx.Unscoped().Where(ImageVariantModel{SourceURL: sourceURL, Preset: preset}).Delete(ImageVariantModel{})
+	for i := range models {
+		if err := tx.Create(&models[i]).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+func hasVariants(sourceURL, preset string) bool {
+	db := common.GetDB()
+	var count int
+	db.Model(&ImageVariantModel{}).Where(ImageVariantModel{SourceURL: sourceURL, Preset: preset}).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,108 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+)
+
+// Pull the image at `sourceURL` into the storage and generate the thumbnails of `preset`,
+// keys are derived from the url so processing the same image again overwrites its variants.
+//
+//	err := uploads.GenerateVariants(ctx, "https://golang.org/doc/gopher/frontpage.png", "avatar")
+func GenerateVariants(ctx context.Context, sourceURL, preset string) error {
+	sizes := presetSizes(preset)
+	if len(sizes) == 0 {
+		return errUnknownPreset
+	}
+	data, err := loadSource(ctx, sourceURL)
+	if err != nil {
+		return err
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return err
+	}
+
+	hash := sha1.Sum([]byte(sourceURL))
+	prefix := "variants/" + hex.EncodeToString(hash[:10]) + "/" + preset + "-"
+	var models []ImageVariantModel
+	for _, size := range sizes {
+		thumbnail := resizeImage(img, size, preset == "avatar")
+		var buf bytes.Buffer
+		contentType, ext, err := encodeVariant(&buf, thumbnail)
+		if err != nil {
+			return err
+		}
+		key := prefix + strconv.Itoa(size) + ext
+		if err := storage.Put(ctx, key, &buf, int64(buf.Len()), contentType); err != nil {
+			return err
+		}
+		models = append(models, ImageVariantModel{
+			SourceURL:   sourceURL,
+			Preset:      preset,
+			Size:        size,
+			Width:       thumbnail.Bounds().Dx(),
+			Height:      thumbnail.Bounds().Dy(),
+			Key:         key,
+			ContentType: contentType,
+		})
+	}
+	return saveVariants(sourceURL, preset, models)
+}
+
+type variantRequest struct {
+	SourceURL string
+	Preset    string
+}
+
+var variantQueue = make(chan variantRequest, 256)
+
+// Ask the worker to (re)generate the variants of an image after it was set on a profile or article.
+// Requests are dropped when the queue is full, BackfillVariants picks them up on the next start.
+func EnqueueVariants(sourceURL, preset string) {
+	if sourceURL == "" {
+		return
+	}
+	select {
+	case variantQueue <- variantRequest{sourceURL, preset}:
+	default:
+	}
+}
+
+// Process queued requests until ctx is done, started once from main.
+func RunVariantWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case request := <-variantQueue:
+			if err := GenerateVariants(ctx, request.SourceURL, request.Preset); err != nil {
+				slog.Error("uploads err: (variants)", "url", request.SourceURL, "err", err)
+			}
+		}
+	}
+}
+
+// Generate the missing variants of images that were set before the pipeline existed, or whose
+// request was dropped. Returns how many images were processed successfully.
+func BackfillVariants(ctx context.Context, preset string, sourceURLs []string) int {
+	processed := 0
+	for _, sourceURL := range sourceURLs {
+		if ctx.Err() != nil {
+			break
+		}
+		if hasVariants(sourceURL, preset) {
+			continue
+		}
+		if err := GenerateVariants(ctx, sourceURL, preset); err != nil {
+			slog.Error("uploads err: (backfill)", "url", sourceURL, "err", err)
+			continue
+		}
+		processed++
+	}
+	return processed
+}
@@ -0,0 +1,73 @@
+package uploads
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"realworld-backend/common"
+)
+
+func UploadsRegister(router *gin.RouterGroup) {
+	router.POST("/", UploadCreate)
+}
+
+// Serves the files of the local storage backend, S3 compatible backends serve their own urls.
+func FilesRegister(router *gin.RouterGroup) {
+	router.GET("/*key", FileRetrieve)
+}
+
+func UploadCreate(c *gin.Context) {
+	// Leave room for the multipart envelope around the file itself.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBytes()+1<<20)
+	uploadValidator := NewUploadValidator()
+	if err := uploadValidator.Bind(c); err != nil {
+		var validationErrors validator.ValidationErrors
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &validationErrors):
+			c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(validationErrors))
+		case errors.Is(err, errFileTooLarge), errors.As(err, &maxBytesError):
+			c.JSON(http.StatusRequestEntityTooLarge, common.NewError("file", errFileTooLarge))
+		default:
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("file", err))
+		}
+		return
+	}
+
+	uploadModel := uploadValidator.uploadModel
+	err := GetStorage().Put(c.Request.Context(), uploadModel.Key, bytes.NewReader(uploadValidator.data), uploadModel.Size, uploadModel.ContentType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewError("storage", err))
+		return
+	}
+	if err := SaveOne(&uploadModel); err != nil {
+		GetStorage().Delete(c.Request.Context(), uploadModel.Key)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := UploadSerializer{c, uploadModel}
+	c.JSON(http.StatusCreated, gin.H{"upload": serializer.Response()})
+}
+
+func FileRetrieve(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	file, err := GetStorage().Open(c.Request.Context(), key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("file", ErrNotFound))
+		return
+	}
+	defer file.Close()
+	// Keys are never reused, so files can be cached forever and embedded by the frontend's origin.
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("Cross-Origin-Resource-Policy", "cross-origin")
+	c.Header("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, file)
+}
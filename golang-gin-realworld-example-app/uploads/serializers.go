@@ -0,0 +1,28 @@
+package uploads
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type UploadSerializer struct {
+	C *gin.Context
+	UploadModel
+}
+
+type UploadResponse struct {
+	URL         string `json:"url"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+func (s *UploadSerializer) Response() UploadResponse {
+	return UploadResponse{
+		URL:         GetStorage().URL(s.Key),
+		ContentType: s.ContentType,
+		Size:        s.Size,
+		Width:       s.Width,
+		Height:      s.Height,
+	}
+}
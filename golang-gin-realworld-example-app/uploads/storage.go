@@ -0,0 +1,207 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"realworld-backend/common"
+)
+
+// Storage hides where uploaded files live, keys look like "2025/01/aB3dE5gH7jK9mN1p.png".
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Public url of the key, relative to this server for the local backend.
+	URL(key string) string
+}
+
+var ErrNotFound = errors.New("file not found")
+
+var storage Storage
+
+// Choose the backend from the environment:
+//
+//	UPLOAD_STORAGE=local  UPLOAD_DIR=./../uploads  UPLOAD_BASE_URL=/uploads
+//	UPLOAD_STORAGE=s3     S3_ENDPOINT S3_BUCKET S3_REGION S3_ACCESS_KEY S3_SECRET_KEY S3_USE_SSL S3_PUBLIC_URL
+func Init() (Storage, error) {
+	var err error
+	switch backend := common.Getenv("UPLOAD_STORAGE", "local"); backend {
+	case "local":
+		storage, err = NewLocalStorage(common.Getenv("UPLOAD_DIR", "./../uploads"), common.Getenv("UPLOAD_BASE_URL", "/uploads"))
+	case "s3":
+		storage, err = NewS3Storage(S3Config{
+			Endpoint:  common.Getenv("S3_ENDPOINT", "localhost:9000"),
+			Bucket:    common.Getenv("S3_BUCKET", "uploads"),
+			Region:    common.Getenv("S3_REGION", "us-east-1"),
+			AccessKey: common.Getenv("S3_ACCESS_KEY", ""),
+			SecretKey: common.Getenv("S3_SECRET_KEY", ""),
+			UseSSL:    common.GetenvBool("S3_USE_SSL", true),
+			PublicURL: common.Getenv("S3_PUBLIC_URL", ""),
+		})
+	default:
+		err = errors.New("unknown UPLOAD_STORAGE " + backend)
+	}
+	return storage, err
+}
+
+// Swap the storage backend, tests use it to point uploads at a temporary directory.
+func SetStorage(s Storage) {
+	storage = s
+}
+
+func GetStorage() Storage {
+	return storage
+}
+
+// Files under a directory on the local disk, served by FilesRegister.
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalStorage(dir, baseURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{Dir: dir, BaseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+// Resolve a key inside Dir, keys trying to climb out of it are rejected.
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see half a file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
+
+type S3Config struct {
+	Endpoint  string // host[:port] of AWS S3, MinIO or any compatible service
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	// Base url the objects are publicly reachable at, defaults to the path-style bucket url.
+	PublicURL string
+}
+
+// Objects in a bucket of an S3 compatible service, addressed path-style so MinIO works out of the box.
+type S3Storage struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure:       config.UseSSL,
+		Region:       config.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	publicURL := config.PublicURL
+	if publicURL == "" {
+		scheme := "http"
+		if config.UseSSL {
+			scheme = "https"
+		}
+		publicURL = scheme + "://" + config.Endpoint + "/" + config.Bucket
+	}
+	return &S3Storage{client: client, bucket: config.Bucket, publicURL: strings.TrimRight(publicURL, "/")}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, body, size, minio.PutObjectOptions{
+		ContentType:  contentType,
+		CacheControl: "public, max-age=31536000, immutable",
+	})
+	return err
+}
+
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, Stat makes a missing key fail here instead of on the first Read.
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Storage) URL(key string) string {
+	return s.publicURL + "/" + (&url.URL{Path: key}).EscapedPath()
+}
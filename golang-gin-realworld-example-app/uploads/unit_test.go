@@ -0,0 +1,409 @@
+package uploads
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+)
+
+var test_db *gorm.DB
+
+func newTestImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for x := 0; x < 8; x++ {
+		img.Set(x, x%6, color.RGBA{255, 0, 0, 255})
+	}
+	return img
+}
+
+func encodePNG() []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, newTestImage())
+	return buf.Bytes()
+}
+
+// Insert a tEXt chunk holding `text` right after IHDR.
+func pngWithText(text string) []byte {
+	data := encodePNG()
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(data[8:]))
+	chunk := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunk, uint32(len(text)))
+	chunk = append(chunk, "tEXt"+text+"\x00\x00\x00\x00"...)
+	return append(append(append([]byte{}, data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+// Insert an APP1 EXIF segment holding `text` right after SOI.
+func jpegWithExif(text string) []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, newTestImage(), nil)
+	data := buf.Bytes()
+	payload := "Exif\x00\x00" + text
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func webpChunk(fourcc string, payload []byte) []byte {
+	chunk := []byte(fourcc)
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func TestStripMetadata(t *testing.T) {
+	asserts := assert.New(t)
+
+	stripped, err := stripMetadata("image/png", pngWithText("GPS 51.5N"))
+	asserts.NoError(err, "png should be stripped")
+	asserts.NotContains(string(stripped), "GPS 51.5N", "png text chunk should be removed")
+	_, err = png.Decode(bytes.NewReader(stripped))
+	asserts.NoError(err, "stripped png should still decode")
+
+	stripped, err = stripMetadata("image/jpeg", jpegWithExif("Canon EOS"))
+	asserts.NoError(err, "jpeg should be stripped")
+	asserts.NotContains(string(stripped), "Canon EOS", "jpeg exif segment should be removed")
+	_, err = jpeg.Decode(bytes.NewReader(stripped))
+	asserts.NoError(err, "stripped jpeg should still decode")
+
+	var body []byte
+	body = append(body, webpChunk("VP8X", []byte{0x08 | 0x04, 0, 0, 0, 7, 0, 0, 5, 0, 0})...)
+	body = append(body, webpChunk("VP8L", []byte{0x2f, 1, 2})...)
+	body = append(body, webpChunk("EXIF", []byte("Nikon"))...)
+	body = append(body, webpChunk("XMP ", []byte("<x:xmpmeta/>"))...)
+	webp := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)+4))...)
+	webp = append(append(webp, "WEBP"...), body...)
+	stripped, err = stripMetadata("image/webp", webp)
+	asserts.NoError(err, "webp should be stripped")
+	asserts.NotContains(string(stripped), "Nikon", "webp exif chunk should be removed")
+	asserts.NotContains(string(stripped), "xmpmeta", "webp xmp chunk should be removed")
+	asserts.Equal(byte(0), stripped[20]&(0x08|0x04), "vp8x metadata flags should be cleared")
+	asserts.Equal(uint32(len(stripped)-8), binary.LittleEndian.Uint32(stripped[4:]), "riff size should be updated")
+
+	_, err = stripMetadata("image/jpeg", []byte("not a jpeg"))
+	asserts.Error(err, "corrupt image should return error")
+}
+
+func TestLocalStorage(t *testing.T) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	storage, err := NewLocalStorage(dir, "/uploads/")
+	asserts.NoError(err, "local storage should be created")
+
+	err = storage.Put(ctx, "2025/01/a.png", bytes.NewReader([]byte("hello")), 5, "image/png")
+	asserts.NoError(err, "put should succeed")
+	asserts.FileExists(filepath.Join(dir, "2025", "01", "a.png"), "file should be written under the dir")
+	asserts.Equal("/uploads/2025/01/a.png", storage.URL("2025/01/a.png"), "url should join base url and key")
+
+	file, err := storage.Open(ctx, "2025/01/a.png")
+	asserts.NoError(err, "open should succeed")
+	content, _ := io.ReadAll(file)
+	file.Close()
+	asserts.Equal("hello", string(content), "content should round trip")
+
+	err = storage.Put(ctx, "../../escape.png", bytes.NewReader([]byte("x")), 1, "image/png")
+	asserts.NoError(err, "put should succeed")
+	asserts.FileExists(filepath.Join(dir, "escape.png"), "keys should not escape the dir")
+
+	asserts.NoError(storage.Delete(ctx, "2025/01/a.png"), "delete should succeed")
+	_, err = storage.Open(ctx, "2025/01/a.png")
+	asserts.Equal(ErrNotFound, err, "deleted file should not be found")
+	asserts.NoError(storage.Delete(ctx, "2025/01/a.png"), "deleting twice should not fail")
+}
+
+// A minimal stand-in for MinIO: path-style PUT, GET, HEAD and DELETE of objects kept in memory.
+type s3StandIn struct {
+	sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key := r.URL.Path
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") ||
+			strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			body = decodeAWSChunked(body)
+		}
+		s.objects[key] = body
+		s.types[key] = r.Header.Get("Content-Type")
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		body, ok := s.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message><Key>%v</Key></Error>`, key)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", s.types[key])
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Undo the "size;chunk-signature=...\r\n data \r\n" framing of streaming signed uploads.
+func decodeAWSChunked(body []byte) []byte {
+	var out []byte
+	reader := bufio.NewReader(bytes.NewReader(body))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return out
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if err != nil || size == 0 {
+			return out
+		}
+		chunk := make([]byte, size)
+		io.ReadFull(reader, chunk)
+		out = append(out, chunk...)
+		reader.ReadString('\n')
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+
+	standIn := &s3StandIn{objects: map[string][]byte{}, types: map[string]string{}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	storage, err := NewS3Storage(S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Bucket:    "media",
+		Region:    "us-east-1",
+		AccessKey: "minio",
+		SecretKey: "minio123",
+	})
+	asserts.NoError(err, "s3 storage should be created")
+
+	data := encodePNG()
+	err = storage.Put(ctx, "2025/01/b.png", bytes.NewReader(data), int64(len(data)), "image/png")
+	asserts.NoError(err, "put should succeed")
+	asserts.Equal(data, standIn.objects["/media/2025/01/b.png"], "object should be stored path-style")
+	asserts.Equal("image/png", standIn.types["/media/2025/01/b.png"], "content type should be kept")
+
+	file, err := storage.Open(ctx, "2025/01/b.png")
+	asserts.NoError(err, "open should succeed")
+	if err == nil {
+		content, _ := io.ReadAll(file)
+		file.Close()
+		asserts.Equal(data, content, "content should round trip")
+	}
+
+	_, err = storage.Open(ctx, "2025/01/missing.png")
+	asserts.Error(err, "missing object should return error")
+
+	asserts.NoError(storage.Delete(ctx, "2025/01/b.png"), "delete should succeed")
+	asserts.NotContains(standIn.objects, "/media/2025/01/b.png", "object should be removed")
+	asserts.Equal(server.URL+"/media/a%20b.png", storage.URL("a b.png"), "url should be escaped")
+}
+
+func newMultipartRequest(filename string, content []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", filename)
+	part.Write(content)
+	writer.Close()
+	req, _ := http.NewRequest("POST", "/api/uploads/", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestUploadCreate(t *testing.T) {
+	asserts := assert.New(t)
+
+	dir := t.TempDir()
+	storage, _ := NewLocalStorage(dir, "/uploads")
+	SetStorage(storage)
+
+	// The users package depends on this one, so the auth middleware is stubbed.
+	myUserID := uint(7)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(func(c *gin.Context) {
+		c.Set("my_user_id", myUserID)
+	})
+	UploadsRegister(api.Group("/uploads"))
+	FilesRegister(r.Group("/uploads"))
+
+	req := newMultipartRequest("evil.txt", pngWithText("secret location"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusCreated, w.Code, "png should be uploaded whatever its name")
+	asserts.Regexp(`{"upload":{"url":"/uploads/\d{4}/\d{2}/[a-zA-Z0-9]{16}\.png","contentType":"image/png","size":\d+,"width":8,"height":6}}`, w.Body.String(), "response should describe the upload")
+
+	var upload UploadModel
+	test_db.Where(UploadModel{OwnerID: myUserID}).First(&upload)
+	asserts.NotZero(upload.ID, "upload should be saved")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/uploads/"+upload.Key, nil))
+	asserts.Equal(http.StatusOK, w.Code, "file should be served")
+	asserts.Equal("image/png", w.Header().Get("Content-Type"), "file should have its content type")
+	asserts.NotContains(w.Body.String(), "secret location", "served file should have no metadata")
+
+	req = newMultipartRequest("image.png", []byte("just some text pretending to be an image"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "non images should be rejected")
+	asserts.Equal(`{"errors":{"file":"unsupported file type"}}`, w.Body.String(), "error should explain why")
+
+	os.Setenv("UPLOAD_MAX_BYTES", "64")
+	defer os.Unsetenv("UPLOAD_MAX_BYTES")
+	req = newMultipartRequest("image.png", encodePNG())
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusRequestEntityTooLarge, w.Code, "too large files should be rejected")
+}
+
+func encodeLargePNG(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.RGBA{0, 0, 255, 255})
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestResizeImage(t *testing.T) {
+	asserts := assert.New(t)
+
+	small := newTestImage()
+	asserts.Equal(image.Rect(0, 0, 6, 6), resizeImage(small, 64, true).Bounds(), "avatars should be square and not upscaled")
+	asserts.Equal(image.Rect(0, 0, 8, 6), resizeImage(small, 400, false).Bounds(), "covers should not be upscaled")
+
+	large := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	asserts.Equal(image.Rect(0, 0, 64, 64), resizeImage(large, 64, true).Bounds(), "avatars should be cropped and scaled")
+	asserts.Equal(image.Rect(0, 0, 400, 200), resizeImage(large, 400, false).Bounds(), "covers should keep their aspect ratio")
+}
+
+func TestGenerateVariants(t *testing.T) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+
+	storage, _ := NewLocalStorage(t.TempDir(), "/uploads")
+	SetStorage(storage)
+
+	// An upload of our own is read back from the storage.
+	original := encodeLargePNG(300, 200)
+	storage.Put(ctx, "2025/01/avatar.png", bytes.NewReader(original), int64(len(original)), "image/png")
+	sourceURL := storage.URL("2025/01/avatar.png")
+	asserts.Nil(FindVariants(context.Background(), sourceURL, "avatar"), "variants should not exist before processing")
+
+	asserts.NoError(GenerateVariants(ctx, sourceURL, "avatar"), "variants should be generated")
+	variants := FindVariants(context.Background(), sourceURL, "avatar")
+	asserts.Len(variants, 2, "every avatar size should have a variant")
+	asserts.Regexp(`^/uploads/variants/[0-9a-f]{20}/avatar-64\.jpg$`, variants["64"], "variant url should point into the storage")
+
+	file, err := storage.Open(ctx, strings.TrimPrefix(variants["128"], "/uploads/"))
+	asserts.NoError(err, "variant should be stored")
+	thumbnail, err := jpeg.Decode(file)
+	file.Close()
+	asserts.NoError(err, "variant should be a jpeg")
+	asserts.Equal(image.Rect(0, 0, 128, 128), thumbnail.Bounds(), "avatar variant should be a square of its size")
+
+	// Generating again replaces the variants instead of adding more.
+	asserts.NoError(GenerateVariants(ctx, sourceURL, "avatar"), "variants should be regenerated")
+	var count int
+	test_db.Model(&ImageVariantModel{}).Where(ImageVariantModel{SourceURL: sourceURL}).Count(&count)
+	asserts.Equal(2, count, "regenerating should replace variants")
+
+	os.Setenv("IMAGE_VARIANT_FORMAT", "webp")
+	os.Setenv("IMAGE_COVER_SIZES", "100")
+	asserts.NoError(GenerateVariants(ctx, sourceURL, "cover"), "webp variants should be generated")
+	os.Unsetenv("IMAGE_VARIANT_FORMAT")
+	os.Unsetenv("IMAGE_COVER_SIZES")
+	variants = FindVariants(context.Background(), sourceURL, "cover")
+	asserts.Len(variants, 1, "configured cover sizes should be used")
+	asserts.True(strings.HasSuffix(variants["100"], ".webp"), "configured format should be used")
+
+	asserts.Equal(errUnknownPreset, GenerateVariants(ctx, sourceURL, "banner"), "unknown presets should be refused")
+}
+
+func TestRemoteVariantsAndBackfill(t *testing.T) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+
+	storage, _ := NewLocalStorage(t.TempDir(), "/uploads")
+	SetStorage(storage)
+
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/text" {
+			w.Write([]byte("hello"))
+			return
+		}
+		w.Write(encodeLargePNG(200, 200))
+	}))
+	defer remote.Close()
+
+	err := GenerateVariants(ctx, remote.URL+"/avatar.png", "avatar")
+	asserts.Error(err, "private addresses should not be fetched")
+
+	defaultClient := fetchClient
+	fetchClient = remote.Client()
+	defer func() { fetchClient = defaultClient }()
+
+	sources := []string{remote.URL + "/a.png", remote.URL + "/b.png", remote.URL + "/text"}
+	asserts.NoError(GenerateVariants(ctx, sources[0], "avatar"), "remote image should be pulled")
+	asserts.Len(FindVariants(context.Background(), sources[0], "avatar"), 2, "remote image should have variants")
+
+	asserts.Equal(1, BackfillVariants(ctx, "avatar", sources), "backfill should only process images without variants")
+	asserts.Len(FindVariants(context.Background(), sources[1], "avatar"), 2, "backfilled image should have variants")
+	asserts.Nil(FindVariants(context.Background(), sources[2], "avatar"), "non images should be skipped")
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	AutoMigrate()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
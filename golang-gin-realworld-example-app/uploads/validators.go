@@ -0,0 +1,106 @@
+package uploads
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "golang.org/x/image/webp"
+	"realworld-backend/common"
+)
+
+// Accepted formats and their file extension, the type is sniffed from the leading bytes of the file,
+// the file name and the Content-Type the client sent are ignored.
+var allowedTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Larger images are refused before anything tries to decode them.
+const maxPixels = 40 * 1000 * 1000
+
+var (
+	errFileTooLarge    = errors.New("file is too large")
+	errUnsupportedType = errors.New("unsupported file type")
+	errImageTooLarge   = errors.New("image dimensions are too large")
+)
+
+// UPLOAD_MAX_BYTES, 5MB by default.
+func maxUploadBytes() int64 {
+	return int64(common.GetenvInt("UPLOAD_MAX_BYTES", 5<<20))
+}
+
+type UploadValidator struct {
+	File        *multipart.FileHeader `form:"file" binding:"required"`
+	uploadModel UploadModel           `json:"-"`
+	data        []byte
+}
+
+func NewUploadValidator() UploadValidator {
+	return UploadValidator{}
+}
+
+// Besides the form binding, the file is read, sniffed, size checked and stripped of its metadata here.
+// Errors other than validator.ValidationErrors are one of the errors declared above.
+func (s *UploadValidator) Bind(c *gin.Context) error {
+	myUserID := c.MustGet("my_user_id").(uint)
+
+	err := common.Bind(c, s)
+	if err != nil {
+		return err
+	}
+	maxBytes := maxUploadBytes()
+	if s.File.Size > maxBytes {
+		return errFileTooLarge
+	}
+	file, err := s.File.Open()
+	if err != nil {
+		return errUnsupportedType
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		return errUnsupportedType
+	}
+	if int64(len(data)) > maxBytes {
+		return errFileTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := allowedTypes[contentType]
+	if !ok {
+		return errUnsupportedType
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return errUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return errImageTooLarge
+	}
+	data, err = stripMetadata(contentType, data)
+	if err != nil {
+		return errUnsupportedType
+	}
+
+	s.data = data
+	s.uploadModel = UploadModel{
+		Key:         time.Now().UTC().Format("2006/01/") + common.RandString(16) + ext,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       config.Width,
+		Height:      config.Height,
+		OwnerID:     myUserID,
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package users
 
 import (
+	"errors"
 	"net/http"
 	"realworld-backend/common"
 	"strings"
@@ -22,8 +23,8 @@ func stripBearerPrefixFromTokenString(tok string) (string, error) {
 // Extract  token from Authorization header
 // Uses PostExtractionFilter to strip "TOKEN " prefix from header
 var AuthorizationHeaderExtractor = &request.PostExtractionFilter{
-	request.HeaderExtractor{"Authorization"},
-	stripBearerPrefixFromTokenString,
+	Extractor: request.HeaderExtractor{"Authorization"},
+	Filter:    stripBearerPrefixFromTokenString,
 }
 
 // Extractor for OAuth2 access tokens.  Looks in 'Authorization'
@@ -37,11 +38,29 @@ var MyAuth2Extractor = &request.MultiExtractor{
 func UpdateContextUserModel(c *gin.Context, my_user_id uint) {
 	var myUserModel UserModel
 	if my_user_id != 0 {
-		db := common.GetDB()
+		db := common.GetDBContext(common.RequestContext(c))
 		db.First(&myUserModel, my_user_id)
 	}
 	c.Set("my_user_id", my_user_id)
 	c.Set("my_user_model", myUserModel)
+	c.Set("my_blocked_user_ids", nil)
+}
+
+// Ids of the users blocked with the current user, loaded once per request.
+//
+//	hidden := users.ContextBlockedUserIDs(c)
+func ContextBlockedUserIDs(c *gin.Context) []uint {
+	value, _ := c.Get("my_blocked_user_ids")
+	if ids, ok := value.([]uint); ok {
+		return ids
+	}
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	ids := myUserModel.BlockedUserIDs(common.RequestContext(c))
+	if ids == nil {
+		ids = []uint{}
+	}
+	c.Set("my_blocked_user_ids", ids)
+	return ids
 }
 
 // You can custom middlewares yourself as the doc: https://github.com/gin-gonic/gin#custom-middleware
@@ -67,3 +86,15 @@ func AuthMiddleware(auto401 bool) gin.HandlerFunc {
 		}
 	}
 }
+
+// Let only administrators through, it goes after AuthMiddleware(true) which loads my_user_model.
+//
+//	router.DELETE("/:tag", users.AdminMiddleware(), TagDelete)
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		myUserModel := c.MustGet("my_user_model").(UserModel)
+		if !myUserModel.IsAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("admin", errors.New("Admin only")))
+		}
+	}
+}
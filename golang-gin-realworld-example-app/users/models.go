@@ -1,10 +1,14 @@
 package users
 
 import (
+	"context"
 	"errors"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
 	"golang.org/x/crypto/bcrypt"
+	"realworld-backend/events"
+	"strconv"
+	"time"
 )
 
 // Models should only be concerned with database schema, more strict checking should be put in validator.
@@ -19,6 +23,10 @@ type UserModel struct {
 	Bio          string  `gorm:"column:bio;size:1024"`
 	Image        *string `gorm:"column:image"`
 	PasswordHash string  `gorm:"column:password;not null"`
+	IsAdmin      bool    `gorm:"column:is_admin;not null;default:false"` // granted in the database only
+	// Email digest of the new articles of followed authors, see the digest module.
+	DigestFrequency string     `gorm:"column:digest_frequency;size:16"`
+	DigestSentAt    *time.Time `gorm:"column:digest_sent_at"`
 }
 
 // A hack way to save ManyToMany relationship,
@@ -39,12 +47,37 @@ type FollowModel struct {
 	FollowedByID uint
 }
 
+// `Blocker` blocked `Blocked`: neither can follow the other, comment on or favorite the other's articles,
+// or see the other's profile.
+type BlockModel struct {
+	gorm.Model
+	Blocker   UserModel
+	BlockerID uint
+	Blocked   UserModel
+	BlockedID uint
+}
+
+// `Muter` muted `Muted`: their articles and comments are left out of the muter's feed and comment lists,
+// the muted user doesn't notice anything.
+type MuteModel struct {
+	gorm.Model
+	Muter   UserModel
+	MuterID uint
+	Muted   UserModel
+	MutedID uint
+}
+
+// Returned when a user tries to interact with someone they blocked or who blocked them.
+var ErrBlocked = errors.New("Blocked user")
+
 // Migrate the schema of database if needed
 func AutoMigrate() {
 	db := common.GetDB()
 
 	db.AutoMigrate(&UserModel{})
 	db.AutoMigrate(&FollowModel{})
+	db.AutoMigrate(&BlockModel{})
+	db.AutoMigrate(&MuteModel{})
 }
 
 // What's bcrypt? https://en.wikipedia.org/wiki/Bcrypt
@@ -71,9 +104,9 @@ func (u *UserModel) checkPassword(password string) error {
 }
 
 // You could input the conditions and it will return an UserModel in database with error info.
-// 	userModel, err := FindOneUser(&UserModel{Username: "username0"})
-func FindOneUser(condition interface{}) (UserModel, error) {
-	db := common.GetDB()
+// 	userModel, err := FindOneUser(ctx, &UserModel{Username: "username0"})
+func FindOneUser(ctx context.Context, condition interface{}) (UserModel, error) {
+	db := common.GetDBContext(ctx)
 	var model UserModel
 	err := db.Where(condition).First(&model).Error
 	return model, err
@@ -96,21 +129,39 @@ func (model *UserModel) Update(data interface{}) error {
 }
 
 // You could add a following relationship as userModel1 following userModel2
-// 	err = userModel1.following(userModel2)
-func (u UserModel) following(v UserModel) error {
-	db := common.GetDB()
-	var follow FollowModel
-	err := db.FirstOrCreate(&follow, &FollowModel{
+// 	err = userModel1.following(ctx, userModel2)
+func (u UserModel) following(ctx context.Context, v UserModel) error {
+	if BlockedBetween(ctx, u.ID, v.ID) {
+		return ErrBlocked
+	}
+	if u.isFollowing(ctx, v) {
+		return nil
+	}
+	tx := common.GetDBContext(ctx).Begin()
+	err := tx.Create(&FollowModel{
 		FollowingID:  v.ID,
 		FollowedByID: u.ID,
 	}).Error
-	return err
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	eventID, err := events.Record(tx, events.UserFollowed{FollowerID: u.ID, FollowingID: v.ID})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	events.Dispatch(eventID)
+	return nil
 }
 
 // You could check whether  userModel1 following userModel2
-// 	followingBool = myUserModel.isFollowing(self.UserModel)
-func (u UserModel) isFollowing(v UserModel) bool {
-	db := common.GetDB()
+// 	followingBool = myUserModel.isFollowing(ctx, self.UserModel)
+func (u UserModel) isFollowing(ctx context.Context, v UserModel) bool {
+	db := common.GetDBContext(ctx)
 	var follow FollowModel
 	db.Where(FollowModel{
 		FollowingID:  v.ID,
@@ -120,9 +171,9 @@ func (u UserModel) isFollowing(v UserModel) bool {
 }
 
 // You could delete a following relationship as userModel1 following userModel2
-// 	err = userModel1.unFollowing(userModel2)
-func (u UserModel) unFollowing(v UserModel) error {
-	db := common.GetDB()
+// 	err = userModel1.unFollowing(ctx, userModel2)
+func (u UserModel) unFollowing(ctx context.Context, v UserModel) error {
+	db := common.GetDBContext(ctx)
 	err := db.Where(FollowModel{
 		FollowingID:  v.ID,
 		FollowedByID: u.ID,
@@ -131,9 +182,9 @@ func (u UserModel) unFollowing(v UserModel) error {
 }
 
 // You could get a following list of userModel
-// 	followings := userModel.GetFollowings()
-func (u UserModel) GetFollowings() []UserModel {
-	db := common.GetDB()
+// 	followings := userModel.GetFollowings(ctx)
+func (u UserModel) GetFollowings(ctx context.Context) []UserModel {
+	db := common.GetDBContext(ctx)
 	tx := db.Begin()
 	var follows []FollowModel
 	var followings []UserModel
@@ -148,3 +199,229 @@ func (u UserModel) GetFollowings() []UserModel {
 	tx.Commit()
 	return followings
 }
+
+// Blocking also removes the follows between both users.
+//
+//	err = userModel1.block(ctx, userModel2)
+func (u UserModel) block(ctx context.Context, v UserModel) error {
+	db := common.GetDBContext(ctx)
+	tx := db.Begin()
+	var block BlockModel
+	err := tx.FirstOrCreate(&block, &BlockModel{
+		BlockerID: u.ID,
+		BlockedID: v.ID,
+	}).Error
+	if err == nil {
+		err = tx.Where("(following_id = ? AND followed_by_id = ?) OR (following_id = ? AND followed_by_id = ?)",
+			v.ID, u.ID, u.ID, v.ID).Delete(FollowModel{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (u UserModel) unBlock(ctx context.Context, v UserModel) error {
+	db := common.GetDBContext(ctx)
+	err := db.Where(BlockModel{
+		BlockerID: u.ID,
+		BlockedID: v.ID,
+	}).Delete(BlockModel{}).Error
+	return err
+}
+
+func (u UserModel) isBlocking(ctx context.Context, v UserModel) bool {
+	db := common.GetDBContext(ctx)
+	var block BlockModel
+	db.Where(BlockModel{
+		BlockerID: u.ID,
+		BlockedID: v.ID,
+	}).First(&block)
+	return block.ID != 0
+}
+
+func (u UserModel) mute(ctx context.Context, v UserModel) error {
+	db := common.GetDBContext(ctx)
+	var mute MuteModel
+	err := db.FirstOrCreate(&mute, &MuteModel{
+		MuterID: u.ID,
+		MutedID: v.ID,
+	}).Error
+	return err
+}
+
+func (u UserModel) unMute(ctx context.Context, v UserModel) error {
+	db := common.GetDBContext(ctx)
+	err := db.Where(MuteModel{
+		MuterID: u.ID,
+		MutedID: v.ID,
+	}).Delete(MuteModel{}).Error
+	return err
+}
+
+func (u UserModel) isMuting(ctx context.Context, v UserModel) bool {
+	db := common.GetDBContext(ctx)
+	var mute MuteModel
+	db.Where(MuteModel{
+		MuterID: u.ID,
+		MutedID: v.ID,
+	}).First(&mute)
+	return mute.ID != 0
+}
+
+// Whether one of the two users blocked the other, blocks work in both directions.
+//
+//	if users.BlockedBetween(ctx, myUserModel.ID, articleModel.Author.UserModelID) { ... }
+func BlockedBetween(ctx context.Context, a, b uint) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	db := common.GetDBContext(ctx)
+	count := 0
+	db.Model(&BlockModel{}).Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", a, b, b, a).Count(&count)
+	return count > 0
+}
+
+// Users `u` blocked or who blocked `u`, their content is left out of every list `u` sees.
+func (u UserModel) BlockedUserIDs(ctx context.Context) []uint {
+	var ids []uint
+	if u.ID == 0 {
+		return ids
+	}
+	db := common.GetDBContext(ctx)
+	var blocks []BlockModel
+	db.Where("blocker_id = ? OR blocked_id = ?", u.ID, u.ID).Find(&blocks)
+	for _, block := range blocks {
+		if block.BlockerID == u.ID {
+			ids = append(ids, block.BlockedID)
+		} else {
+			ids = append(ids, block.BlockerID)
+		}
+	}
+	return ids
+}
+
+// The other side of HiddenUserIDs: users who don't see what `u` does, because of a block
+// either way or because they muted `u`. Pushed updates skip them.
+func (u UserModel) HidingUserIDs(ctx context.Context) []uint {
+	ids := u.BlockedUserIDs(ctx)
+	if u.ID == 0 {
+		return ids
+	}
+	db := common.GetDBContext(ctx)
+	var muterIDs []uint
+	db.Model(&MuteModel{}).Where(MuteModel{MutedID: u.ID}).Pluck("muter_id", &muterIDs)
+	return append(ids, muterIDs...)
+}
+
+// Ids of the users following `u`.
+func (u UserModel) FollowerIDs() []uint {
+	db := common.GetDB()
+	var ids []uint
+	db.Model(&FollowModel{}).Where(FollowModel{FollowingID: u.ID}).Pluck("followed_by_id", &ids)
+	return ids
+}
+
+// BlockedUserIDs and the users `u` muted, for the feed and the comment lists.
+func (u UserModel) HiddenUserIDs(ctx context.Context) []uint {
+	ids := u.BlockedUserIDs(ctx)
+	if u.ID == 0 {
+		return ids
+	}
+	db := common.GetDBContext(ctx)
+	var mutedIDs []uint
+	db.Model(&MuteModel{}).Where(MuteModel{MuterID: u.ID}).Pluck("muted_id", &mutedIDs)
+	return append(ids, mutedIDs...)
+}
+
+// Number of profiles per page of the follower lists, `?limit=` can ask for up to maxProfileLimit.
+const (
+	defaultProfileLimit = 20
+	maxProfileLimit     = 100
+)
+
+// A page of the users following `u` when `followers` is set, of the users `u` follows otherwise,
+// most recent follow first, with the total count. Users in `hidden` are left out.
+//
+//	followers, count, err := userModel.getFollowsPage(true, myUserModel.BlockedUserIDs(ctx), "20", "0")
+func (u UserModel) getFollowsPage(followers bool, hidden []uint, limit, offset string) ([]UserModel, int, error) {
+	if followers {
+		return u.getRelatedPage("follow_models", "followed_by_id", "following_id", hidden, limit, offset)
+	}
+	return u.getRelatedPage("follow_models", "following_id", "followed_by_id", hidden, limit, offset)
+}
+
+// Shared by the follow, block and mute lists: the users in `userColumn` of the rows of `table`
+// whose `ownerColumn` is `u`, most recent row first.
+func (u UserModel) getRelatedPage(table, userColumn, ownerColumn string, hidden []uint, limit, offset string) ([]UserModel, int, error) {
+	db := common.GetDB()
+	var models []UserModel
+	var count int
+
+	offset_int, err := strconv.Atoi(offset)
+	if err != nil || offset_int < 0 {
+		offset_int = 0
+	}
+	limit_int, err := strconv.Atoi(limit)
+	if err != nil || limit_int <= 0 {
+		limit_int = defaultProfileLimit
+	}
+	if limit_int > maxProfileLimit {
+		limit_int = maxProfileLimit
+	}
+
+	related := db.Model(&UserModel{}).
+		Joins("JOIN "+table+" ON "+table+"."+userColumn+" = user_models.id AND "+table+".deleted_at IS NULL").
+		Where(table+"."+ownerColumn+" = ?", u.ID)
+	if len(hidden) > 0 {
+		related = related.Where("user_models.id NOT IN (?)", hidden)
+	}
+	if err := related.Count(&count).Error; err != nil {
+		return models, 0, err
+	}
+	err = related.Select("user_models.*").Order(table + ".created_at desc, " + table + ".id desc").
+		Offset(offset_int).Limit(limit_int).Find(&models).Error
+	return models, count, err
+}
+
+// Which of the users in `ids` are followed by `u`, in one query for a whole list of profiles.
+func (u UserModel) followingSet(ctx context.Context, ids []uint) map[uint]bool {
+	set := map[uint]bool{}
+	if u.ID == 0 || len(ids) == 0 {
+		return set
+	}
+	db := common.GetDBContext(ctx)
+	var followingIDs []uint
+	db.Model(&FollowModel{}).Where("followed_by_id = ? AND following_id in (?)", u.ID, ids).Pluck("following_id", &followingIDs)
+	for _, id := range followingIDs {
+		set[id] = true
+	}
+	return set
+}
+
+// Every distinct profile image, the variant pipeline uses it to backfill avatars.
+//
+//	go uploads.BackfillVariants(ctx, "avatar", users.ImageURLs())
+func ImageURLs() []string {
+	db := common.GetDB()
+	var urls []string
+	db.Model(&UserModel{}).Where("image IS NOT NULL AND image <> ''").Pluck("DISTINCT image", &urls)
+	return urls
+}
+
+// Number of users, sitemaps use it to know how many pages to list.
+func CountUsers() int {
+	db := common.GetDB()
+	count := 0
+	db.Model(&UserModel{}).Count(&count)
+	return count
+}
+
+// Usernames of a page of users in id order, for listing profiles in a sitemap.
+func FindUsernames(offset, limit int) ([]string, error) {
+	db := common.GetDB()
+	var usernames []string
+	err := db.Model(&UserModel{}).Order("id").Offset(offset).Limit(limit).Pluck("username", &usernames).Error
+	return usernames, err
+}
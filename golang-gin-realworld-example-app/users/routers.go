@@ -1,8 +1,11 @@
 package users
 
 import (
+	"context"
 	"errors"
 	"realworld-backend/common"
+	"realworld-backend/metrics"
+	"realworld-backend/uploads"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
@@ -15,34 +18,50 @@ func UsersRegister(router *gin.RouterGroup) {
 func UserRegister(router *gin.RouterGroup) {
 	router.GET("/", UserRetrieve)
 	router.PUT("/", UserUpdate)
+	router.GET("/blocks", UserBlockList)
+	router.GET("/mutes", UserMuteList)
 }
 
 func ProfileRegister(router *gin.RouterGroup) {
 	router.GET("/:username", ProfileRetrieve)
 	router.POST("/:username/follow", ProfileFollow)
 	router.DELETE("/:username/follow", ProfileUnfollow)
+	router.GET("/:username/followers", ProfileFollowers)
+	router.GET("/:username/following", ProfileFollowing)
+	router.POST("/:username/block", ProfileBlock)
+	router.DELETE("/:username/block", ProfileUnblock)
+	router.POST("/:username/mute", ProfileMute)
+	router.DELETE("/:username/mute", ProfileUnmute)
 }
 
+// Profiles are hidden in both directions between blocked users, as if they didn't exist.
 func ProfileRetrieve(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
-	if err != nil {
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Username: username})
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	if err != nil || BlockedBetween(c.Request.Context(), myUserModel.ID, userModel.ID) {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
 	}
 	profileSerializer := ProfileSerializer{c, userModel}
-	c.JSON(http.StatusOK, gin.H{"profile": profileSerializer.Response()})
+	profile := profileSerializer.Response()
+	profile.Muted = myUserModel.isMuting(c.Request.Context(), userModel)
+	c.JSON(http.StatusOK, gin.H{"profile": profile})
 }
 
 func ProfileFollow(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Username: username})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(UserModel)
-	err = myUserModel.following(userModel)
+	err = myUserModel.following(c.Request.Context(), userModel)
+	if errors.Is(err, ErrBlocked) {
+		c.JSON(http.StatusForbidden, common.NewError("profile", err))
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
@@ -53,14 +72,14 @@ func ProfileFollow(c *gin.Context) {
 
 func ProfileUnfollow(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Username: username})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(UserModel)
 
-	err = myUserModel.unFollowing(userModel)
+	err = myUserModel.unFollowing(c.Request.Context(), userModel)
 	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
@@ -69,6 +88,101 @@ func ProfileUnfollow(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"profile": serializer.Response()})
 }
 
+func ProfileFollowers(c *gin.Context) {
+	profileFollowList(c, true)
+}
+
+func ProfileFollowing(c *gin.Context) {
+	profileFollowList(c, false)
+}
+
+func profileFollowList(c *gin.Context, followers bool) {
+	username := c.Param("username")
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Username: username})
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	if err != nil || BlockedBetween(c.Request.Context(), myUserModel.ID, userModel.ID) {
+		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		return
+	}
+	userModels, modelCount, err := userModel.getFollowsPage(followers, myUserModel.BlockedUserIDs(c.Request.Context()), c.Query("limit"), c.Query("offset"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profiles", errors.New("Invalid param")))
+		return
+	}
+	serializer := ProfilesSerializer{c, userModels}
+	c.JSON(http.StatusOK, gin.H{"profiles": serializer.Response(), "profilesCount": modelCount})
+}
+
+// Blocking works on any user, including one who already blocked you.
+func ProfileBlock(c *gin.Context) {
+	profileRelation(c, func(ctx context.Context, me, other UserModel) error { return me.block(ctx, other) })
+}
+
+func ProfileUnblock(c *gin.Context) {
+	profileRelation(c, func(ctx context.Context, me, other UserModel) error { return me.unBlock(ctx, other) })
+}
+
+func ProfileMute(c *gin.Context) {
+	profileRelation(c, func(ctx context.Context, me, other UserModel) error { return me.mute(ctx, other) })
+}
+
+func ProfileUnmute(c *gin.Context) {
+	profileRelation(c, func(ctx context.Context, me, other UserModel) error { return me.unMute(ctx, other) })
+}
+
+func profileRelation(c *gin.Context, change func(ctx context.Context, me, other UserModel) error) {
+	username := c.Param("username")
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Username: username})
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	if userModel.ID == myUserModel.ID {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("profile", errors.New("Can't block or mute yourself")))
+		return
+	}
+	if err := change(c.Request.Context(), myUserModel, userModel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ProfileSerializer{c, userModel}
+	profile := serializer.Response()
+	profile.Blocked = myUserModel.isBlocking(c.Request.Context(), userModel)
+	profile.Muted = myUserModel.isMuting(c.Request.Context(), userModel)
+	c.JSON(http.StatusOK, gin.H{"profile": profile})
+}
+
+func UserBlockList(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	userModels, modelCount, err := myUserModel.getRelatedPage("block_models", "blocked_id", "blocker_id", nil, c.Query("limit"), c.Query("offset"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profiles", errors.New("Invalid param")))
+		return
+	}
+	serializer := ProfilesSerializer{c, userModels}
+	profiles := serializer.Response()
+	for i := range profiles {
+		profiles[i].Blocked = true
+	}
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles, "profilesCount": modelCount})
+}
+
+func UserMuteList(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	userModels, modelCount, err := myUserModel.getRelatedPage("mute_models", "muted_id", "muter_id", nil, c.Query("limit"), c.Query("offset"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profiles", errors.New("Invalid param")))
+		return
+	}
+	serializer := ProfilesSerializer{c, userModels}
+	profiles := serializer.Response()
+	for i := range profiles {
+		profiles[i].Muted = true
+	}
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles, "profilesCount": modelCount})
+}
+
 func UsersRegistration(c *gin.Context) {
 	userModelValidator := NewUserModelValidator()
 	if err := userModelValidator.Bind(c); err != nil {
@@ -80,6 +194,10 @@ func UsersRegistration(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
+	metrics.Registrations.Inc()
+	if image := userModelValidator.userModel.Image; image != nil {
+		uploads.EnqueueVariants(*image, "avatar")
+	}
 	c.Set("my_user_model", userModelValidator.userModel)
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusCreated, gin.H{"user": serializer.Response()})
@@ -91,17 +209,20 @@ func UsersLogin(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
 		return
 	}
-	userModel, err := FindOneUser(&UserModel{Email: loginValidator.userModel.Email})
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Email: loginValidator.userModel.Email})
 
 	if err != nil {
+		metrics.Logins.WithLabelValues("failure").Inc()
 		c.JSON(http.StatusForbidden, common.NewError("login", errors.New("Not Registered email or invalid password")))
 		return
 	}
 
 	if userModel.checkPassword(loginValidator.User.Password) != nil {
+		metrics.Logins.WithLabelValues("failure").Inc()
 		c.JSON(http.StatusForbidden, common.NewError("login", errors.New("Not Registered email or invalid password")))
 		return
 	}
+	metrics.Logins.WithLabelValues("success").Inc()
 	UpdateContextUserModel(c, userModel.ID)
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
@@ -120,11 +241,18 @@ func UserUpdate(c *gin.Context) {
 		return
 	}
 
+	var previousImage string
+	if myUserModel.Image != nil {
+		previousImage = *myUserModel.Image
+	}
 	userModelValidator.userModel.ID = myUserModel.ID
 	if err := myUserModel.Update(userModelValidator.userModel); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
+	if image := userModelValidator.userModel.Image; image != nil && *image != previousImage {
+		uploads.EnqueueVariants(*image, "avatar")
+	}
 	UpdateContextUserModel(c, myUserModel.ID)
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"realworld-backend/common"
+	"realworld-backend/uploads"
 )
 
 type ProfileSerializer struct {
@@ -13,26 +14,80 @@ type ProfileSerializer struct {
 
 // Declare your response schema here
 type ProfileResponse struct {
-	ID        uint    `json:"-"`
-	Username  string  `json:"username"`
-	Bio       string  `json:"bio"`
-	Image     *string `json:"image"`
-	Following bool    `json:"following"`
+	ID            uint              `json:"-"`
+	Username      string            `json:"username"`
+	Bio           string            `json:"bio"`
+	Image         *string           `json:"image"`
+	ImageVariants map[string]string `json:"imageVariants,omitempty"` // thumbnails of Image keyed by size
+	Following     bool              `json:"following"`
+	Blocked       bool              `json:"blocked,omitempty"`
+	Muted         bool              `json:"muted,omitempty"`
 }
 
 // Put your response logic including wrap the userModel here.
 func (self *ProfileSerializer) Response() ProfileResponse {
+	return self.ResponseWithAvatars(nil)
+}
+
+// Like Response with the avatar variants of a whole list already loaded by uploads.FindVariantsOf,
+// they are looked up for this profile alone when avatars is nil.
+func (self *ProfileSerializer) ResponseWithAvatars(avatars uploads.Variants) ProfileResponse {
 	myUserModel := self.C.MustGet("my_user_model").(UserModel)
+	return self.response(myUserModel.isFollowing(common.RequestContext(self.C), self.UserModel), avatars)
+}
+
+func (self *ProfileSerializer) response(following bool, avatars uploads.Variants) ProfileResponse {
 	profile := ProfileResponse{
 		ID:        self.ID,
 		Username:  self.Username,
 		Bio:       self.Bio,
 		Image:     self.Image,
-		Following: myUserModel.isFollowing(self.UserModel),
+		Following: following,
+	}
+	if self.Image != nil {
+		if avatars == nil {
+			avatars = uploads.FindVariantsOf(common.RequestContext(self.C), "avatar", []string{*self.Image})
+		}
+		profile.ImageVariants = avatars[*self.Image]
 	}
 	return profile
 }
 
+type ProfilesSerializer struct {
+	C     *gin.Context
+	Users []UserModel
+}
+
+// The following flags and the avatar variants of the whole list are loaded at once instead of per
+// profile.
+func (self *ProfilesSerializer) Response() []ProfileResponse {
+	myUserModel := self.C.MustGet("my_user_model").(UserModel)
+	ctx := common.RequestContext(self.C)
+	ids := make([]uint, 0, len(self.Users))
+	for _, userModel := range self.Users {
+		ids = append(ids, userModel.ID)
+	}
+	following := myUserModel.followingSet(ctx, ids)
+	avatars := uploads.FindVariantsOf(ctx, "avatar", ImagesOf(self.Users))
+	response := []ProfileResponse{}
+	for _, userModel := range self.Users {
+		serializer := ProfileSerializer{self.C, userModel}
+		response = append(response, serializer.response(following[userModel.ID], avatars))
+	}
+	return response
+}
+
+// The images set by the users, to load their variants at once.
+func ImagesOf(userModels []UserModel) []string {
+	images := []string{}
+	for _, userModel := range userModels {
+		if userModel.Image != nil {
+			images = append(images, *userModel.Image)
+		}
+	}
+	return images
+}
+
 type UserSerializer struct {
 	c *gin.Context
 }
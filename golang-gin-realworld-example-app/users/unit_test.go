@@ -5,9 +5,13 @@ import (
 	"testing"
 
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"net/http/httptest"
@@ -76,19 +80,19 @@ func TestUserModel(t *testing.T) {
 	a := users[0]
 	b := users[1]
 	c := users[2]
-	asserts.Equal(0, len(a.GetFollowings()), "GetFollowings should be right before following")
-	asserts.Equal(false, a.isFollowing(b), "isFollowing relationship should be right at init")
-	a.following(b)
-	asserts.Equal(1, len(a.GetFollowings()), "GetFollowings should be right after a following b")
-	asserts.Equal(true, a.isFollowing(b), "isFollowing should be right after a following b")
-	a.following(c)
-	asserts.Equal(2, len(a.GetFollowings()), "GetFollowings be right after a following c")
-	asserts.EqualValues(b, a.GetFollowings()[0], "GetFollowings should be right")
-	asserts.EqualValues(c, a.GetFollowings()[1], "GetFollowings should be right")
-	a.unFollowing(b)
-	asserts.Equal(1, len(a.GetFollowings()), "GetFollowings should be right after a unFollowing b")
-	asserts.EqualValues(c, a.GetFollowings()[0], "GetFollowings should be right after a unFollowing b")
-	asserts.Equal(false, a.isFollowing(b), "isFollowing should be right after a unFollowing b")
+	asserts.Equal(0, len(a.GetFollowings(context.Background())), "GetFollowings should be right before following")
+	asserts.Equal(false, a.isFollowing(context.Background(), b), "isFollowing relationship should be right at init")
+	a.following(context.Background(), b)
+	asserts.Equal(1, len(a.GetFollowings(context.Background())), "GetFollowings should be right after a following b")
+	asserts.Equal(true, a.isFollowing(context.Background(), b), "isFollowing should be right after a following b")
+	a.following(context.Background(), c)
+	asserts.Equal(2, len(a.GetFollowings(context.Background())), "GetFollowings be right after a following c")
+	asserts.EqualValues(b, a.GetFollowings(context.Background())[0], "GetFollowings should be right")
+	asserts.EqualValues(c, a.GetFollowings(context.Background())[1], "GetFollowings should be right")
+	a.unFollowing(context.Background(), b)
+	asserts.Equal(1, len(a.GetFollowings(context.Background())), "GetFollowings should be right after a unFollowing b")
+	asserts.EqualValues(c, a.GetFollowings(context.Background())[0], "GetFollowings should be right after a unFollowing b")
+	asserts.Equal(false, a.isFollowing(context.Background(), b), "isFollowing should be right after a unFollowing b")
 }
 
 //Reset test DB and create new one with mock data
@@ -96,6 +100,7 @@ func resetDBWithMock() {
 	common.TestDBFree(test_db)
 	test_db = common.TestDBInit()
 	AutoMigrate()
+	events.AutoMigrate()
 	userModelMocker(3)
 }
 
@@ -451,6 +456,297 @@ var unauthRequestTests = []struct {
 		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
 		"user cancel follow another should make sure database changed",
 	},
+
+	//---------------------   Testing for follower lists   ---------------------
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow",
+		"POST",
+		``,
+		http.StatusOK,
+		`"following":true`,
+		"user follow another should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1/follow",
+		"POST",
+		``,
+		http.StatusOK,
+		`"following":true`,
+		"another user follow the same user should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user3/follow",
+		"POST",
+		``,
+		http.StatusOK,
+		`"following":true`,
+		"user follow a follower should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/followers",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profiles":\[{"username":"user3","bio":"bio3","image":"http://image/3.jpg","following":true},{"username":"user2","bio":"bio2","image":"http://image/2.jpg","following":false}\],"profilesCount":2}`,
+		"followers should be listed newest first with the viewer's following state",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/followers?limit=1&offset=1",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profiles":\[{"username":"user2","bio":"bio2","image":"http://image/2.jpg","following":false}\],"profilesCount":2}`,
+		"followers should be paginated",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user2/following",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profiles":\[{"username":"user3","bio":"bio3","image":"http://image/3.jpg","following":false},{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true}\],"profilesCount":2}`,
+		"following should be listed newest first with the viewer's following state",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user2/follow",
+		"DELETE",
+		``,
+		http.StatusOK,
+		`"following":false`,
+		"unfollowing someone not followed should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user3/following",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profiles":\[{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true}\],"profilesCount":1}`,
+		"following list should only include followed users",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user666/followers",
+		"GET",
+		``,
+		http.StatusNotFound,
+		`{"errors":{"profile":"Invalid username"}}`,
+		"followers of a missing user should return 404",
+	},
+	//---------------------   Testing for block and mute   ---------------------
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow",
+		"POST",
+		``,
+		http.StatusOK,
+		`"following":true`,
+		"user follow another before being blocked should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user2/block",
+		"POST",
+		``,
+		http.StatusOK,
+		`{"profile":{"username":"user2","bio":"bio2","image":"http://image/2.jpg","following":false,"blocked":true}}`,
+		"user block another should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1",
+		"GET",
+		``,
+		http.StatusNotFound,
+		`{"errors":{"profile":"Invalid username"}}`,
+		"blocked user should not see the profile",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user2",
+		"GET",
+		``,
+		http.StatusNotFound,
+		`{"errors":{"profile":"Invalid username"}}`,
+		"blocker should not see the profile either",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow",
+		"POST",
+		``,
+		http.StatusForbidden,
+		`{"errors":{"profile":"Blocked user"}}`,
+		"blocked user should not follow",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user1/followers",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profiles":\[\],"profilesCount":0}`,
+		"blocking should remove the follows",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/blocks",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profiles":\[{"username":"user2","bio":"bio2","image":"http://image/2.jpg","following":false,"blocked":true}\],"profilesCount":1}`,
+		"blocked users should be listed",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1/follow",
+		"POST",
+		``,
+		http.StatusOK,
+		`"following":true`,
+		"other users should still follow",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user3/following",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profiles":\[\],"profilesCount":0}`,
+		"blocked users should be left out of follow lists",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user1/block",
+		"POST",
+		``,
+		http.StatusUnprocessableEntity,
+		`{"errors":{"profile":"Can't block or mute yourself"}}`,
+		"user should not block themself",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user2/block",
+		"DELETE",
+		``,
+		http.StatusOK,
+		`{"profile":{"username":"user2","bio":"bio2","image":"http://image/2.jpg","following":false}}`,
+		"user unblock another should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		"unblocked profile should be visible again",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/mute",
+		"POST",
+		``,
+		http.StatusOK,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"muted":true}}`,
+		"user mute another should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1",
+		"GET",
+		``,
+		http.StatusOK,
+		`"muted":true`,
+		"muted profile should stay visible and flagged",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user2",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profile":{"username":"user2","bio":"bio2","image":"http://image/2.jpg","following":false}}`,
+		"muted user should not notice the mute",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/mutes",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profiles":\[{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"muted":true}\],"profilesCount":1}`,
+		"muted users should be listed",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/mute",
+		"DELETE",
+		``,
+		http.StatusOK,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		"user unmute another should work",
+	},
 }
 
 func TestWithoutAuth(t *testing.T) {
@@ -477,6 +773,9 @@ func TestWithoutAuth(t *testing.T) {
 		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
 		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
 	}
+	asserts.Greater(testutil.ToFloat64(metrics.Registrations), float64(0), "registrations should be counted")
+	asserts.Greater(testutil.ToFloat64(metrics.Logins.WithLabelValues("success")), float64(0), "logins should be counted")
+	asserts.Greater(testutil.ToFloat64(metrics.Logins.WithLabelValues("failure")), float64(0), "failed logins should be counted apart")
 }
 
 //This is a hack way to add test database for each case, as whole test will just share one database.
@@ -484,6 +783,7 @@ func TestWithoutAuth(t *testing.T) {
 func TestMain(m *testing.M) {
 	test_db = common.TestDBInit()
 	AutoMigrate()
+	events.AutoMigrate()
 	exitVal := m.Run()
 	common.TestDBFree(test_db)
 	os.Exit(exitVal)
@@ -15,7 +15,7 @@ type UserModelValidator struct {
 		Email    string `form:"email" json:"email" binding:"required,email"`
 		Password string `form:"password" json:"password" binding:"required,min=8,max=255"`
 		Bio      string `form:"bio" json:"bio" binding:"max=1024"`
-		Image    string `form:"image" json:"image" binding:"omitempty,url"`
+		Image    string `form:"image" json:"image" binding:"omitempty,mediaurl"`
 	} `json:"user"`
 	userModel UserModel `json:"-"`
 }
@@ -0,0 +1,262 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+	"realworld-backend/events"
+)
+
+// Headers sent with every delivery. The signature covers "<timestamp>.<body>" so a captured
+// request can't be replayed later with a new timestamp.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Sign `body` sent at `timestamp` (unix seconds) with the secret of the webhook.
+//
+//	signature := webhooks.Sign(secret, time.Now().Unix(), body) // "sha256=5d41..."
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check a signature in constant time, receivers should also refuse old timestamps.
+func Verify(secret, signature string, timestamp int64, body []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}
+
+// What receivers get, Data is the event itself.
+type Payload struct {
+	ID        string       `json:"id"`
+	Event     string       `json:"event"`
+	CreatedAt string       `json:"createdAt"`
+	Data      events.Event `json:"data"`
+}
+
+// The event id of the deliveries of the outbox event `outboxID`, the same on every retry.
+func eventID(outboxID uint) string {
+	return "evt_" + strconv.FormatUint(uint64(outboxID), 10)
+}
+
+// Record a pending delivery of `event` for every active webhook subscribed to it, except for
+// webhooks of users blocked with someone the event is about. The deliveries are saved together,
+// and a retry of the event skips the webhooks which already have theirs.
+func enqueue(outboxID uint, event events.Event) error {
+	webhookModels := activeWebhooks(event.EventName())
+	if len(webhookModels) == 0 {
+		return nil
+	}
+	payload := Payload{
+		ID:        eventID(outboxID),
+		Event:     event.EventName(),
+		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05.999Z"),
+		Data:      event,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	visible := []WebhookModel{}
+	for _, webhookModel := range webhookModels {
+		if visibleTo(webhookModel.OwnerID, event) {
+			visible = append(visible, webhookModel)
+		}
+	}
+	tx := common.GetDB().Begin()
+	var enqueued []uint
+	tx.Model(&DeliveryModel{}).Where("event_id = ? AND replay = 0", payload.ID).Pluck("webhook_id", &enqueued)
+	done := map[uint]bool{}
+	for _, id := range enqueued {
+		done[id] = true
+	}
+	for _, webhookModel := range visible {
+		if done[webhookModel.ID] {
+			continue
+		}
+		delivery := DeliveryModel{
+			WebhookID:     webhookModel.ID,
+			EventID:       payload.ID,
+			Event:         payload.Event,
+			Payload:       string(body),
+			Status:        StatusPending,
+			NextAttemptAt: time.Now(),
+		}
+		if err := tx.Create(&delivery).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	wake()
+	return nil
+}
+
+var wakeup = make(chan struct{}, 1)
+
+// Nudge the worker instead of waiting for its next poll.
+func wake() {
+	select {
+	case wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Send due deliveries until ctx is done, started once from main. Deliveries are polled from the
+// database so the ones pending when the server stopped are sent after a restart.
+func RunDeliveryWorker(ctx context.Context) {
+	ticker := time.NewTicker(common.GetenvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wakeup:
+		}
+		DeliverDue(ctx)
+	}
+}
+
+// Send every pending delivery whose next attempt is due, returns how many were delivered. The
+// pending deliveries of webhooks deactivated or deleted since they were enqueued are cancelled.
+func DeliverDue(ctx context.Context) int {
+	db := common.GetDB()
+	active := db.Table("webhook_models").Select("id").Where("active = ? AND deleted_at IS NULL", true).SubQuery()
+	if err := db.Model(&DeliveryModel{}).Where("status = ? AND webhook_id NOT IN ?", StatusPending, active).
+		Update("status", StatusCancelled).Error; err != nil {
+		slog.Error("webhooks err: (cancel)", "err", err)
+	}
+	var deliveries []DeliveryModel
+	db.Joins("JOIN webhook_models ON webhook_models.id = delivery_models.webhook_id AND webhook_models.active = ? AND webhook_models.deleted_at IS NULL", true).
+		Where("delivery_models.status = ? AND delivery_models.next_attempt_at <= ?", StatusPending, time.Now()).
+		Select("delivery_models.*").Order("delivery_models.id").Limit(100).Find(&deliveries)
+	delivered := 0
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			break
+		}
+		var webhookModel WebhookModel
+		err := db.Where("active = ?", true).First(&webhookModel, delivery.WebhookID).Error
+		if gorm.IsRecordNotFoundError(err) {
+			// Deactivated while the previous deliveries were sent.
+			db.Model(&delivery).Update("status", StatusCancelled)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if err := attempt(ctx, &delivery, webhookModel); err != nil {
+			slog.Error("webhooks err: (deliver)", "url", webhookModel.URL, "delivery", delivery.ID, "err", err)
+			continue
+		}
+		delivered++
+	}
+	return delivered
+}
+
+// Endpoints are given by users, deliveries are refused to private addresses so the delivery log
+// can't tell what answers inside our network.
+var client = newClient()
+
+func newClient() *http.Client {
+	return common.NewPublicClient(common.GetenvDuration("WEBHOOK_TIMEOUT", 10*time.Second))
+}
+
+// Deliver through `c`, such as the client of a TestReceiver listening on loopback. nil restores
+// the client refusing private addresses.
+//
+//	webhooks.SetClient(receiver.Client())
+//	defer webhooks.SetClient(nil)
+func SetClient(c *http.Client) {
+	if c == nil {
+		c = newClient()
+	}
+	client = c
+}
+
+// Delay before the next attempt, doubling from WEBHOOK_RETRY_BASE up to an hour.
+func backoff(attempts int) time.Duration {
+	delay := common.GetenvDuration("WEBHOOK_RETRY_BASE", 30*time.Second)
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// Post the delivery once and record the outcome, any 2xx answer counts as delivered. After
+// WEBHOOK_MAX_ATTEMPTS failures the delivery is given up, it can still be replayed.
+func attempt(ctx context.Context, delivery *DeliveryModel, webhookModel WebhookModel) error {
+	sendErr := send(ctx, delivery, webhookModel)
+	delivery.Attempts++
+	if sendErr == nil {
+		now := time.Now()
+		delivery.Status = StatusDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = sendErr.Error()
+		if len(delivery.LastError) > 1024 {
+			delivery.LastError = delivery.LastError[:1024]
+		}
+		if delivery.Attempts >= common.GetenvInt("WEBHOOK_MAX_ATTEMPTS", 8) {
+			delivery.Status = StatusFailed
+		} else {
+			delivery.NextAttemptAt = time.Now().Add(backoff(delivery.Attempts))
+		}
+	}
+	if err := SaveOne(delivery); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+func send(ctx context.Context, delivery *DeliveryModel, webhookModel WebhookModel) error {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookModel.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "realworld-webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.EventID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(webhookModel.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	delivery.ResponseCode = 0
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	delivery.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
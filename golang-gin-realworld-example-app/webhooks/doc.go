@@ -0,0 +1,19 @@
+/*
+The webhooks module posting events (new articles, comments, follows...) to the endpoints users
+registered, signed with HMAC-SHA256 and retried with exponential backoff.
+
+models.go: definition of orm based data model
+
+routers.go: router binding and core logic
+
+serializers.go: definition the schema of return data
+
+validators.go: definition the validator of form data
+
+delivery.go: signing, sending and retrying deliveries
+
+events.go: subscription to the events that are delivered
+
+receiver.go: a signature checking receiver for integration tests
+*/
+package webhooks
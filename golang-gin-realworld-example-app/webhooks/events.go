@@ -0,0 +1,62 @@
+package webhooks
+
+import (
+	"context"
+
+	"realworld-backend/events"
+	"realworld-backend/users"
+)
+
+// Register the delivery of every event webhooks can subscribe to, call once at startup. The outbox
+// id of the event becomes the event id of its deliveries.
+func SubscribeEvents() {
+	events.SubscribeWithID("webhooks", func(id uint, event events.ArticleCreated) error { return enqueue(id, event) })
+	events.SubscribeWithID("webhooks", func(id uint, event events.ArticleUpdated) error { return enqueue(id, event) })
+	events.SubscribeWithID("webhooks", func(id uint, event events.ArticleDeleted) error { return enqueue(id, event) })
+	events.SubscribeWithID("webhooks", func(id uint, event events.CommentCreated) error { return enqueue(id, event) })
+	events.SubscribeWithID("webhooks", func(id uint, event events.UserFollowed) error { return enqueue(id, event) })
+	events.SubscribeWithID("webhooks", func(id uint, event events.ArticleFavorited) error { return enqueue(id, event) })
+	events.SubscribeWithID("webhooks", func(id uint, event events.UserMentioned) error { return enqueue(id, event) })
+}
+
+// Users an event is about.
+func involvedUsers(event events.Event) []uint {
+	switch e := event.(type) {
+	case events.ArticleCreated:
+		return []uint{e.AuthorID}
+	case events.ArticleUpdated:
+		return []uint{e.AuthorID}
+	case events.ArticleDeleted:
+		return []uint{e.AuthorID}
+	case events.CommentCreated:
+		return []uint{e.AuthorID, e.ArticleAuthorID}
+	case events.UserFollowed:
+		return []uint{e.FollowerID, e.FollowingID}
+	case events.ArticleFavorited:
+		return []uint{e.UserID, e.AuthorID}
+	case events.UserMentioned:
+		return []uint{e.AuthorID, e.UserID}
+	}
+	return nil
+}
+
+// Webhooks of admins get every event, those of other users skip events about users they
+// are blocked with, as the api hides them.
+func visibleTo(ownerID uint, event events.Event) bool {
+	owner, err := users.FindOneUser(context.Background(), &users.UserModel{ID: ownerID})
+	if err != nil {
+		return false
+	}
+	if owner.IsAdmin {
+		return true
+	}
+	blocked := owner.BlockedUserIDs(context.Background())
+	for _, id := range involvedUsers(event) {
+		for _, blockedID := range blocked {
+			if id == blockedID {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+	"realworld-backend/events"
+)
+
+// Names of the events a webhook can subscribe to.
+var eventNames = []string{
+	events.ArticleCreated{}.EventName(),
+	events.ArticleUpdated{}.EventName(),
+	events.ArticleDeleted{}.EventName(),
+	events.CommentCreated{}.EventName(),
+	events.UserFollowed{}.EventName(),
+	events.ArticleFavorited{}.EventName(),
+	events.UserMentioned{}.EventName(),
+}
+
+type WebhookModel struct {
+	gorm.Model
+	OwnerID uint   `gorm:"index"`
+	URL     string `gorm:"size:1024"`
+	Secret  string `gorm:"size:64"`
+	Events  string `gorm:"size:512"` // comma separated event names
+	Active  bool
+}
+
+// Status of a delivery.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled" // its webhook was deactivated or deleted before it was sent
+)
+
+// One event sent to one webhook. EventID is shared by the deliveries of the same event, so
+// receivers can drop duplicates, replays included. Replay is 0 for the delivery of the event and
+// counts its replays, a webhook gets a single delivery of every (EventID, Replay).
+type DeliveryModel struct {
+	gorm.Model
+	WebhookID     uint   `gorm:"index"`
+	EventID       string `gorm:"size:32;index"`
+	Replay        int
+	Event         string `gorm:"size:64"`
+	Payload       string `gorm:"type:text"`
+	Status        string `gorm:"size:16;index"`
+	Attempts      int
+	ResponseCode  int
+	LastError     string    `gorm:"size:1024"`
+	NextAttemptAt time.Time `gorm:"index"`
+	DeliveredAt   *time.Time
+}
+
+func AutoMigrate() {
+	db := common.GetDB()
+
+	db.AutoMigrate(&WebhookModel{})
+	db.AutoMigrate(&DeliveryModel{})
+	// Replays made before they were counted share the key of their original, number them apart.
+	db.Exec("UPDATE delivery_models SET replay = id WHERE replay = 0 AND id NOT IN (SELECT MIN(id) FROM delivery_models GROUP BY webhook_id, event_id)")
+	db.Model(&DeliveryModel{}).AddUniqueIndex("idx_delivery_models_webhook_event", "webhook_id", "event_id", "replay")
+}
+
+func validEvent(name string) bool {
+	for _, eventName := range eventNames {
+		if eventName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Random hex string used for secrets and event ids.
+func randomHex(bytes int) string {
+	b := make([]byte, bytes)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (model WebhookModel) eventList() []string {
+	if model.Events == "" {
+		return []string{}
+	}
+	return strings.Split(model.Events, ",")
+}
+
+func (model WebhookModel) subscribed(name string) bool {
+	for _, eventName := range model.eventList() {
+		if eventName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func SaveOne(data interface{}) error {
+	db := common.GetDB()
+	err := db.Save(data).Error
+	return err
+}
+
+// Admins can see and change every webhook, other users only theirs.
+func findWebhook(id string, ownerID uint, admin bool) (WebhookModel, error) {
+	db := common.GetDB()
+	var model WebhookModel
+	query := db.Where("id = ?", id)
+	if !admin {
+		query = query.Where("owner_id = ?", ownerID)
+	}
+	err := query.First(&model).Error
+	return model, err
+}
+
+func getWebhooks(ownerID uint, admin bool) ([]WebhookModel, error) {
+	db := common.GetDB()
+	var models []WebhookModel
+	query := db.Order("id")
+	if !admin {
+		query = query.Where("owner_id = ?", ownerID)
+	}
+	err := query.Find(&models).Error
+	return models, err
+}
+
+func activeWebhooks(name string) []WebhookModel {
+	db := common.GetDB()
+	var models []WebhookModel
+	db.Where("active = ?", true).Find(&models)
+	subscribed := []WebhookModel{}
+	for _, model := range models {
+		if model.subscribed(name) {
+			subscribed = append(subscribed, model)
+		}
+	}
+	return subscribed
+}
+
+// Deliveries are removed with their webhook, nothing is left to send them to.
+func DeleteWebhookModel(model WebhookModel) error {
+	tx := common.GetDB().Begin()
+	if err := tx.Unscoped().Where("webhook_id = ?", model.ID).Delete(&DeliveryModel{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Unscoped().Delete(&model).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Number of deliveries per page of the log, `?limit=` can ask for up to maxDeliveryLimit.
+const (
+	defaultDeliveryLimit = 20
+	maxDeliveryLimit     = 100
+)
+
+// A page of the delivery log of a webhook, latest first, with its total count. `status` keeps
+// only pending, delivered, failed or cancelled deliveries.
+//
+//	deliveries, count, err := webhookModel.getDeliveriesPage("failed", "20", "0")
+func (model WebhookModel) getDeliveriesPage(status, limit, offset string) ([]DeliveryModel, int, error) {
+	db := common.GetDB()
+	var models []DeliveryModel
+	var count int
+
+	offset_int, err := strconv.Atoi(offset)
+	if err != nil || offset_int < 0 {
+		offset_int = 0
+	}
+	limit_int, err := strconv.Atoi(limit)
+	if err != nil || limit_int <= 0 {
+		limit_int = defaultDeliveryLimit
+	}
+	if limit_int > maxDeliveryLimit {
+		limit_int = maxDeliveryLimit
+	}
+
+	query := db.Model(&DeliveryModel{}).Where("webhook_id = ?", model.ID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	query.Count(&count)
+	err = query.Order("id desc").Offset(offset_int).Limit(limit_int).Find(&models).Error
+	return models, count, err
+}
+
+func (model WebhookModel) findDelivery(id string) (DeliveryModel, error) {
+	db := common.GetDB()
+	var delivery DeliveryModel
+	err := db.Where("id = ? AND webhook_id = ?", id, model.ID).First(&delivery).Error
+	return delivery, err
+}
+
+// Queue the event again as a new delivery with the same event id and payload, the original
+// stays in the log.
+func (delivery DeliveryModel) replay() (DeliveryModel, error) {
+	var last struct{ Replay int }
+	common.GetDB().Model(&DeliveryModel{}).Select("MAX(replay) AS replay").
+		Where("webhook_id = ? AND event_id = ?", delivery.WebhookID, delivery.EventID).Scan(&last)
+	replayed := DeliveryModel{
+		WebhookID:     delivery.WebhookID,
+		EventID:       delivery.EventID,
+		Replay:        last.Replay + 1,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        StatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	err := SaveOne(&replayed)
+	if err == nil {
+		wake()
+	}
+	return replayed, err
+}
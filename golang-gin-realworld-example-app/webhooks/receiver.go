@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A delivery as the receiver got it.
+type ReceivedDelivery struct {
+	Event    string
+	Delivery string
+	Payload  map[string]interface{}
+}
+
+// A local endpoint for integration tests, it checks signatures like a real receiver should,
+// answering 401 to bad ones, and records the valid deliveries.
+//
+//	receiver := webhooks.NewTestReceiver(secret)
+//	defer receiver.Close()
+//	// register receiver.URL, trigger events, then webhooks.DeliverDue(ctx)
+//	deliveries := receiver.Received()
+type TestReceiver struct {
+	*httptest.Server
+	Secret string
+
+	mutex    sync.Mutex
+	received []ReceivedDelivery
+	failures int
+}
+
+func NewTestReceiver(secret string) *TestReceiver {
+	receiver := &TestReceiver{Secret: secret}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(receiver.serve))
+	return receiver
+}
+
+// Answer 500 to the next `n` deliveries, to exercise retries.
+func (r *TestReceiver) FailNext(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failures = n
+}
+
+func (r *TestReceiver) Received() []ReceivedDelivery {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]ReceivedDelivery{}, r.received...)
+}
+
+func (r *TestReceiver) serve(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > 5*time.Minute || !Verify(r.Secret, req.Header.Get(HeaderSignature), timestamp, body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	delivery := ReceivedDelivery{Event: req.Header.Get(HeaderEvent), Delivery: req.Header.Get(HeaderDelivery)}
+	json.Unmarshal(body, &delivery.Payload)
+	r.received = append(r.received, delivery)
+	w.WriteHeader(http.StatusNoContent)
+}
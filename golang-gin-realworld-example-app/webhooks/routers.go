@@ -0,0 +1,140 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+func WebhooksRegister(router *gin.RouterGroup) {
+	router.GET("/", WebhookList)
+	router.POST("/", WebhookCreate)
+	router.GET("/:id", WebhookRetrieve)
+	router.PUT("/:id", WebhookUpdate)
+	router.DELETE("/:id", WebhookDelete)
+	router.GET("/:id/deliveries", DeliveryList)
+	router.POST("/:id/deliveries/:delivery/replay", DeliveryReplay)
+}
+
+// The webhook of the `:id` param, answering 404 when it isn't one of the current user's.
+func webhookParam(c *gin.Context) (WebhookModel, bool) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	webhookModel, err := findWebhook(c.Param("id"), myUserModel.ID, myUserModel.IsAdmin)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("webhook", errors.New("Invalid id")))
+		return webhookModel, false
+	}
+	return webhookModel, true
+}
+
+func WebhookList(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	webhookModels, err := getWebhooks(myUserModel.ID, myUserModel.IsAdmin)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := WebhooksSerializer{c, webhookModels}
+	c.JSON(http.StatusOK, gin.H{"webhooks": serializer.Response(), "webhooksCount": len(webhookModels)})
+}
+
+func WebhookCreate(c *gin.Context) {
+	webhookModelValidator := NewWebhookModelValidator()
+	if err := webhookModelValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if err := webhookModelValidator.check(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("webhook", err))
+		return
+	}
+	if err := SaveOne(&webhookModelValidator.webhookModel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := WebhookSerializer{c, webhookModelValidator.webhookModel}
+	response := serializer.Response()
+	response.Secret = webhookModelValidator.webhookModel.Secret
+	c.JSON(http.StatusCreated, gin.H{"webhook": response})
+}
+
+func WebhookRetrieve(c *gin.Context) {
+	webhookModel, ok := webhookParam(c)
+	if !ok {
+		return
+	}
+	serializer := WebhookSerializer{c, webhookModel}
+	c.JSON(http.StatusOK, gin.H{"webhook": serializer.Response()})
+}
+
+// Setting a new secret rotates it, leaving it out keeps the current one.
+func WebhookUpdate(c *gin.Context) {
+	webhookModel, ok := webhookParam(c)
+	if !ok {
+		return
+	}
+	webhookModelValidator := NewWebhookModelValidatorFillWith(webhookModel)
+	if err := webhookModelValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if err := webhookModelValidator.check(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("webhook", err))
+		return
+	}
+	if err := SaveOne(&webhookModelValidator.webhookModel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := WebhookSerializer{c, webhookModelValidator.webhookModel}
+	c.JSON(http.StatusOK, gin.H{"webhook": serializer.Response()})
+}
+
+func WebhookDelete(c *gin.Context) {
+	webhookModel, ok := webhookParam(c)
+	if !ok {
+		return
+	}
+	if err := DeleteWebhookModel(webhookModel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhook": "Delete success"})
+}
+
+// `?status=failed` lists the deliveries that were given up.
+func DeliveryList(c *gin.Context) {
+	webhookModel, ok := webhookParam(c)
+	if !ok {
+		return
+	}
+	deliveryModels, modelCount, err := webhookModel.getDeliveriesPage(c.Query("status"), c.Query("limit"), c.Query("offset"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("deliveries", errors.New("Invalid param")))
+		return
+	}
+	serializer := DeliveriesSerializer{c, deliveryModels}
+	c.JSON(http.StatusOK, gin.H{"deliveries": serializer.Response(), "deliveriesCount": modelCount})
+}
+
+func DeliveryReplay(c *gin.Context) {
+	webhookModel, ok := webhookParam(c)
+	if !ok {
+		return
+	}
+	deliveryModel, err := webhookModel.findDelivery(c.Param("delivery"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("delivery", errors.New("Invalid id")))
+		return
+	}
+	replayed, err := deliveryModel.replay()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := DeliverySerializer{c, replayed}
+	c.JSON(http.StatusAccepted, gin.H{"delivery": serializer.Response()})
+}
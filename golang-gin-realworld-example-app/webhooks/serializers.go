@@ -0,0 +1,103 @@
+package webhooks
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookSerializer struct {
+	C *gin.Context
+	WebhookModel
+}
+
+type WebhookResponse struct {
+	ID        uint     `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"createdAt"`
+	UpdatedAt string   `json:"updatedAt"`
+}
+
+func (s *WebhookSerializer) Response() WebhookResponse {
+	return WebhookResponse{
+		ID:        s.ID,
+		URL:       s.URL,
+		Events:    s.eventList(),
+		Active:    s.Active,
+		CreatedAt: s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		UpdatedAt: s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+	}
+}
+
+type WebhooksSerializer struct {
+	C        *gin.Context
+	Webhooks []WebhookModel
+}
+
+func (s *WebhooksSerializer) Response() []WebhookResponse {
+	response := []WebhookResponse{}
+	for _, webhook := range s.Webhooks {
+		serializer := WebhookSerializer{s.C, webhook}
+		response = append(response, serializer.Response())
+	}
+	return response
+}
+
+type DeliverySerializer struct {
+	C *gin.Context
+	DeliveryModel
+}
+
+type DeliveryResponse struct {
+	ID            uint            `json:"id"`
+	EventID       string          `json:"eventId"`
+	Event         string          `json:"event"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	ResponseCode  int             `json:"responseCode,omitempty"`
+	LastError     string          `json:"lastError,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	NextAttemptAt *string         `json:"nextAttemptAt"`
+	DeliveredAt   *string         `json:"deliveredAt"`
+	CreatedAt     string          `json:"createdAt"`
+}
+
+func (s *DeliverySerializer) Response() DeliveryResponse {
+	response := DeliveryResponse{
+		ID:           s.ID,
+		EventID:      s.EventID,
+		Event:        s.Event,
+		Status:       s.Status,
+		Attempts:     s.Attempts,
+		ResponseCode: s.ResponseCode,
+		LastError:    s.LastError,
+		Payload:      json.RawMessage(s.Payload),
+		CreatedAt:    s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+	}
+	if s.Status == StatusPending {
+		nextAttemptAt := s.NextAttemptAt.UTC().Format("2006-01-02T15:04:05.999Z")
+		response.NextAttemptAt = &nextAttemptAt
+	}
+	if s.DeliveredAt != nil {
+		deliveredAt := s.DeliveredAt.UTC().Format("2006-01-02T15:04:05.999Z")
+		response.DeliveredAt = &deliveredAt
+	}
+	return response
+}
+
+type DeliveriesSerializer struct {
+	C          *gin.Context
+	Deliveries []DeliveryModel
+}
+
+func (s *DeliveriesSerializer) Response() []DeliveryResponse {
+	response := []DeliveryResponse{}
+	for _, delivery := range s.Deliveries {
+		serializer := DeliverySerializer{s.C, delivery}
+		response = append(response, serializer.Response())
+	}
+	return response
+}
@@ -0,0 +1,253 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+	"realworld-backend/events"
+	"realworld-backend/users"
+)
+
+var test_db *gorm.DB
+
+func newUser(name string) users.UserModel {
+	userModel := users.UserModel{Username: name, Email: name + "@webhooks.test", PasswordHash: "x"}
+	test_db.Create(&userModel)
+	return userModel
+}
+
+func newRouter(current users.UserModel) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		users.UpdateContextUserModel(c, current.ID)
+	})
+	WebhooksRegister(r.Group("/api/webhooks"))
+	return r
+}
+
+func request(r *gin.Engine, method, url, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSign(t *testing.T) {
+	asserts := assert.New(t)
+
+	body := []byte(`{"event":"article.created"}`)
+	signature := Sign("secret", 1700000000, body)
+	asserts.Regexp("^sha256=[0-9a-f]{64}$", signature, "signature should be a hex sha256")
+	asserts.True(Verify("secret", signature, 1700000000, body), "signature should verify")
+	asserts.False(Verify("other", signature, 1700000000, body), "other secrets should not verify")
+	asserts.False(Verify("secret", signature, 1700000001, body), "other timestamps should not verify")
+	asserts.False(Verify("secret", signature, 1700000000, []byte(`{}`)), "other bodies should not verify")
+}
+
+func TestBackoff(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Equal(30*time.Second, backoff(1), "first retry should wait the base delay")
+	asserts.Equal(2*time.Minute, backoff(3), "delay should double with every attempt")
+	asserts.Equal(time.Hour, backoff(20), "delay should be capped")
+}
+
+func TestWebhooks(t *testing.T) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+
+	os.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+	defer os.Unsetenv("WEBHOOK_MAX_ATTEMPTS")
+
+	owner := newUser("hookowner")
+	other := newUser("hookother")
+	blocker := newUser("hookblocker")
+	admin := newUser("hookadmin")
+	test_db.Model(&admin).UpdateColumn("is_admin", true)
+	test_db.Create(&users.BlockModel{BlockerID: blocker.ID, BlockedID: owner.ID})
+
+	receiver := NewTestReceiver("0123456789abcdef")
+	defer receiver.Close()
+	SetClient(receiver.Client())
+	defer SetClient(nil)
+	r := newRouter(owner)
+
+	w := request(r, "POST", "/api/webhooks/", `{"webhook":{"url":"`+receiver.URL+`","events":["article.created","user.followed"],"secret":"0123456789abcdef"}}`)
+	asserts.Equal(http.StatusCreated, w.Code, "webhook should be created")
+	var created struct {
+		Webhook WebhookResponse `json:"webhook"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	asserts.Equal("0123456789abcdef", created.Webhook.Secret, "secret should be shown on creation")
+	asserts.True(created.Webhook.Active, "webhook should be active by default")
+	id := strconv.FormatUint(uint64(created.Webhook.ID), 10)
+
+	w = request(r, "POST", "/api/webhooks/", `{"webhook":{"url":"`+receiver.URL+`","events":["article.liked"]}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "unknown events should be refused")
+	w = request(r, "POST", "/api/webhooks/", `{"webhook":{"url":"ftp://example.com","events":["article.created"]}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "only http urls should be accepted")
+	w = request(r, "GET", "/api/webhooks/"+id, "")
+	asserts.NotContains(w.Body.String(), "0123456789abcdef", "secret should not be shown again")
+
+	w = request(newRouter(other), "GET", "/api/webhooks/"+id, "")
+	asserts.Equal(http.StatusNotFound, w.Code, "webhooks of others should be hidden")
+	w = request(newRouter(admin), "GET", "/api/webhooks/"+id, "")
+	asserts.Equal(http.StatusOK, w.Code, "admins should see every webhook")
+
+	events.Publish(events.ArticleCreated{ArticleID: 1, Slug: "hello", AuthorID: other.ID})
+	events.Publish(events.ArticleCreated{ArticleID: 2, Slug: "blocked", AuthorID: blocker.ID})
+	events.Publish(events.CommentCreated{CommentID: 1, ArticleID: 1, AuthorID: other.ID})
+	asserts.Equal(1, DeliverDue(ctx), "only subscribed events of visible users should be delivered")
+	received := receiver.Received()
+	asserts.Len(received, 1, "receiver should verify the delivery")
+	asserts.Equal("article.created", received[0].Event, "event header should be set")
+	asserts.Equal(received[0].Delivery, received[0].Payload["id"], "delivery header should be the event id")
+	asserts.Equal("hello", received[0].Payload["data"].(map[string]interface{})["slug"], "payload should hold the event")
+
+	receiver.FailNext(3)
+	events.Publish(events.UserFollowed{FollowerID: other.ID, FollowingID: owner.ID})
+	asserts.Equal(0, DeliverDue(ctx), "failed delivery should not count")
+	var delivery DeliveryModel
+	test_db.Where("event = ?", "user.followed").First(&delivery)
+	asserts.Equal(StatusPending, delivery.Status, "failed delivery should be retried")
+	asserts.Equal(http.StatusInternalServerError, delivery.ResponseCode, "response code should be logged")
+	asserts.True(delivery.NextAttemptAt.After(time.Now()), "retry should be delayed")
+	for i := 0; i < 2; i++ {
+		test_db.Model(&delivery).UpdateColumn("next_attempt_at", time.Now().Add(-time.Second))
+		DeliverDue(ctx)
+	}
+	test_db.First(&delivery, delivery.ID)
+	asserts.Equal(StatusFailed, delivery.Status, "delivery should be given up after the last attempt")
+	asserts.Equal(3, delivery.Attempts, "every attempt should be counted")
+
+	w = request(r, "GET", "/api/webhooks/"+id+"/deliveries?status=failed", "")
+	asserts.Equal(http.StatusOK, w.Code, "delivery log should be listed")
+	asserts.Contains(w.Body.String(), `"deliveriesCount":1`, "status filter should be applied")
+	asserts.Contains(w.Body.String(), `"lastError":"unexpected status 500"`, "errors should be logged")
+
+	deliveryURL := "/api/webhooks/" + id + "/deliveries/" + strconv.FormatUint(uint64(delivery.ID), 10)
+	w = request(r, "POST", deliveryURL+"/replay", "")
+	asserts.Equal(http.StatusAccepted, w.Code, "delivery should be replayed")
+	asserts.Equal(1, DeliverDue(ctx), "replayed delivery should be sent")
+	received = receiver.Received()
+	asserts.Equal("user.followed", received[len(received)-1].Event, "replayed event should be received")
+	asserts.Equal(delivery.EventID, received[len(received)-1].Delivery, "replay should keep the event id")
+	w = request(newRouter(other), "POST", deliveryURL+"/replay", "")
+	asserts.Equal(http.StatusNotFound, w.Code, "deliveries of others can't be replayed")
+
+	w = request(r, "PUT", "/api/webhooks/"+id, `{"webhook":{"active":false}}`)
+	asserts.Equal(http.StatusOK, w.Code, "webhook should be updated")
+	events.Publish(events.ArticleCreated{ArticleID: 3, Slug: "later", AuthorID: other.ID})
+	asserts.Equal(0, DeliverDue(ctx), "inactive webhooks should not get deliveries")
+
+	w = request(r, "DELETE", "/api/webhooks/"+id, "")
+	asserts.Equal(http.StatusOK, w.Code, "webhook should be deleted")
+	var count int
+	test_db.Model(&DeliveryModel{}).Count(&count)
+	asserts.Equal(0, count, "deliveries should be deleted with their webhook")
+}
+
+func TestPrivateEndpoints(t *testing.T) {
+	asserts := assert.New(t)
+
+	owner := newUser("hookprivate")
+	receiver := NewTestReceiver("0123456789abcdef")
+	defer receiver.Close()
+	webhookModel := WebhookModel{OwnerID: owner.ID, URL: receiver.URL, Events: "article.created", Secret: "0123456789abcdef", Active: true}
+	test_db.Create(&webhookModel)
+	defer test_db.Delete(&webhookModel)
+
+	events.Publish(events.ArticleCreated{ArticleID: 4, Slug: "internal", AuthorID: owner.ID})
+	asserts.Equal(0, DeliverDue(context.Background()), "loopback endpoints should be refused")
+	asserts.Empty(receiver.Received(), "nothing should reach the loopback endpoint")
+	var delivery DeliveryModel
+	test_db.Where("webhook_id = ?", webhookModel.ID).First(&delivery)
+	asserts.Contains(delivery.LastError, "refusing to connect to 127.0.0.1")
+	asserts.Equal(0, delivery.ResponseCode, "nothing should tell what answers on private addresses")
+}
+
+func TestEnqueueRetry(t *testing.T) {
+	asserts := assert.New(t)
+
+	owner := newUser("hookretry")
+	webhookModels := []WebhookModel{
+		{OwnerID: owner.ID, URL: "https://example.com/a", Events: "user.followed", Secret: "0123456789abcdef", Active: true},
+		{OwnerID: owner.ID, URL: "https://example.com/b", Events: "user.followed", Secret: "0123456789abcdef", Active: true},
+	}
+	for i := range webhookModels {
+		test_db.Create(&webhookModels[i])
+		defer DeleteWebhookModel(webhookModels[i])
+	}
+
+	event := events.UserFollowed{FollowerID: owner.ID, FollowingID: owner.ID}
+	asserts.NoError(enqueue(42, event))
+	// A retry of the event, after another handler failed, finds the deliveries already there.
+	asserts.NoError(enqueue(42, event))
+	var deliveries []DeliveryModel
+	test_db.Where("webhook_id IN (?)", []uint{webhookModels[0].ID, webhookModels[1].ID}).Find(&deliveries)
+	asserts.Len(deliveries, 2, "a retried event should not be delivered twice")
+	for _, delivery := range deliveries {
+		asserts.Equal("evt_42", delivery.EventID, "the event id should come from the outbox")
+	}
+
+	duplicate := DeliveryModel{WebhookID: webhookModels[0].ID, EventID: "evt_42", Event: "user.followed", Status: StatusPending}
+	asserts.Error(test_db.Create(&duplicate).Error, "a webhook should get one delivery of an event")
+	replayed, err := deliveries[0].replay()
+	asserts.NoError(err, "replays should be allowed")
+	asserts.Equal(1, replayed.Replay, "replays should be numbered")
+}
+
+func TestInactiveWebhooks(t *testing.T) {
+	asserts := assert.New(t)
+
+	owner := newUser("hookinactive")
+	receiver := NewTestReceiver("0123456789abcdef")
+	defer receiver.Close()
+	SetClient(receiver.Client())
+	defer SetClient(nil)
+	webhookModels := []WebhookModel{
+		{OwnerID: owner.ID, URL: receiver.URL, Events: "article.deleted", Secret: "0123456789abcdef", Active: true},
+		{OwnerID: owner.ID, URL: receiver.URL, Events: "article.deleted", Secret: "0123456789abcdef", Active: true},
+		{OwnerID: owner.ID, URL: receiver.URL, Events: "article.deleted", Secret: "0123456789abcdef", Active: true},
+	}
+	for i := range webhookModels {
+		test_db.Create(&webhookModels[i])
+		defer DeleteWebhookModel(webhookModels[i])
+	}
+
+	asserts.NoError(enqueue(43, events.ArticleDeleted{ArticleID: 5, Slug: "gone", AuthorID: owner.ID}))
+	test_db.Model(&webhookModels[1]).UpdateColumn("active", false)
+	test_db.Delete(&webhookModels[2])
+	asserts.Equal(1, DeliverDue(context.Background()), "only the active webhook should get the event")
+	asserts.Len(receiver.Received(), 1)
+
+	for i, status := range []string{StatusDelivered, StatusCancelled, StatusCancelled} {
+		var delivery DeliveryModel
+		test_db.Where("webhook_id = ?", webhookModels[i].ID).First(&delivery)
+		asserts.Equal(status, delivery.Status, "deliveries of deactivated and deleted webhooks should be cancelled")
+	}
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	users.AutoMigrate()
+	events.AutoMigrate()
+	AutoMigrate()
+	SubscribeEvents()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
@@ -0,0 +1,69 @@
+package webhooks
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// A secret is generated when none is given, it is only shown in the response of the creation.
+type WebhookModelValidator struct {
+	Webhook struct {
+		URL    string   `json:"url" binding:"required,url,max=1024"`
+		Events []string `json:"events" binding:"required,min=1"`
+		Secret string   `json:"secret" binding:"omitempty,min=16,max=64"`
+		Active *bool    `json:"active"`
+	} `json:"webhook"`
+	webhookModel WebhookModel `json:"-"`
+}
+
+func NewWebhookModelValidator() WebhookModelValidator {
+	return WebhookModelValidator{}
+}
+
+func NewWebhookModelValidatorFillWith(webhookModel WebhookModel) WebhookModelValidator {
+	webhookModelValidator := NewWebhookModelValidator()
+	webhookModelValidator.Webhook.URL = webhookModel.URL
+	webhookModelValidator.Webhook.Events = webhookModel.eventList()
+	webhookModelValidator.Webhook.Secret = webhookModel.Secret
+	webhookModelValidator.Webhook.Active = &webhookModel.Active
+	webhookModelValidator.webhookModel = webhookModel
+	return webhookModelValidator
+}
+
+func (s *WebhookModelValidator) Bind(c *gin.Context) error {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+
+	err := common.Bind(c, s)
+	if err != nil {
+		return err
+	}
+	if s.webhookModel.OwnerID == 0 {
+		s.webhookModel.OwnerID = myUserModel.ID
+	}
+	s.webhookModel.URL = s.Webhook.URL
+	s.webhookModel.Events = strings.Join(s.Webhook.Events, ",")
+	s.webhookModel.Secret = s.Webhook.Secret
+	if s.webhookModel.Secret == "" {
+		s.webhookModel.Secret = randomHex(32)
+	}
+	s.webhookModel.Active = s.Webhook.Active == nil || *s.Webhook.Active
+	return nil
+}
+
+// Deliveries are only posted over http(s), to events that exist.
+func (s *WebhookModelValidator) check() error {
+	if parsed, err := url.Parse(s.Webhook.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return errors.New("Url must be http or https")
+	}
+	for _, name := range s.Webhook.Events {
+		if !validEvent(name) {
+			return errors.New("Unknown event " + name)
+		}
+	}
+	return nil
+}